@@ -0,0 +1,182 @@
+package block
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/Nico2220/blockchain/utils"
+)
+
+// Archive formats written by ArchiveWriter. A JSON lines archive holds one
+// {"height", "hash", "block"} object per line. A binary archive starts with
+// the encoding version and the uvarint height of its first block, followed
+// by one record per block as in the store.
+const (
+	FORMAT_JSONL  = "jsonl"
+	FORMAT_BINARY = "binary"
+)
+
+// MAX_ARCHIVE_RECORD bounds the size of a block read from an archive.
+const MAX_ARCHIVE_RECORD = 32 << 20
+
+var ErrArchiveFormat = errors.New("malformed archive")
+
+type archiveLine struct {
+	Height int    `json:"height"`
+	Hash   string `json:"hash"`
+	Block  *Block `json:"block"`
+}
+
+// ArchiveWriter writes consecutive blocks to an archive.
+type ArchiveWriter struct {
+	w      *bufio.Writer
+	format string
+	height int
+}
+
+// NewArchiveWriter starts an archive whose first block is at height from.
+func NewArchiveWriter(w io.Writer, format string, from int) (*ArchiveWriter, error) {
+	aw := &ArchiveWriter{w: bufio.NewWriter(w), format: format, height: from}
+	switch format {
+	case FORMAT_JSONL:
+	case FORMAT_BINARY:
+		header := binary.AppendUvarint([]byte{ENCODING_VERSION}, uint64(from))
+		if _, err := aw.w.Write(header); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown archive format %q", format)
+	}
+	return aw, nil
+}
+
+// Write appends b, the block at the next height.
+func (aw *ArchiveWriter) Write(b *Block) error {
+	if !b.HasBody() {
+		return fmt.Errorf("block %d: %w", aw.height, ErrNoBody)
+	}
+	var err error
+	if aw.format == FORMAT_JSONL {
+		var line []byte
+		line, err = json.Marshal(&archiveLine{Height: aw.height, Hash: fmt.Sprintf("%x", b.Hash()), Block: b})
+		if err == nil {
+			_, err = aw.w.Write(append(line, '\n'))
+		}
+	} else {
+		encoded := b.appendBinary(nil)
+		record := binary.AppendUvarint(nil, uint64(len(encoded)))
+		_, err = aw.w.Write(append(record, encoded...))
+	}
+	aw.height++
+	return err
+}
+
+// Flush writes the buffered blocks to the underlying writer.
+func (aw *ArchiveWriter) Flush() error {
+	return aw.w.Flush()
+}
+
+// ArchiveReader reads the blocks of an archive in either format.
+type ArchiveReader struct {
+	r      *bufio.Reader
+	format string
+	height int
+}
+
+// NewArchiveReader detects the format of the archive from its first byte.
+func NewArchiveReader(r io.Reader) (*ArchiveReader, error) {
+	ar := &ArchiveReader{r: bufio.NewReader(r)}
+	first, err := ar.r.Peek(1)
+	if err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: empty file", ErrArchiveFormat)
+		}
+		return nil, err
+	}
+
+	if first[0] == '{' {
+		ar.format = FORMAT_JSONL
+		return ar, nil
+	}
+	ar.format = FORMAT_BINARY
+	if v, _ := ar.r.ReadByte(); v != ENCODING_VERSION {
+		return nil, fmt.Errorf("%w %d", ErrUnknownVersion, v)
+	}
+	from, err := binary.ReadUvarint(ar.r)
+	if err != nil || from > math.MaxInt32 {
+		return nil, fmt.Errorf("%w: bad start height", ErrArchiveFormat)
+	}
+	ar.height = int(from)
+	return ar, nil
+}
+
+// Format returns FORMAT_JSONL or FORMAT_BINARY.
+func (ar *ArchiveReader) Format() string {
+	return ar.format
+}
+
+// Next returns the next block and its height, or io.EOF after the last one.
+// Heights must follow each other and the hash of a JSON lines entry must be
+// the one of its block.
+func (ar *ArchiveReader) Next() (int, *Block, error) {
+	if ar.format == FORMAT_JSONL {
+		return ar.nextLine()
+	}
+
+	size, err := binary.ReadUvarint(ar.r)
+	if err == io.EOF {
+		return 0, nil, io.EOF
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: block %d: %v", ErrArchiveFormat, ar.height, err)
+	}
+	if size > MAX_ARCHIVE_RECORD {
+		return 0, nil, fmt.Errorf("%w: block %d is %d bytes", ErrArchiveFormat, ar.height, size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(ar.r, data); err != nil {
+		return 0, nil, fmt.Errorf("block %d: %w", ar.height, ErrTruncated)
+	}
+
+	b := &Block{}
+	d := decoder{data: data}
+	b.decode(&d)
+	if err := d.finish(); err != nil {
+		return 0, nil, fmt.Errorf("block %d: %w", ar.height, err)
+	}
+	height := ar.height
+	ar.height++
+	return height, b, nil
+}
+
+func (ar *ArchiveReader) nextLine() (int, *Block, error) {
+	line, err := ar.r.ReadBytes('\n')
+	if err == io.EOF && len(line) == 0 {
+		return 0, nil, io.EOF
+	}
+	if err != nil && err != io.EOF {
+		return 0, nil, err
+	}
+
+	var entry archiveLine
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return 0, nil, fmt.Errorf("%w: %v", ErrArchiveFormat, err)
+	}
+	if entry.Block == nil {
+		return 0, nil, fmt.Errorf("%w: block %d is missing", ErrArchiveFormat, entry.Height)
+	}
+	if ar.height > 0 && entry.Height != ar.height {
+		return 0, nil, fmt.Errorf("%w: block %d follows block %d", ErrArchiveFormat, entry.Height, ar.height-1)
+	}
+	hash, err := utils.HashFromString(entry.Hash)
+	if err != nil || hash != entry.Block.Hash() {
+		return 0, nil, fmt.Errorf("%w: block %d does not match its hash", ErrArchiveFormat, entry.Height)
+	}
+	ar.height = entry.Height + 1
+	return entry.Height, entry.Block, nil
+}
@@ -0,0 +1,87 @@
+package block
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	source := testChain(t, 4)
+	for _, format := range []string{FORMAT_JSONL, FORMAT_BINARY} {
+		var buf bytes.Buffer
+		aw, err := NewArchiveWriter(&buf, format, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, b := range source.Chain()[2:] {
+			aw.Write(b)
+		}
+		aw.Flush()
+
+		ar, err := NewArchiveReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ar.Format() != format {
+			t.Fatalf("%s archive detected as %s", format, ar.Format())
+		}
+		want := 2
+		for {
+			height, b, err := ar.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", format, err)
+			}
+			if height != want || b.Hash() != source.Chain()[height].Hash() {
+				t.Fatalf("%s: read block %d, want block %d", format, height, want)
+			}
+			want++
+		}
+		if want != len(source.Chain()) {
+			t.Fatalf("%s: read up to block %d", format, want-1)
+		}
+	}
+}
+
+func TestArchiveRejectsTampering(t *testing.T) {
+	source := testChain(t, 1)
+	var buf bytes.Buffer
+	aw, _ := NewArchiveWriter(&buf, FORMAT_JSONL, 0)
+	aw.Write(source.Chain()[0])
+	aw.Flush()
+
+	tampered := bytes.Replace(buf.Bytes(), []byte(`"nonce":0`), []byte(`"nonce":1`), 1)
+	ar, err := NewArchiveReader(bytes.NewReader(tampered))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ar.Next(); !errors.Is(err, ErrArchiveFormat) {
+		t.Fatalf("read a block that does not match its hash: %v", err)
+	}
+}
+
+func TestAddBlock(t *testing.T) {
+	source := testChain(t, 3)
+	bc := NewBlockchain(testBob, 0, testParams())
+	for height, b := range source.Chain()[1:] {
+		if err := bc.AddBlock(b); err != nil {
+			t.Fatalf("block %d: %v", height+1, err)
+		}
+	}
+	if err := bc.AddBlock(source.Chain()[3]); !errors.Is(err, ErrInvalidChain) {
+		t.Fatalf("added the tip twice: %v", err)
+	}
+
+	// An address that never received anything cannot spend.
+	overspend := bc.NewBlock(0, bc.LasBlock().Hash(), []*Transaction{NewTransactionAt("1Carol", testAlice, 5, 1)})
+	for !bc.ValidProof(overspend.nonce, overspend.previousHash, overspend.transactions, bc.params.MiningDifficulty) {
+		overspend.nonce++
+	}
+	if err := bc.AddBlock(overspend); !errors.Is(err, ErrInvalidChain) {
+		t.Fatalf("added a block spending coins that don't exist: %v", err)
+	}
+}
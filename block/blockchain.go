@@ -2,31 +2,37 @@ package block
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"math"
 	"net/http"
 	"strings"
 	"sync"
-	"time"
+	"sync/atomic"
 
+	"github.com/Nico2220/blockchain/clock"
+	"github.com/Nico2220/blockchain/events"
+	"github.com/Nico2220/blockchain/logging"
 	"github.com/Nico2220/blockchain/utils"
 )
 
-const (
-	MINING_DIFFICULTY = 3
-	MINING_SENDER     = "THE BLOCKCHAIN"
-	MINING_REWARD     = 1.0
-	MINING_TIMER      = 20
-
-	BLOCKCHAIN_PORT_RANGE_START        = 5001
-	BLOCKCHAIN_PORT_RANGE_END          = 5003
-	NEIGHBOR_IP_RANGE_START            = 0
-	NEIGHTBOR_IP_RANGE_END             = 1
-	BLOCKCHAIN_NEIGTHBOR_SYNC_TIME_SEC = 20
+var (
+	ErrStaleWork       = errors.New("stale work: the chain tip has moved")
+	ErrInvalidProof    = errors.New("nonce does not satisfy the mining difficulty")
+	ErrInvalidCoinbase = errors.New("block pays more than the subsidy")
+)
+
+var (
+	consensusLog = logging.New(logging.CONSENSUS)
+	p2pLog       = logging.New(logging.P2P)
+	mempoolLog   = logging.New(logging.MEMPOOL)
+	minerLog     = logging.New(logging.MINER)
+	storeLog     = logging.New(logging.STORE)
 )
 
 type Block struct {
@@ -34,13 +40,23 @@ type Block struct {
 	nonce        int
 	previousHash [32]byte
 	transactions []*Transaction
+
+	// A block without its body keeps the hash it can no longer compute.
+	headerOnly bool
+	hash       [32]byte
+}
+
+// NewBlock creates a block timestamped by the chain clock.
+func (bc *Blockchain) NewBlock(nonce int, previousHash [32]byte, transactions []*Transaction) *Block {
+	return NewBlockAt(nonce, previousHash, bc.now(), transactions)
 }
 
-func NewBlock(nonce int, previousHash [32]byte, transactions []*Transaction) *Block {
+// NewBlockAt creates a block with a given timestamp.
+func NewBlockAt(nonce int, previousHash [32]byte, timeStamp int64, transactions []*Transaction) *Block {
 	return &Block{
 		nonce:        nonce,
 		previousHash: previousHash,
-		timeStamp:    time.Now().UnixNano(),
+		timeStamp:    timeStamp,
 		transactions: transactions,
 	}
 }
@@ -57,9 +73,17 @@ func(b *Block) Transactions() []*Transaction {
 	return b.transactions
 }
 
+func (b *Block) Timestamp() int64 {
+	return b.timeStamp
+}
+
 
+// Hash is the sha256 of the binary encoding of the block.
 func (b *Block) Hash() [32]byte {
-	m, _ := json.Marshal(b)
+	if b.headerOnly {
+		return b.hash
+	}
+	m, _ := b.MarshalBinary()
 	return sha256.Sum256(m)
 }
 
@@ -77,27 +101,42 @@ func (b *Block) MarshalJSON() ([]byte, error) {
 	})
 }
 
-func(b *Block) UnMarshalJSON(data []byte) error{
-	var previousHash string
-	v := struct {
-		Timestamp    int64          `json:"timestamp"`
-		Nonce        int            `json:"nonce"`
-		PreviousHash string         `json:"previousHash"`
+func (b *Block) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Timestamp    *int64         `json:"timestamp"`
+		Nonce        *int           `json:"nonce"`
+		PreviousHash *string        `json:"previousHash"`
 		Transactions []*Transaction `json:"transactions"`
-	}{
-		Timestamp:    b.timeStamp,
-		Nonce:        b.nonce,
-		PreviousHash: previousHash,
-		Transactions: b.transactions,
 	}
-
 	if err := json.Unmarshal(data, &v); err != nil {
 		return err
 	}
 
-	ph, _ := hex.DecodeString(v.PreviousHash)
-	copy(b.previousHash[:], ph[:3])
+	switch {
+	case v.Timestamp == nil:
+		return fmt.Errorf("block: missing timestamp")
+	case v.Nonce == nil:
+		return fmt.Errorf("block: missing nonce")
+	case v.PreviousHash == nil:
+		return fmt.Errorf("block: missing previousHash")
+	}
+	previousHash, err := utils.HashFromString(*v.PreviousHash)
+	if err != nil {
+		return fmt.Errorf("block: previousHash: %w", err)
+	}
+	for i, t := range v.Transactions {
+		if t == nil {
+			return fmt.Errorf("block: transaction %d is null", i)
+		}
+	}
 
+	b.timeStamp = *v.Timestamp
+	b.nonce = *v.Nonce
+	b.previousHash = previousHash
+	b.transactions = v.Transactions
+	if b.transactions == nil {
+		b.transactions = []*Transaction{}
+	}
 	return nil
 }
 
@@ -110,102 +149,241 @@ func (b *Block) Print() {
 	}
 }
 
+// Blockchain is safe for concurrent use. Changes of the chain and of the
+// transaction pool are serialized by mu and only hold muState, which guards
+// the state below, while they swap it. Readers take muState for reading and
+// get copies of the slices, so a change never shows through what they hold.
 type Blockchain struct {
 	transactionPool   []*Transaction
 	chain             []*Block
 	blockchainAddress string
 	port              int
+	params            *NetworkParams
 	mu                sync.Mutex
 
 	neighbors    []string
+	peers        []string
 	muxNeighbors sync.Mutex
+
+	// client sends the requests to the neighbors.
+	client *http.Client
+
+	// clock timestamps the blocks and transactions and paces the neighbor
+	// sync and mining loops.
+	clock clock.Clock
+
+	index   *chainIndex
+	muState sync.RWMutex
+
+	events  *events.Bus
+	store   *Store
+	metrics *chainMetrics
+
+	// base is the snapshot the chain bodies start after, snapshot the latest
+	// one taken. Both are guarded by muState.
+	base     *Snapshot
+	snapshot *Snapshot
+
+	// keepBlocks is the number of recent bodies a pruned node keeps, 0
+	// when it keeps them all.
+	keepBlocks int
+
+	// The neighbor sync and mining loops run until cancel is called. They
+	// are guarded by muLoops.
+	ctx     context.Context
+	cancel  context.CancelFunc
+	loops   sync.WaitGroup
+	muLoops sync.Mutex
+	mining  atomic.Bool
 }
 
-func NewBlockchain(blockchainAddress string, port int) *Blockchain {
-	b := &Block{}
-	bc := &Blockchain{}
-	bc.CreateBlock(0, b.Hash())
+func NewBlockchain(blockchainAddress string, port int, params *NetworkParams) *Blockchain {
+	bc := &Blockchain{index: newChainIndex(), events: events.NewBus()}
+	bc.connectBlock(params.GenesisBlock())
 	bc.blockchainAddress = blockchainAddress
 	bc.port = port
+	bc.params = params
+	bc.metrics = newChainMetrics(bc)
+	bc.client = http.DefaultClient
+	bc.clock = clock.Real
 	return bc
 }
 
-func(b *Blockchain) Chain() []*Block {
-	return b.chain
+func (bc *Blockchain) Params() *NetworkParams {
+	return bc.params
 }
 
+// Chain returns a copy of the blocks from the genesis to the tip.
+func (bc *Blockchain) Chain() []*Block {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+	return append([]*Block{}, bc.chain...)
+}
+
+// SetTransport makes the requests to the neighbors go through rt instead of
+// the network, which lets tests run several nodes in one process. Call it
+// before the chain talks to its neighbors.
+func (bc *Blockchain) SetTransport(rt http.RoundTripper) {
+	bc.client = &http.Client{Transport: rt}
+}
+
+// SetClock makes the chain read the time from c, which lets tests mine
+// reproducible blocks and drive the background loops, and date its events
+// with it. Call it before Start.
+func (bc *Blockchain) SetClock(c clock.Clock) {
+	bc.clock = c
+	bc.events.SetClock(c)
+}
+
+// Clock returns the clock the chain timestamps blocks and transactions with.
+func (bc *Blockchain) Clock() clock.Clock {
+	return bc.clock
+}
+
+// now returns the time of the chain clock as a timestamp.
+func (bc *Blockchain) now() int64 {
+	return bc.clock.Now().UnixNano()
+}
+
+// SetPeers replaces the neighbor discovery with a fixed list of peers.
+func (bc *Blockchain) SetPeers(peers []string) {
+	bc.muxNeighbors.Lock()
+	defer bc.muxNeighbors.Unlock()
+	bc.peers = peers
+}
+
+// SetNeighbors uses the fixed peers as neighbors, or scans the neighbor
+// range for nodes when there are none. The scan runs without holding the
+// lock of the neighbors.
 func (bc *Blockchain) SetNeighbors() {
-	bc.neighbors = utils.FindNeighbors(utils.GetHost(), bc.port,
-		NEIGHBOR_IP_RANGE_START, NEIGHTBOR_IP_RANGE_END,
-		BLOCKCHAIN_PORT_RANGE_START,
-		BLOCKCHAIN_PORT_RANGE_END)
+	bc.muxNeighbors.Lock()
+	neighbors := bc.peers
+	bc.muxNeighbors.Unlock()
+
+	if len(neighbors) > 0 {
+		p2pLog.Debug("neighbors set", "neighbors", neighbors)
+	} else {
+		neighbors = utils.FindNeighbors(utils.GetHost(), bc.port,
+			bc.params.NeighborIPRangeStart, bc.params.NeighborIPRangeEnd,
+			bc.params.PortRangeStart,
+			bc.params.PortRangeEnd)
+		p2pLog.Debug("neighbors found", "neighbors", neighbors)
+	}
 
-	log.Printf("neighbors:%v", bc.neighbors)
+	bc.muxNeighbors.Lock()
+	bc.neighbors = neighbors
+	bc.muxNeighbors.Unlock()
 }
 
-func (bc *Blockchain) SyncNeighbors() {
+// Neighbors returns the peers the node currently talks to.
+func (bc *Blockchain) Neighbors() []string {
 	bc.muxNeighbors.Lock()
 	defer bc.muxNeighbors.Unlock()
-	bc.SetNeighbors()
+	return append([]string{}, bc.neighbors...)
 }
 
-func (bc *Blockchain) StartSyncNeighbors() {
-	bc.SyncNeighbors()
+// IsMining reports whether StartMining was called.
+func (bc *Blockchain) IsMining() bool {
+	return bc.mining.Load()
+}
 
-	time.AfterFunc(BLOCKCHAIN_NEIGTHBOR_SYNC_TIME_SEC*time.Second, bc.StartSyncNeighbors)
+// BlockchainAddress is the address the node mines to.
+func (bc *Blockchain) BlockchainAddress() string {
+	return bc.blockchainAddress
 }
-func (bc *Blockchain) Run() {
-	bc.StartSyncNeighbors()
-	bc.ResolveConfilcts()
+
+func (bc *Blockchain) SyncNeighbors() {
+	bc.SetNeighbors()
 }
 
+// TransactionPool returns a copy of the pending transactions.
 func (bc *Blockchain) TransactionPool() []*Transaction {
-	return bc.transactionPool
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+	return append([]*Transaction{}, bc.transactionPool...)
 }
 
-func (bc *Blockchain) MarsalJSON() ([]byte, error) {
+func (bc *Blockchain) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Blocks []*Block `json:"chain"`
 	}{
-		Blocks: bc.chain,
+		Blocks: bc.Chain(),
 	})
 }
 
-func(bc *Blockchain) UnMarsalJSON(data []byte) error {
-	v := struct {
-		Blocks []*Block `json:"chain"`
-	}{
-		Blocks: bc.chain,
-	}
-
-	err := json.Unmarshal(data, &v)
+// UnmarshalJSON replaces the chain with the decoded one and indexes it. It
+// only checks the encoding: use ImportChain to load a chain that must be
+// valid.
+func (bc *Blockchain) UnmarshalJSON(data []byte) error {
+	blocks, err := DecodeChainJSON(data)
 	if err != nil {
 		return err
 	}
+	if len(blocks) == 0 {
+		return ErrEmptyChain
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.muState.Lock()
+	defer bc.muState.Unlock()
 
+	bc.chain = blocks
+	bc.index = newChainIndex()
+	for height, b := range blocks {
+		bc.index.connect(b, height)
+	}
+	if bc.events == nil {
+		bc.events = events.NewBus()
+	}
+	if bc.metrics == nil {
+		bc.metrics = newChainMetrics(bc)
+	}
+	if bc.client == nil {
+		bc.client = http.DefaultClient
+	}
+	if bc.clock == nil {
+		bc.clock = clock.Real
+	}
 	return nil
 }
 
+// CreateBlock connects a block holding the whole transaction pool, which
+// leaves the pool empty.
 func (bc *Blockchain) CreateBlock(nonce int, previousHash [32]byte) *Block {
-	b := NewBlock(nonce, previousHash, bc.transactionPool)
-	bc.chain = append(bc.chain, b)
-	bc.transactionPool = []*Transaction{}
-	for _, n := range bc.neighbors {
-		endpoint := fmt.Sprintf("http://%s/transactions", n)
-		client := http.Client{}
-		req, _ := http.NewRequest(http.MethodDelete, endpoint, nil)
-		resp, _ := client.Do(req)
-		fmt.Printf("resp:%v", resp)
-	}
+	bc.mu.Lock()
+	b := NewBlockAt(nonce, previousHash, bc.now(), bc.transactionPool)
+	bc.connectBlock(b)
+	bc.mu.Unlock()
 	return b
 }
 
+// broadcast sends an empty request to the same endpoint on every neighbor.
+func (bc *Blockchain) broadcast(method, path string) {
+	for _, n := range bc.Neighbors() {
+		endpoint := fmt.Sprintf("http://%s%s", n, path)
+		req, _ := http.NewRequest(method, endpoint, nil)
+		resp, err := bc.client.Do(req)
+		if err != nil || resp.StatusCode >= http.StatusBadRequest {
+			bc.metrics.neighborFailures.Inc(strings.TrimPrefix(path, "/"))
+		}
+		if err != nil {
+			p2pLog.Warn("broadcast failed", "neighbor", n, "method", method, "path", path, "err", err)
+			continue
+		}
+		resp.Body.Close()
+		p2pLog.Debug("broadcast", "neighbor", n, "method", method, "path", path, "status", resp.StatusCode)
+	}
+}
+
 func (bc *Blockchain) LasBlock() *Block {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
 	return bc.chain[len(bc.chain)-1]
 }
 
 func (bc *Blockchain) Print() {
-	for i, block := range bc.chain {
+	for i, block := range bc.Chain() {
 		fmt.Printf("%s chain %d %s\n ", strings.Repeat("=", 25), i, strings.Repeat("=", 25))
 		block.Print()
 	}
@@ -214,10 +392,16 @@ func (bc *Blockchain) Print() {
 }
 
 func (bc *Blockchain) CreateTransaction(sender, recipient string, value float32, senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
-	isTransacted := bc.AddTransaction(sender, recipient, value, senderPublicKey, s)
-	client := http.Client{}
+	return bc.CreateTransactionAt(sender, recipient, value, bc.now(), senderPublicKey, s)
+}
+
+// CreateTransactionAt is CreateTransaction with a timestamp chosen by the
+// caller, which then knows the ID of the transaction in advance.
+func (bc *Blockchain) CreateTransactionAt(sender, recipient string, value float32, timeStamp int64, senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
+	t := NewTransactionAt(sender, recipient, value, timeStamp)
+	isTransacted := bc.addTransaction(t, senderPublicKey, s)
 	if isTransacted {
-		for _, n := range bc.neighbors {
+		for _, n := range bc.Neighbors() {
 			publicKeyStr := fmt.Sprintf("%064x%064x", senderPublicKey.X.Bytes(), senderPublicKey.Y.Bytes())
 			signatureStr := s.String()
 			tr := TransactionRequest{
@@ -226,14 +410,23 @@ func (bc *Blockchain) CreateTransaction(sender, recipient string, value float32,
 				SenderPublicKey:          &publicKeyStr,
 				Value:                    &value,
 				Signature:                &signatureStr,
+				Timestamp:                &timeStamp,
 			}
 			m, _ := json.Marshal(tr)
 			endpoint := fmt.Sprintf("http://%s/transactions", n)
 			buf := bytes.NewBuffer(m)
 			
 			req, _ := http.NewRequest(http.MethodPut, endpoint, buf)
-			resp, _ := client.Do(req)
-			fmt.Printf("resp:%v", resp)
+			resp, err := bc.client.Do(req)
+			if err != nil || resp.StatusCode >= http.StatusBadRequest {
+				bc.metrics.neighborFailures.Inc(ENDPOINT_TRANSACTIONS)
+			}
+			if err != nil {
+				p2pLog.Warn("transaction relay failed", "neighbor", n, "err", err)
+				continue
+			}
+			resp.Body.Close()
+			p2pLog.Debug("transaction relayed", "neighbor", n, "status", resp.StatusCode)
 
 		}
 	}
@@ -242,46 +435,122 @@ func (bc *Blockchain) CreateTransaction(sender, recipient string, value float32,
 }
 
 func (bc *Blockchain) ClearTransactionPool() {
-	bc.transactionPool = bc.transactionPool[:0]
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	height := len(bc.chain) - 1
+	for _, t := range bc.transactionPool {
+		bc.events.Publish(events.Event{
+			Type:      events.TX_EVICTED,
+			Height:    height,
+			Hash:      fmt.Sprintf("%x", t.Hash()),
+			Addresses: t.Addresses(),
+			Data:      t,
+		})
+	}
+	bc.muState.Lock()
+	bc.transactionPool = []*Transaction{}
+	bc.muState.Unlock()
 }
 
 func (bc *Blockchain) AddTransaction(sender, recipient string, value float32, senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
-	t := NewTransaction(sender, recipient, value)
+	return bc.addTransaction(NewTransactionAt(sender, recipient, value, bc.now()), senderPublicKey, s)
+}
+
+// AddTransactionAt adds a transaction relayed by a peer, keeping the
+// timestamp, and so the ID, it was created with.
+func (bc *Blockchain) AddTransactionAt(sender, recipient string, value float32, timeStamp int64, senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
+	return bc.addTransaction(NewTransactionAt(sender, recipient, value, timeStamp), senderPublicKey, s)
+}
+
+// addTransaction adds t to the transaction pool when it is signed by its
+// sender. The signature is checked before taking the chain.
+func (bc *Blockchain) addTransaction(t *Transaction, senderPublicKey *ecdsa.PublicKey, s *utils.Signature) bool {
+	if t.senderBlockchainAddress != bc.params.MiningSender && !bc.VerifyTransactionSignature(senderPublicKey, s, t) {
+		mempoolLog.Debug("transaction rejected", "id", fmt.Sprintf("%x", t.Hash()), "reason", "invalid signature", "sender", t.senderBlockchainAddress)
+		return false
+	}
+	return bc.admitTransaction(t)
+}
+
+// admitTransaction adds t to the transaction pool unless it is already known
+// or its sender can't afford it. Its signature was checked before.
+func (bc *Blockchain) admitTransaction(t *Transaction) bool {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	sender, value := t.senderBlockchainAddress, t.value
 
-	if sender == MINING_SENDER {
-		bc.transactionPool = append(bc.transactionPool, t)
+	if bc.hasTransaction(t.Hash()) {
+		mempoolLog.Debug("transaction rejected", "id", fmt.Sprintf("%x", t.Hash()), "reason", "already known")
+		return false
+	}
+
+	if sender == bc.params.MiningSender {
+		bc.appendTransaction(t)
 		return true
 	}
 
-	// if bc.VerifyTransactionSignature(senderPublicKey, s, t) {
-	// 	// if bc.CalculateTotalAmount(sender) < value{
-	// 	// 	log.Println("ERROR:", "Not wnougth balance in wallet")
-	// 	// 	return false
-	// 	// }
-	// 	bc.transactionPool = append(bc.transactionPool, t)
-	// 	return true
-	// }
+	if !validValue(value) {
+		mempoolLog.Debug("transaction rejected", "id", fmt.Sprintf("%x", t.Hash()), "reason", "value must be positive and finite")
+		return false
+	}
 
-	bc.transactionPool = append(bc.transactionPool, t)
+	if bc.calculateBalance(sender).Spendable-bc.pendingSpend(sender) < value {
+		mempoolLog.Debug("transaction rejected", "id", fmt.Sprintf("%x", t.Hash()), "reason", "not enough spendable balance", "sender", sender)
+		return false
+	}
+
+	bc.appendTransaction(t)
+	mempoolLog.Debug("transaction added", "id", fmt.Sprintf("%x", t.Hash()), "sender", sender, "value", value)
+	bc.events.Publish(events.Event{
+		Type:      events.NEW_PENDING_TX,
+		Height:    len(bc.chain) - 1,
+		Hash:      fmt.Sprintf("%x", t.Hash()),
+		Addresses: t.Addresses(),
+		Data:      t,
+	})
 
 	return true
+}
 
-	// log.Println("ERROR:", "Verify transaction")
+// appendTransaction adds t to the transaction pool. The caller holds mu.
+func (bc *Blockchain) appendTransaction(t *Transaction) {
+	bc.muState.Lock()
+	bc.transactionPool = append(bc.transactionPool, t)
+	bc.muState.Unlock()
+}
 
-	// return false
+// pendingSpend returns what sender already spends in the transaction pool.
+// The caller holds mu.
+func (bc *Blockchain) pendingSpend(sender string) float32 {
+	var spent float32
+	for _, t := range bc.transactionPool {
+		if t.senderBlockchainAddress == sender {
+			spent += t.value
+		}
+	}
+	return spent
 }
 
+// VerifyTransactionSignature reports whether s is the signature of t by the
+// key of its sender: senderPublicKey must be the key of the sender address
+// and have signed the ID of t, see Sign.
 func (bc *Blockchain) VerifyTransactionSignature(
 	senderPublicKey *ecdsa.PublicKey, s *utils.Signature, t *Transaction) bool {
-	m, _ := json.Marshal(t)
-	h := sha256.Sum256([]byte(m))
+	if senderPublicKey == nil || s == nil || s.R == nil || s.S == nil {
+		return false
+	}
+	if utils.AddressFromPublicKey(senderPublicKey) != t.senderBlockchainAddress {
+		return false
+	}
+	h := t.Hash()
 	return ecdsa.Verify(senderPublicKey, h[:], s.R, s.S)
 }
 
 func (bc *Blockchain) Copytransactions() []*Transaction {
 	transactions := make([]*Transaction, 0)
-	for _, t := range bc.transactionPool {
-		transactions = append(transactions, NewTransaction(t.senderBlockchainAddress, t.recipientBlockchainAddress, t.value))
+	for _, t := range bc.TransactionPool() {
+		transactions = append(transactions, NewTransactionAt(t.senderBlockchainAddress, t.recipientBlockchainAddress, t.value, t.timeStamp))
 
 	}
 	return transactions
@@ -295,47 +564,125 @@ func (bc *Blockchain) ValidProof(nonce int, previousHash [32]byte, transactions
 }
 
 func (bc *Blockchain) ProofOfWork() int {
-	transactions := bc.Copytransactions()
-	previousHash := bc.LasBlock().Hash()
+	return bc.proofOfWork(bc.LasBlock().Hash(), bc.Copytransactions())
+}
+
+// proofOfWork finds the nonce of a block of transactions on top of
+// previousHash.
+func (bc *Blockchain) proofOfWork(previousHash [32]byte, transactions []*Transaction) int {
 	nonce := 0
-	for !bc.ValidProof(nonce, previousHash, transactions, MINING_DIFFICULTY) {
+	start := bc.clock.Now()
+	for !bc.ValidProof(nonce, previousHash, transactions, bc.params.MiningDifficulty) {
 		nonce += 1
 	}
+	if elapsed := bc.clock.Now().Sub(start).Seconds(); elapsed > 0 {
+		bc.metrics.hashrate.Set(float64(nonce+1) / elapsed)
+	}
+	bc.metrics.hashes.Add(float64(nonce + 1))
 	return nonce
 }
 
+// Mining mines a block with the transaction pool and the subsidy for the
+// node. The proof of work runs without holding the chain, so transactions
+// keep coming in meanwhile; the block is dropped when another one was
+// connected first.
 func (bc *Blockchain) Mining() bool {
-	bc.mu.Lock()
-	defer bc.mu.Unlock()
+	bc.muState.RLock()
+	height := len(bc.chain)
+	previousHash := bc.chain[height-1].Hash()
+	transactions := append([]*Transaction{}, bc.transactionPool...)
+	bc.muState.RUnlock()
 
-	if len(bc.transactionPool) == 0 {
+	if len(transactions) == 0 {
 		return false
 	}
+	if subsidy := bc.params.Subsidy(height); subsidy > 0 {
+		transactions = append(transactions, NewTransactionAt(bc.params.MiningSender, bc.blockchainAddress, subsidy, bc.now()))
+	}
+	nonce := bc.proofOfWork(previousHash, transactions)
 
-	bc.AddTransaction(MINING_SENDER, bc.blockchainAddress, MINING_REWARD, nil, nil)
-	nonce := bc.ProofOfWork()
-	previousHash := bc.LasBlock().previousHash
-	bc.CreateBlock(nonce, previousHash)
-	log.Println("action=MINING", "status=success")
-	for _, n := range bc.neighbors {
-		endpoint := fmt.Sprintf("http://%s/consensus", n)
-		client := &http.Client{}
-		req, _ := http.NewRequest(http.MethodPut, endpoint, nil)
-		response, _ := client.Do(req)
-		fmt.Printf("%v", response)
-
+	bc.mu.Lock()
+	if previousHash != bc.chain[len(bc.chain)-1].Hash() {
+		bc.mu.Unlock()
+		bc.metrics.blocksRejected.Inc(REJECT_STALE)
+		minerLog.Info("mined block is stale", "height", height, "nonce", nonce)
+		return false
 	}
+	b := NewBlockAt(nonce, previousHash, bc.now(), transactions)
+	bc.connectBlock(b)
+	bc.mu.Unlock()
+
+	bc.metrics.blocksMined.Inc("node")
+	minerLog.Info("block mined", "height", height, "hash", fmt.Sprintf("%x", b.Hash()), "nonce", nonce)
+	bc.broadcast(http.MethodPut, "/consensus")
 	return true
 }
 
-func (bc *Blockchain) StartMining() {
-	bc.Mining()
-	_ = time.AfterFunc(time.Second*MINING_TIMER, bc.StartMining)
+// MiningWork returns the previous hash and the transactions an external miner
+// has to find a nonce for. Unless the subsidy has run out, the last
+// transaction pays it to rewardAddress.
+func (bc *Blockchain) MiningWork(rewardAddress string) ([32]byte, []*Transaction) {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+
+	transactions := make([]*Transaction, 0, len(bc.transactionPool)+1)
+	transactions = append(transactions, bc.transactionPool...)
+	if subsidy := bc.params.Subsidy(len(bc.chain)); subsidy > 0 {
+		transactions = append(transactions, NewTransactionAt(bc.params.MiningSender, rewardAddress, subsidy, bc.now()))
+	}
+	return bc.chain[len(bc.chain)-1].Hash(), transactions
+}
+
+// SubmitWork appends the block solved by an external miner. The work must
+// still build on the current tip, the nonce must meet the network mining
+// difficulty, the block may not pay more than the subsidy and its transfers
+// are checked like those of a block from a peer. The mined transactions are
+// removed from the transaction pool. It returns the block with the height
+// and hash it was connected at, which a later block may already have built
+// on by the time the caller reads the chain.
+func (bc *Blockchain) SubmitWork(nonce int, previousHash [32]byte, transactions []*Transaction) (*BlockInfo, error) {
+	bc.mu.Lock()
+	b := NewBlockAt(nonce, previousHash, bc.now(), transactions)
+	var err error
+	switch {
+	case previousHash != bc.chain[len(bc.chain)-1].Hash():
+		err = ErrStaleWork
+	case !bc.ValidProof(nonce, previousHash, transactions, bc.params.MiningDifficulty):
+		err = ErrInvalidProof
+	case !bc.ValidCoinbase(len(bc.chain), transactions):
+		err = ErrInvalidCoinbase
+	default:
+		err = bc.checkBlock(b)
+	}
+	if err != nil {
+		bc.mu.Unlock()
+		bc.metrics.blocksRejected.Inc(rejectReason(err))
+		return nil, err
+	}
+
+	bc.connectBlock(b)
+	info := &BlockInfo{Height: len(bc.chain) - 1, Hash: b.Hash(), Confirmations: 1, Block: b}
+	bc.mu.Unlock()
+
+	bc.metrics.blocksMined.Inc("pool")
+	minerLog.Info("work submitted", "height", info.Height, "hash", fmt.Sprintf("%x", info.Hash), "nonce", nonce)
+	bc.broadcast(http.MethodPut, "/consensus")
+	return info, nil
 }
 
 func (bc *Blockchain) CalculateTotalAmount(blockchainAddress string) float32 {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+
 	var totalAmount float32 = 0.0
-	for _, c := range bc.chain {
+	chain := bc.chain
+	if bc.base != nil {
+		if a := bc.base.Account(blockchainAddress); a != nil {
+			totalAmount = a.Balance
+		}
+		chain = chain[bc.base.Height+1:]
+	}
+	for _, c := range chain {
 		for _, t := range c.transactions {
 			value := t.value
 			if blockchainAddress == t.recipientBlockchainAddress {
@@ -350,96 +697,342 @@ func (bc *Blockchain) CalculateTotalAmount(blockchainAddress string) float32 {
 	return totalAmount
 }
 
-func(bc *Blockchain) ValidChain(chain []*Block) bool{
-	prevBlock := chain[0]
-	currentIndex := 1
-	for currentIndex < len(chain) {
-		currentBlock := chain[currentIndex]
-		if currentBlock.PreviousHash() != prevBlock.Hash() {
-			return false
+// ValidCoinbase reports whether the transactions of the block at height pay
+// no more than the subsidy of that height, each with a positive value.
+func (bc *Blockchain) ValidCoinbase(height int, transactions []*Transaction) bool {
+	if height == 0 {
+		return true
+	}
+
+	var paid float32
+	for _, t := range transactions {
+		if t.senderBlockchainAddress == bc.params.MiningSender {
+			if !validValue(t.value) {
+				return false
+			}
+			paid += t.value
+		}
+	}
+	return paid <= bc.params.Subsidy(height)
+}
+
+// validValue reports whether value can be transferred: a finite amount above
+// zero. NaN and the infinities would break every balance they reach.
+func validValue(value float32) bool {
+	return value > 0 && value <= math.MaxFloat32
+}
+
+type Supply struct {
+	Height             int     `json:"height"`
+	Circulating        float64 `json:"circulating_supply"`
+	MaxSupply          float64 `json:"max_supply"`
+	Subsidy            float32 `json:"current_subsidy"`
+	NextHalvingHeight  int     `json:"next_halving_height"`
+	BlocksUntilHalving int     `json:"blocks_until_halving"`
+}
+
+// Supply reports the coins created so far and the emission schedule from the
+// next block on.
+func (bc *Blockchain) Supply() *Supply {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+
+	var circulating float64
+	chain := bc.chain
+	if bc.base != nil {
+		circulating = bc.base.Supply
+		chain = chain[bc.base.Height+1:]
+	}
+	for _, b := range chain {
+		for _, t := range b.transactions {
+			if t.senderBlockchainAddress == bc.params.MiningSender {
+				circulating += float64(t.value)
+			}
 		}
+	}
 
-		// if !bc.ValidProof(currentBlock.Nonce(), currentBlock.PreviousHash(), currentBlock.Transactions(), MINING_DIFFICULTY){
-		// 	return false
-		// }
+	next := len(bc.chain)
+	s := &Supply{
+		Height:            next - 1,
+		Circulating:       circulating,
+		MaxSupply:         bc.params.MaxSupply(),
+		Subsidy:           bc.params.Subsidy(next),
+		NextHalvingHeight: bc.params.NextHalving(next),
+	}
+	if s.NextHalvingHeight > 0 {
+		s.BlocksUntilHalving = s.NextHalvingHeight - next
+	}
+	return s
+}
+
+// Balance splits the confirmed balance of an address between what it can
+// spend and the block rewards that haven't reached CoinbaseMaturity yet.
+type Balance struct {
+	Amount    float32 `json:"amount"`
+	Spendable float32 `json:"spendable"`
+	Immature  float32 `json:"immature"`
+}
 
-		prevBlock = currentBlock
-		currentIndex++
+// IsMature reports whether a block reward paid at height can be spent on top
+// of the current chain. Genesis allocations are always spendable.
+func (bc *Blockchain) IsMature(height int) bool {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+	return bc.isMature(height)
+}
+
+func (bc *Blockchain) isMature(height int) bool {
+	return height == 0 || len(bc.chain)-1-height >= bc.params.CoinbaseMaturity
+}
+
+func (bc *Blockchain) CalculateBalance(blockchainAddress string) *Balance {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+	return bc.calculateBalance(blockchainAddress)
+}
+
+// calculateBalance is CalculateBalance for callers that hold mu or muState.
+func (bc *Blockchain) calculateBalance(blockchainAddress string) *Balance {
+	balance := &Balance{}
+	start := 0
+	if bc.base != nil {
+		if a := bc.base.Account(blockchainAddress); a != nil {
+			balance.Amount = a.Balance
+			for _, r := range a.Rewards {
+				if !bc.isMature(r.Height) {
+					balance.Immature += r.Amount
+				}
+			}
+		}
+		start = bc.base.Height + 1
+	}
+	for height := start; height < len(bc.chain); height++ {
+		c := bc.chain[height]
+		for _, t := range c.transactions {
+			if blockchainAddress == t.recipientBlockchainAddress {
+				balance.Amount += t.value
+				if t.senderBlockchainAddress == bc.params.MiningSender && !bc.isMature(height) {
+					balance.Immature += t.value
+				}
+			}
+			if blockchainAddress == t.senderBlockchainAddress {
+				balance.Amount -= t.value
+			}
+		}
 	}
 
-	return true
+	balance.Spendable = balance.Amount - balance.Immature
+	return balance
+}
+
+func (bc *Blockchain) ValidChain(chain []*Block) bool {
+	return bc.VerifyChain(chain) == nil
 }
 
+// ResolveConfilcts switches to the longest valid chain of the neighbors when
+// it is longer than ours. The chains are fetched and verified without
+// holding the chain, which is then checked again before switching. The best
+// peer height that SyncLag is measured against is only updated when a
+// neighbor answered with a valid chain.
 func(bc *Blockchain) ResolveConfilcts() bool{
 	var longuestChain []*Block  = nil
 	maxLength := len(longuestChain)
-	for _, n := range bc.neighbors {
-		endpoint := fmt.Sprintf("http://%s/chain", n)
-		response, _ := http.Get(endpoint)
-		if response.StatusCode == http.StatusOK {
-			var bcResp Blockchain
-			_ = json.NewDecoder(response.Body).Decode(&bcResp)
-			
-			chain := bcResp.Chain()
-			//&& bc.ValidChain(chain)
-			if len(chain) > maxLength  {
-				longuestChain = chain
-				maxLength = len(chain)
-			}
+	from := bc.FirstBody()
+	base := bc.Base()
+	bestPeerHeight := 0
+	answered := false
+	for _, n := range bc.Neighbors() {
+		chain, err := bc.fetchChain(n, from)
+		if err != nil {
+			bc.metrics.neighborFailures.Inc(ENDPOINT_CHAIN)
+			p2pLog.Warn("cannot fetch the chain", "neighbor", n, "err", err)
+			continue
+		}
+		if len(chain) <= maxLength {
+			// Shorter than a valid chain already fetched: the neighbor
+			// answered but can't be the best peer.
+			answered = true
+			continue
 		}
+		if err := bc.VerifyChain(chain); err != nil {
+			bc.metrics.blocksRejected.Inc(REJECT_INVALID)
+			consensusLog.Warn("invalid chain", "neighbor", n, "err", err)
+			continue
+		}
+		longuestChain = chain
+		maxLength = len(chain)
+		bestPeerHeight = len(chain) - 1
+		answered = true
+	}
+	bc.muState.Lock()
+	bc.metrics.neighborsAnswered = answered
+	if answered {
+		bc.metrics.bestPeerHeight = bestPeerHeight
 	}
+	bc.muState.Unlock()
 
-	if longuestChain != nil {
-		bc.chain = longuestChain
-		fmt.Println("conflic resolve, longest chain:", longuestChain)
-		return true
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if longuestChain == nil || len(longuestChain) <= len(bc.chain) {
+		return false
 	}
+	// Pruning meanwhile moves the base the chain was verified against.
+	if bc.base != base {
+		if err := bc.VerifyChain(longuestChain); err != nil {
+			consensusLog.Warn("chain no longer valid", "err", err)
+			return false
+		}
+	}
+	bc.replaceChain(longuestChain)
+	consensusLog.Info("conflict resolved", "height", len(longuestChain)-1, "hash", fmt.Sprintf("%x", bc.chain[len(bc.chain)-1].Hash()))
+	return true
+}
 
-	return false
+// fetchChain downloads the chain of a neighbor, in the binary encoding unless
+// it only speaks JSON. A node synced from a snapshot only asks for the blocks
+// from height from on and completes them with its own headers.
+func (bc *Blockchain) fetchChain(neighbor string, from int) ([]*Block, error) {
+	url := fmt.Sprintf("http://%s/chain", neighbor)
+	if from > 0 {
+		url += fmt.Sprintf("?from=%d", from)
+	}
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Accept", BINARY_CONTENT_TYPE)
+	response, err := bc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusGone {
+		var limits Limits
+		json.NewDecoder(response.Body).Decode(&limits)
+		return nil, fmt.Errorf("%w: the neighbor only has the blocks from height %d", ErrNoBody, limits.FirstAvailable)
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("chain request answered %s", response.Status)
+	}
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	var chain []*Block
+	if response.Header.Get("Content-Type") == BINARY_CONTENT_TYPE {
+		chain, err = DecodeChain(data)
+	} else {
+		chain, err = DecodeChainJSON(data)
+	}
+	if err != nil || from == 0 || len(chain) == 0 || chain[0].Hash() == bc.params.GenesisBlock().Hash() {
+		return chain, err
+	}
+
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+	if from > len(bc.chain) {
+		return nil, fmt.Errorf("chain from height %d does not follow ours", from)
+	}
+	return append(append([]*Block{}, bc.chain[:from]...), chain...), nil
 }
 
 type Transaction struct {
 	senderBlockchainAddress    string
 	recipientBlockchainAddress string
 	value                      float32
+	timeStamp                  int64
+}
+
+// NewTransaction creates a transaction timestamped by the chain clock.
+func (bc *Blockchain) NewTransaction(senderBlockchainAddress, recipientBlockchainAdress string, value float32) *Transaction {
+	return NewTransactionAt(senderBlockchainAddress, recipientBlockchainAdress, value, bc.now())
 }
 
-func NewTransaction(senderBlockchainAddress, recipientBlockchainAdress string, value float32) *Transaction {
+// NewTransactionAt creates a transaction with a given timestamp, for
+// transactions received from a peer that must keep their ID.
+func NewTransactionAt(senderBlockchainAddress, recipientBlockchainAdress string, value float32, timeStamp int64) *Transaction {
 	return &Transaction{
 		senderBlockchainAddress,
 		recipientBlockchainAdress,
 		value,
+		timeStamp,
 	}
 }
 
+// Hash is the ID of the transaction, the sha256 of its binary encoding.
+func (t *Transaction) Hash() [32]byte {
+	m, _ := t.MarshalBinary()
+	return sha256.Sum256(m)
+}
+
+// Sign signs the ID of the transaction with the key of its sender, drawing
+// the nonce of the signature from rand. The ID covers every field, the
+// timestamp included, so a signature can't be replayed on a transaction
+// sent at another time.
+func (t *Transaction) Sign(privateKey *ecdsa.PrivateKey, rand io.Reader) (*utils.Signature, error) {
+	h := t.Hash()
+	r, s, err := ecdsa.Sign(rand, privateKey, h[:])
+	if err != nil {
+		return nil, err
+	}
+	return &utils.Signature{R: r, S: s}, nil
+}
+
+func (t *Transaction) Timestamp() int64 {
+	return t.timeStamp
+}
+
+func (t *Transaction) SenderBlockchainAddress() string {
+	return t.senderBlockchainAddress
+}
+
+func (t *Transaction) RecipientBlockchainAddress() string {
+	return t.recipientBlockchainAddress
+}
+
+func (t *Transaction) Value() float32 {
+	return t.value
+}
+
 func (t *Transaction) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Sender    string  `json:"sender_blochain_address"`
 		Recipient string  `json:"recipient_blockchain_address"`
 		Value     float32 `json:"value"`
+		Timestamp int64   `json:"timestamp"`
 	}{
 		Sender:    t.senderBlockchainAddress,
 		Recipient: t.recipientBlockchainAddress,
 		Value:     t.value,
+		Timestamp: t.timeStamp,
 	})
 
 }
 
-func(t *Transaction) UnMarshalJSON(data []byte) error {
-	v := struct {
+func (t *Transaction) UnmarshalJSON(data []byte) error {
+	var v struct {
 		Sender    *string  `json:"sender_blochain_address"`
 		Recipient *string  `json:"recipient_blockchain_address"`
 		Value     *float32 `json:"value"`
-	}{
-		Sender:    &t.senderBlockchainAddress,
-		Recipient: &t.recipientBlockchainAddress,
-		Value:     &t.value,
+		Timestamp *int64   `json:"timestamp"`
 	}
-
 	if err := json.Unmarshal(data, &v); err != nil {
-		return nil
+		return err
 	}
 
+	switch {
+	case v.Sender == nil:
+		return fmt.Errorf("transaction: missing sender_blochain_address")
+	case v.Recipient == nil:
+		return fmt.Errorf("transaction: missing recipient_blockchain_address")
+	case v.Value == nil:
+		return fmt.Errorf("transaction: missing value")
+	case v.Timestamp == nil:
+		return fmt.Errorf("transaction: missing timestamp")
+	}
+
+	t.senderBlockchainAddress = *v.Sender
+	t.recipientBlockchainAddress = *v.Recipient
+	t.value = *v.Value
+	t.timeStamp = *v.Timestamp
 	return nil
 }
 
@@ -457,6 +1050,7 @@ type TransactionRequest struct {
 	SenderPublicKey          *string  `json:"sender_public_key"`
 	Value                    *float32 `json:"value"`
 	Signature                *string  `json:"signature"`
+	Timestamp                *int64   `json:"timestamp,omitempty"`
 }
 
 func (t *TransactionRequest) Validate() map[string]string {
@@ -481,9 +1075,32 @@ func (t *TransactionRequest) Validate() map[string]string {
 	if t.Signature == nil {
 		mapError["signature"] = errorText
 	}
+
+	// The signature covers the timestamp, so the node can't choose it.
+	if t.Timestamp == nil {
+		mapError["timestamp"] = errorText
+	}
+
+	if t.SenderPublicKey != nil {
+		if _, err := utils.PublickKeyFromString(*t.SenderPublicKey); err != nil {
+			mapError["sender_public_key"] = err.Error()
+		}
+	}
+	if t.Signature != nil {
+		if _, err := utils.SignatureFromString(*t.Signature); err != nil {
+			mapError["signature"] = err.Error()
+		}
+	}
 	return mapError
 }
 
+type HistoryResponse struct {
+	History []*HistoryEntry `json:"history"`
+	Total   int             `json:"total"`
+}
+
 type AmountResponse struct {
-	Amount float32 `json:"amount"`
+	Amount    float32 `json:"amount"`
+	Spendable float32 `json:"spendable"`
+	Immature  float32 `json:"immature"`
 }
@@ -0,0 +1,201 @@
+package block
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrEmptyChain      = errors.New("empty chain")
+	ErrGenesisMismatch = errors.New("genesis block does not match the network")
+	ErrInvalidChain    = errors.New("invalid chain")
+	ErrShorterChain    = errors.New("chain is not longer than the current one")
+)
+
+// DecodeChainJSON decodes the {"chain": [...]} document served by /chain and
+// produced by Blockchain.MarshalJSON.
+func DecodeChainJSON(data []byte) ([]*Block, error) {
+	var v struct {
+		Blocks []*Block `json:"chain"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	for height, b := range v.Blocks {
+		if b == nil {
+			return nil, fmt.Errorf("block %d is null", height)
+		}
+	}
+	return v.Blocks, nil
+}
+
+// VerifyChain checks that chain starts with the genesis block of the network
+// and that every block links to the previous one and passes the checks of
+// AddBlock on top of the blocks before it: it meets the mining difficulty,
+// pays no more than the subsidy, repeats no transaction and spends only
+// positive amounts of confirmed, spendable coins. On a node synced from a
+// snapshot the blocks up to the snapshot may be headers, the one at its
+// height must be the block of the snapshot, and the balances start from the
+// snapshot.
+func (bc *Blockchain) VerifyChain(chain []*Block) error {
+	if len(chain) == 0 {
+		return ErrEmptyChain
+	}
+	if chain[0].Hash() != bc.params.GenesisBlock().Hash() {
+		return ErrGenesisMismatch
+	}
+	base := bc.Base()
+	if base != nil && len(chain) <= base.Height {
+		return fmt.Errorf("%w: chain ends before the snapshot at height %d", ErrInvalidChain, base.Height)
+	}
+
+	// The blocks are replayed on a chain of their own, which nothing else
+	// sees, so checkBlock needs no lock.
+	replay := &Blockchain{params: bc.params, index: newChainIndex(), base: base}
+	replay.chain = append(replay.chain, chain[0])
+	replay.index.connect(chain[0], 0)
+	for height := 1; height < len(chain); height++ {
+		b := chain[height]
+		if base != nil && height <= base.Height {
+			if b.PreviousHash() != chain[height-1].Hash() {
+				return fmt.Errorf("%w: block %d does not link to block %d", ErrInvalidChain, height, height-1)
+			}
+			if height == base.Height && b.Hash() != base.BlockHash {
+				return fmt.Errorf("%w: block %d", ErrSnapshotMismatch, height)
+			}
+			// The snapshot already holds the state these blocks lead to.
+			if b.HasBody() && !bc.ValidProof(b.Nonce(), b.PreviousHash(), b.Transactions(), bc.params.MiningDifficulty) {
+				return fmt.Errorf("%w: block %d: %v", ErrInvalidChain, height, ErrInvalidProof)
+			}
+		} else if !b.HasBody() {
+			return fmt.Errorf("%w: block %d: %v", ErrInvalidChain, height, ErrNoBody)
+		} else if err := replay.checkBlock(b); err != nil {
+			return err
+		}
+		replay.chain = append(replay.chain, b)
+		replay.index.connect(b, height)
+	}
+	return nil
+}
+
+// ChainFrom returns the blocks from height from to the tip. It fails with
+// ErrNoBody when some of them are only known by their header.
+func (bc *Blockchain) ChainFrom(from int) ([]*Block, error) {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+
+	if from < 0 || from >= len(bc.chain) {
+		return nil, fmt.Errorf("no block at height %d", from)
+	}
+	if first := bc.firstBody(); from > 0 && from < first || from == 0 && first > 1 {
+		return nil, fmt.Errorf("%w: blocks before height %d", ErrNoBody, first)
+	}
+	return append([]*Block{}, bc.chain[from:]...), nil
+}
+
+// FirstBody returns the height from which the node has every block body.
+// The genesis block is always kept.
+func (bc *Blockchain) FirstBody() int {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+	return bc.firstBody()
+}
+
+func (bc *Blockchain) firstBody() int {
+	if bc.base == nil {
+		return 0
+	}
+	return bc.base.Height + 1
+}
+
+// ImportChain switches to chain after verifying it. Like a chain received
+// from a peer it must be longer than the current one. The chain is verified
+// without holding the chain, and again if the base moved meanwhile.
+func (bc *Blockchain) ImportChain(chain []*Block) error {
+	base := bc.Base()
+	if err := bc.VerifyChain(chain); err != nil {
+		return err
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if len(chain) <= len(bc.chain) {
+		return ErrShorterChain
+	}
+	// Pruning meanwhile moves the base the chain was verified against.
+	if bc.base != base {
+		if err := bc.VerifyChain(chain); err != nil {
+			return err
+		}
+	}
+	bc.replaceChain(chain)
+	return nil
+}
+
+// ImportChainJSON imports a chain exported by ExportChainJSON.
+func (bc *Blockchain) ImportChainJSON(data []byte) error {
+	chain, err := DecodeChainJSON(data)
+	if err != nil {
+		return err
+	}
+	return bc.ImportChain(chain)
+}
+
+// ExportChainJSON exports the chain in the format /chain serves.
+func (bc *Blockchain) ExportChainJSON() ([]byte, error) {
+	return bc.MarshalJSON()
+}
+
+// AddBlock connects b on top of the chain after checking it the way a
+// verified chain is checked, and that it spends only confirmed, spendable
+// coins and repeats no transaction already in the chain.
+func (bc *Blockchain) AddBlock(b *Block) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if err := bc.checkBlock(b); err != nil {
+		bc.metrics.blocksRejected.Inc(REJECT_INVALID)
+		return err
+	}
+	bc.connectBlock(b)
+	return nil
+}
+
+// checkBlock validates b as the next block of the chain. The caller holds
+// mu, unless the chain is the replay of VerifyChain.
+func (bc *Blockchain) checkBlock(b *Block) error {
+	height := len(bc.chain)
+	if b.PreviousHash() != bc.chain[height-1].Hash() {
+		return fmt.Errorf("%w: block %d does not link to block %d", ErrInvalidChain, height, height-1)
+	}
+	if !bc.ValidProof(b.Nonce(), b.PreviousHash(), b.Transactions(), bc.params.MiningDifficulty) {
+		return fmt.Errorf("%w: block %d: %v", ErrInvalidChain, height, ErrInvalidProof)
+	}
+	if !bc.ValidCoinbase(height, b.Transactions()) {
+		return fmt.Errorf("%w: block %d: %v", ErrInvalidChain, height, ErrInvalidCoinbase)
+	}
+
+	spent := make(map[string]float32)
+	seen := make(map[[32]byte]bool, len(b.transactions))
+	for i, t := range b.transactions {
+		id := t.Hash()
+		if seen[id] || bc.hasTransaction(id) {
+			return fmt.Errorf("%w: block %d: transaction %d is a duplicate", ErrInvalidChain, height, i)
+		}
+		seen[id] = true
+		if t.senderBlockchainAddress == bc.params.MiningSender {
+			continue
+		}
+		if !validValue(t.value) {
+			return fmt.Errorf("%w: block %d: transaction %d has no positive value", ErrInvalidChain, height, i)
+		}
+		spent[t.senderBlockchainAddress] += t.value
+	}
+	for sender, value := range spent {
+		if bc.calculateBalance(sender).Spendable < value {
+			return fmt.Errorf("%w: block %d: %s spends more than it has", ErrInvalidChain, height, sender)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package block
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/Nico2220/blockchain/utils"
+)
+
+// testKeys holds the private key of every address made by testAddress.
+var testKeys = map[string]*ecdsa.PrivateKey{}
+
+var (
+	testAlice = testAddress()
+	testBob   = testAddress()
+)
+
+// testAddress returns the address of a new key, which send signs with.
+func testAddress() string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	address := utils.AddressFromPublicKey(&key.PublicKey)
+	testKeys[address] = key
+	return address
+}
+
+// send signs a transfer with the key of from and adds it to the pool.
+func send(bc *Blockchain, from, to string, value float32) bool {
+	t := NewTransactionAt(from, to, value, bc.now())
+	s, err := t.Sign(testKeys[from], rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return bc.AddTransactionAt(from, to, value, t.timeStamp, &testKeys[from].PublicKey, s)
+}
+
+func testParams() *NetworkParams {
+	params := RegtestParams()
+	params.Genesis.Allocations = []*Allocation{{Address: testAlice, Amount: 100}}
+	return params
+}
+
+// testChain mines n blocks, each with a transfer from alice to bob.
+func testChain(t testing.TB, n int) *Blockchain {
+	t.Helper()
+	bc := NewBlockchain(testBob, 0, testParams())
+	for i := 0; i < n; i++ {
+		if !send(bc, testAlice, testBob, 1) {
+			t.Fatalf("transaction %d rejected", i)
+		}
+		if !bc.Mining() {
+			t.Fatalf("block %d not mined", i)
+		}
+	}
+	return bc
+}
+
+func TestTransactionJSONRoundTrip(t *testing.T) {
+	tx := NewTransactionAt(testAlice, testBob, 1.25, 1735689600000000001)
+	data, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Transaction
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded != *tx || decoded.Hash() != tx.Hash() {
+		t.Fatalf("decoded %+v, want %+v", decoded, *tx)
+	}
+
+	if err := json.Unmarshal([]byte(`{"value": 1}`), &decoded); err == nil {
+		t.Fatal("decoded a transaction without addresses")
+	}
+}
+
+func TestBlockJSONRoundTrip(t *testing.T) {
+	b := testBlock()
+	b.previousHash = [32]byte{0xff, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 0xee}
+	data, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Block
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Hash() != b.Hash() {
+		t.Fatalf("decoded %s into a block with another hash", data)
+	}
+	again, _ := json.Marshal(&decoded)
+	if string(again) != string(data) {
+		t.Fatalf("encoded back to %s, want %s", again, data)
+	}
+
+	for _, bad := range []string{
+		`{"nonce": 1, "previousHash": "00", "transactions": []}`,
+		`{"timestamp": 1, "nonce": 1, "previousHash": "abc", "transactions": []}`,
+		`{"timestamp": 1, "nonce": 1, "previousHash": "0000000000000000000000000000000000000000000000000000000000000000", "transactions": [null]}`,
+	} {
+		if err := json.Unmarshal([]byte(bad), &decoded); err == nil {
+			t.Errorf("decoded %s without error", bad)
+		}
+	}
+}
+
+func TestChainJSONRoundTrip(t *testing.T) {
+	bc := testChain(t, 3)
+	data, err := bc.ExportChainJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Blockchain
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Chain()) != len(bc.Chain()) {
+		t.Fatalf("decoded %d blocks, want %d", len(decoded.Chain()), len(bc.Chain()))
+	}
+	for height, b := range bc.Chain() {
+		if decoded.Chain()[height].Hash() != b.Hash() {
+			t.Fatalf("block %d changed after a round trip", height)
+		}
+	}
+	if _, ok := decoded.BlockByHash(bc.LasBlock().Hash()); !ok {
+		t.Fatal("decoded chain is not indexed")
+	}
+
+	again, _ := json.Marshal(&decoded)
+	if string(again) != string(data) {
+		t.Fatal("chain encoded back differently")
+	}
+}
+
+func TestImportChain(t *testing.T) {
+	source := testChain(t, 3)
+	data, _ := source.ExportChainJSON()
+
+	bc := NewBlockchain(testBob, 0, testParams())
+	if err := bc.ImportChainJSON(data); err != nil {
+		t.Fatal(err)
+	}
+	if bc.LasBlock().Hash() != source.LasBlock().Hash() {
+		t.Fatal("imported chain has another tip")
+	}
+	if got := bc.CalculateBalance(testAlice).Amount; got != 97 {
+		t.Fatalf("alice has %v after the import, want 97", got)
+	}
+	if err := bc.ImportChainJSON(data); !errors.Is(err, ErrShorterChain) {
+		t.Fatalf("importing the same chain again: %v", err)
+	}
+}
+
+func TestImportChainRejects(t *testing.T) {
+	source := testChain(t, 3)
+
+	// Tampering with a block in the middle breaks the link of the next one
+	// even when the tampered block still meets the difficulty by chance.
+	tampered := append([]*Block{}, source.Chain()...)
+	middle := *tampered[2]
+	middle.transactions = append([]*Transaction{NewTransactionAt(testAlice, testBob, 50, 1)}, middle.transactions...)
+	tampered[2] = &middle
+
+	other := RegtestParams()
+	other.Genesis.Timestamp++
+	foreign := NewBlockchain(testBob, 0, other)
+
+	tests := map[string]struct {
+		chain []*Block
+		err   error
+	}{
+		"empty":    {nil, ErrEmptyChain},
+		"genesis":  {foreign.Chain(), ErrGenesisMismatch},
+		"tampered": {tampered, ErrInvalidChain},
+		"unlinked": {[]*Block{source.Chain()[0], source.Chain()[2]}, ErrInvalidChain},
+	}
+	for name, test := range tests {
+		bc := NewBlockchain(testBob, 0, testParams())
+		if err := bc.ImportChain(test.chain); !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v, want %v", name, err, test.err)
+		}
+		if len(bc.Chain()) != 1 {
+			t.Errorf("%s: chain changed after a failed import", name)
+		}
+	}
+}
+
+// solve mines a block of transactions on top of previousHash.
+func solve(bc *Blockchain, previousHash [32]byte, transactions []*Transaction) *Block {
+	b := NewBlockAt(0, previousHash, 1, transactions)
+	for !bc.ValidProof(b.nonce, b.previousHash, b.transactions, bc.params.MiningDifficulty) {
+		b.nonce++
+	}
+	return b
+}
+
+func TestCoinbaseRejects(t *testing.T) {
+	coinbase, subsidy := testParams().MiningSender, testParams().Subsidy(1)
+	tests := map[string][]*Transaction{
+		"too much": {NewTransactionAt(coinbase, testBob, subsidy+1, 1)},
+		"negative": {
+			NewTransactionAt(coinbase, testAlice, -10, 1),
+			NewTransactionAt(coinbase, testBob, subsidy+10, 2),
+		},
+		"nan":      {NewTransactionAt(coinbase, testBob, float32(math.NaN()), 1)},
+		"infinite": {NewTransactionAt(testAlice, testBob, float32(math.Inf(1)), 1)},
+	}
+	for name, transactions := range tests {
+		bc := NewBlockchain(testBob, 0, testParams())
+		b := solve(bc, bc.LasBlock().Hash(), transactions)
+		if _, err := bc.SubmitWork(b.nonce, b.previousHash, b.transactions); err == nil {
+			t.Errorf("%s: work submitted", name)
+		}
+		if err := bc.AddBlock(b); !errors.Is(err, ErrInvalidChain) {
+			t.Errorf("%s: block added: %v", name, err)
+		}
+		if err := bc.VerifyChain([]*Block{bc.Chain()[0], b}); !errors.Is(err, ErrInvalidChain) {
+			t.Errorf("%s: chain verified: %v", name, err)
+		}
+	}
+}
+
+// TestImportChainRejectsSpends checks that a chain from a peer is held to the
+// balances AddBlock holds a single block to.
+func TestImportChainRejectsSpends(t *testing.T) {
+	params := testParams()
+	bc := NewBlockchain(testBob, 0, params)
+	genesis := bc.LasBlock()
+
+	overspend := solve(bc, genesis.Hash(), []*Transaction{
+		NewTransactionAt(testAlice, testBob, 150, 1),
+		NewTransactionAt(params.MiningSender, testBob, params.Subsidy(1), 2),
+	})
+
+	// Carol mines a block and spends its reward in the next one.
+	reward := solve(bc, genesis.Hash(), []*Transaction{NewTransactionAt(params.MiningSender, "1Carol", params.Subsidy(1), 1)})
+	immature := solve(bc, reward.Hash(), []*Transaction{NewTransactionAt("1Carol", testBob, params.Subsidy(1), 2)})
+
+	tests := map[string][]*Block{
+		"overspend":      {genesis, overspend},
+		"immature spend": {genesis, reward, immature},
+	}
+	for name, chain := range tests {
+		if err := bc.ImportChain(chain); !errors.Is(err, ErrInvalidChain) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrInvalidChain)
+		}
+		if len(bc.Chain()) != 1 {
+			t.Errorf("%s: chain changed after a failed import", name)
+		}
+	}
+	if err := bc.ImportChain([]*Block{genesis, reward}); err != nil {
+		t.Fatalf("the reward alone: %v", err)
+	}
+}
+
+func TestTransactionSignature(t *testing.T) {
+	bc := NewBlockchain(testBob, 0, testParams())
+	alice := &testKeys[testAlice].PublicKey
+	tx := NewTransactionAt(testAlice, testBob, 1, 1735689600000000001)
+	signature, err := tx.Sign(testKeys[testAlice], rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobSignature, _ := tx.Sign(testKeys[testBob], rand.Reader)
+
+	tests := map[string]struct {
+		tx        *Transaction
+		publicKey *ecdsa.PublicKey
+		signature *utils.Signature
+	}{
+		"other timestamp": {NewTransactionAt(testAlice, testBob, 1, 1735689600000000002), alice, signature},
+		"other value":     {NewTransactionAt(testAlice, testBob, 2, 1735689600000000001), alice, signature},
+		"other recipient": {NewTransactionAt(testAlice, testAlice, 1, 1735689600000000001), alice, signature},
+		"key of another":  {tx, &testKeys[testBob].PublicKey, bobSignature},
+		"no key":          {tx, nil, signature},
+		"no signature":    {tx, alice, nil},
+	}
+	for name, test := range tests {
+		if bc.VerifyTransactionSignature(test.publicKey, test.signature, test.tx) {
+			t.Errorf("%s: signature verified", name)
+		}
+		if bc.AddTransactionAt(test.tx.senderBlockchainAddress, test.tx.recipientBlockchainAddress, test.tx.value,
+			test.tx.timeStamp, test.publicKey, test.signature) {
+			t.Errorf("%s: transaction added", name)
+		}
+	}
+	if !bc.AddTransactionAt(testAlice, testBob, 1, tx.timeStamp, alice, signature) {
+		t.Fatal("the signed transaction was rejected")
+	}
+}
@@ -0,0 +1,84 @@
+package block
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Nico2220/blockchain/clock"
+)
+
+var testEpoch = time.Unix(1735689600, 0)
+
+func TestFakeClockMinesSameBlocks(t *testing.T) {
+	mine := func() [32]byte {
+		bc := NewBlockchain(testBob, 0, testParams())
+		bc.SetClock(clock.NewFake(testEpoch))
+		if !send(bc, testAlice, testBob, 1) || !bc.Mining() {
+			t.Fatal("block not mined")
+		}
+		return bc.LasBlock().Hash()
+	}
+	if a, b := mine(), mine(); a != b {
+		t.Fatalf("the same chain at the same time mined %x and %x", a, b)
+	}
+}
+
+func TestMiningLoopFollowsClock(t *testing.T) {
+	fake := clock.NewFake(testEpoch)
+	bc := NewBlockchain(testBob, 0, testParams())
+	bc.SetClock(fake)
+	bc.StartMining()
+	defer bc.Stop()
+
+	fake.BlockUntil(1)
+	if !send(bc, testAlice, testBob, 1) {
+		t.Fatal("transaction rejected")
+	}
+	interval := time.Duration(bc.params.MiningTimerSec) * time.Second
+	fake.Advance(interval - time.Nanosecond)
+	if h := bc.Height(); h != 0 {
+		t.Fatalf("mined before the timer, height %d", h)
+	}
+	fake.Advance(time.Nanosecond)
+	fake.BlockUntil(1)
+	if h := bc.Height(); h != 1 {
+		t.Fatalf("height %d after the timer, want 1", h)
+	}
+	if got := bc.LasBlock().timeStamp; got != testEpoch.Add(interval).UnixNano() {
+		t.Fatalf("block time %d, want the clock's %d", got, testEpoch.Add(interval).UnixNano())
+	}
+}
+
+func TestSyncLoopFollowsNeighbors(t *testing.T) {
+	source := testChain(t, 3)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", BINARY_CONTENT_TYPE)
+		w.Write(EncodeChain(source.Chain()))
+	}))
+	defer server.Close()
+
+	fake := clock.NewFake(testEpoch)
+	bc := NewBlockchain(testBob, 0, testParams())
+	bc.SetClock(fake)
+	bc.SetPeers([]string{strings.TrimPrefix(server.URL, "http://")})
+	bc.Start(context.Background())
+	defer bc.Stop()
+	if h := bc.Height(); h != 3 {
+		t.Fatalf("height %d after start, want the neighbor's 3", h)
+	}
+
+	for i := 0; i < 2; i++ {
+		send(source, testAlice, testBob, 1)
+		source.Mining()
+	}
+	fake.BlockUntil(1)
+	fake.Advance(time.Duration(bc.params.NeighborSyncTimeSec) * time.Second)
+	fake.BlockUntil(1)
+	if h, lag := bc.Height(), bc.SyncLag(); h != 5 || lag != 0 {
+		t.Fatalf("height %d and sync lag %d after a sync, want 5 and 0", h, lag)
+	}
+}
@@ -0,0 +1,131 @@
+package block
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestConcurrentAccess submits, mines and reads at once. Run it with -race.
+func TestConcurrentAccess(t *testing.T) {
+	const (
+		miner   = "1Miner"
+		rounds  = 200
+		senders = 4
+	)
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := NewBlockchain(miner, 0, testParams())
+	if err := bc.LoadStore(store); err != nil {
+		t.Fatal(err)
+	}
+	defer bc.Close()
+
+	var writers, readers sync.WaitGroup
+	done := make(chan struct{})
+
+	for i := 0; i < senders; i++ {
+		writers.Add(1)
+		go func(i int) {
+			defer writers.Done()
+			from, to := testAlice, testBob
+			if i%2 == 1 {
+				from, to = to, from
+			}
+			for n := 0; n < rounds; n++ {
+				send(bc, from, to, 1)
+			}
+		}(i)
+	}
+
+	writers.Add(2)
+	go func() {
+		defer writers.Done()
+		for n := 0; n < rounds/4; n++ {
+			bc.Mining()
+		}
+	}()
+	go func() {
+		defer writers.Done()
+		for n := 0; n < rounds/4; n++ {
+			previousHash, transactions := bc.MiningWork(miner)
+			nonce := bc.proofOfWork(previousHash, transactions)
+			bc.SubmitWork(nonce, previousHash, transactions)
+		}
+	}()
+
+	read := func(f func()) {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					f()
+				}
+			}
+		}()
+	}
+	read(func() {
+		chain := bc.Chain()
+		bc.BlockByHeight(len(chain) - 1)
+		bc.LatestBlocks(0, 5)
+		bc.Headers(0, 5)
+		bc.ChainFrom(0)
+		bc.MarshalJSON()
+	})
+	read(func() {
+		for _, tx := range bc.TransactionPool() {
+			bc.HasTransaction(tx.Hash())
+			bc.TransactionByID(tx.Hash())
+		}
+		bc.AddressHistory(testAlice, 0, 10)
+		bc.AddressTransactions(testBob, 0, 10)
+	})
+	read(func() {
+		bc.CalculateBalance(testAlice)
+		bc.CalculateTotalAmount(testBob)
+		bc.Supply()
+		bc.StateAt(bc.Height())
+		bc.Snapshot()
+		bc.SyncLag()
+	})
+
+	writers.Wait()
+	close(done)
+	readers.Wait()
+
+	for bc.Mining() {
+	}
+	chain := bc.Chain()
+	if err := bc.VerifyChain(chain); err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[[32]byte]bool)
+	for height, b := range chain {
+		for _, tx := range b.Transactions() {
+			if seen[tx.Hash()] {
+				t.Fatalf("transaction %x %s mined twice, again at height %d", tx.Hash(), tx.SenderBlockchainAddress(), height)
+			}
+			seen[tx.Hash()] = true
+		}
+	}
+
+	var total float64
+	for _, address := range []string{testAlice, testBob, miner} {
+		balance := bc.CalculateBalance(address)
+		if balance.Amount < 0 {
+			t.Errorf("%s has a negative balance %v", address, balance.Amount)
+		}
+		total += float64(balance.Amount)
+	}
+	if supply := bc.Supply(); total != supply.Circulating {
+		t.Errorf("balances add up to %v, the supply is %v", total, supply.Circulating)
+	}
+	if len(bc.TransactionPool()) != 0 {
+		t.Errorf("%d transactions left in the pool", len(bc.TransactionPool()))
+	}
+}
@@ -0,0 +1,256 @@
+package block
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ENCODING_VERSION is the first byte of every encoded transaction, block and
+// chain. Decoders reject the versions they don't know.
+//
+// Version 1 encodes a transaction as
+//
+//	sender      uvarint length, bytes
+//	recipient   uvarint length, bytes
+//	value       4 bytes, IEEE 754 little endian
+//	timestamp   varint
+//
+// a block as
+//
+//	timestamp     varint
+//	nonce         varint
+//	previousHash  32 bytes
+//	transactions  uvarint count, transactions without their version byte
+//
+// and a chain as a uvarint count of blocks, each one prefixed by its uvarint
+// length and without its version byte. Varints must be minimal so that a
+// value has exactly one encoding.
+const ENCODING_VERSION byte = 1
+
+// BINARY_CONTENT_TYPE is the media type of the binary encoding on the wire.
+const BINARY_CONTENT_TYPE = "application/vnd.blockchain.v1+octet-stream"
+
+var (
+	ErrUnknownVersion = errors.New("unknown encoding version")
+	ErrTruncated      = errors.New("truncated data")
+	ErrTrailingData   = errors.New("trailing data")
+	ErrNonMinimal     = errors.New("non minimal varint")
+)
+
+// MarshalBinary encodes the transaction. It never fails.
+func (t *Transaction) MarshalBinary() ([]byte, error) {
+	return t.appendBinary([]byte{ENCODING_VERSION}), nil
+}
+
+func (t *Transaction) UnmarshalBinary(data []byte) error {
+	d := decoder{data: data}
+	d.version()
+	t.decode(&d)
+	return d.finish()
+}
+
+func (t *Transaction) appendBinary(buf []byte) []byte {
+	buf = appendString(buf, t.senderBlockchainAddress)
+	buf = appendString(buf, t.recipientBlockchainAddress)
+	buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(t.value))
+	return binary.AppendVarint(buf, t.timeStamp)
+}
+
+func (t *Transaction) decode(d *decoder) {
+	t.senderBlockchainAddress = d.string()
+	t.recipientBlockchainAddress = d.string()
+	t.value = math.Float32frombits(binary.LittleEndian.Uint32(d.bytes(4)))
+	t.timeStamp = d.varint()
+}
+
+// MarshalBinary encodes the block. It never fails.
+func (b *Block) MarshalBinary() ([]byte, error) {
+	return b.appendBinary([]byte{ENCODING_VERSION}), nil
+}
+
+func (b *Block) UnmarshalBinary(data []byte) error {
+	d := decoder{data: data}
+	d.version()
+	b.decode(&d)
+	return d.finish()
+}
+
+func (b *Block) appendBinary(buf []byte) []byte {
+	buf = binary.AppendVarint(buf, b.timeStamp)
+	buf = binary.AppendVarint(buf, int64(b.nonce))
+	buf = append(buf, b.previousHash[:]...)
+	buf = binary.AppendUvarint(buf, uint64(len(b.transactions)))
+	for _, t := range b.transactions {
+		buf = t.appendBinary(buf)
+	}
+	return buf
+}
+
+// minTransactionSize is the size of a transaction with empty addresses, used
+// to reject counts that can't fit in the remaining data before allocating.
+const minTransactionSize = 1 + 1 + 4 + 1
+
+func (b *Block) decode(d *decoder) {
+	b.timeStamp = d.varint()
+	b.nonce = int(d.varint())
+	copy(b.previousHash[:], d.bytes(32))
+
+	n := d.count(minTransactionSize)
+	b.transactions = make([]*Transaction, n)
+	for i := range b.transactions {
+		t := &Transaction{}
+		t.decode(d)
+		b.transactions[i] = t
+	}
+}
+
+// ProofTemplate splits the encoding ValidProof hashes around the nonce: the
+// sha256 of prefix, the varint nonce and suffix must start with as many hex
+// zeros as the difficulty. External miners hash it without knowing the
+// encoding.
+func ProofTemplate(previousHash [32]byte, transactions []*Transaction) ([]byte, []byte) {
+	prefix := binary.AppendVarint([]byte{ENCODING_VERSION}, 0)
+	suffix := (&Block{previousHash: previousHash, transactions: transactions}).appendBinary(nil)
+	// Skip the timestamp and nonce, both 0, encoded in one byte each.
+	return prefix, suffix[2:]
+}
+
+// EncodeChain encodes blocks for storage or transfer to a peer.
+func EncodeChain(blocks []*Block) []byte {
+	buf := []byte{ENCODING_VERSION}
+	buf = binary.AppendUvarint(buf, uint64(len(blocks)))
+	for _, b := range blocks {
+		encoded := b.appendBinary(nil)
+		buf = binary.AppendUvarint(buf, uint64(len(encoded)))
+		buf = append(buf, encoded...)
+	}
+	return buf
+}
+
+// DecodeChain decodes what EncodeChain produced. It only checks the
+// encoding, the caller validates the chain.
+func DecodeChain(data []byte) ([]*Block, error) {
+	d := decoder{data: data}
+	d.version()
+
+	// A block takes at least its length, timestamp, nonce, hash and count.
+	n := d.count(1 + 1 + 1 + 32 + 1)
+	blocks := make([]*Block, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		size := d.count(1)
+		bd := decoder{data: d.bytes(size), err: d.err}
+		b := &Block{}
+		b.decode(&bd)
+		if err := bd.finish(); err != nil {
+			return nil, fmt.Errorf("block %d: %w", i, err)
+		}
+		blocks = append(blocks, b)
+	}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// decoder reads the binary encoding. The first error sticks and every later
+// read returns zero values, so callers only check err once at the end.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+func (d *decoder) version() {
+	v := d.bytes(1)
+	if d.err == nil && v[0] != ENCODING_VERSION {
+		d.fail(fmt.Errorf("%w %d", ErrUnknownVersion, v[0]))
+	}
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if d.err != nil || n > len(d.data) {
+		d.fail(ErrTruncated)
+		return make([]byte, n)
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail(ErrTruncated)
+		return 0
+	}
+	if n != len(binary.AppendUvarint(nil, v)) {
+		d.fail(ErrNonMinimal)
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail(ErrTruncated)
+		return 0
+	}
+	if n != len(binary.AppendVarint(nil, v)) {
+		d.fail(ErrNonMinimal)
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+// count reads a length or a number of items of at least minSize bytes each
+// and checks that they can fit in what is left.
+func (d *decoder) count(minSize int) int {
+	v := d.uvarint()
+	if d.err == nil && v > uint64(len(d.data)/minSize) {
+		d.fail(ErrTruncated)
+		return 0
+	}
+	return int(v)
+}
+
+// int reads a uvarint that must fit in an int32, such as a height.
+func (d *decoder) int() int {
+	v := d.uvarint()
+	if v > math.MaxInt32 {
+		d.fail(fmt.Errorf("%d is out of range", v))
+		return 0
+	}
+	return int(v)
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes(d.count(1)))
+}
+
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.data) > 0 {
+		d.fail(ErrTrailingData)
+	}
+	return d.err
+}
@@ -0,0 +1,172 @@
+package block
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testBlock() *Block {
+	return &Block{
+		timeStamp:    1735689600000000000,
+		nonce:        42,
+		previousHash: [32]byte{1, 2, 3},
+		transactions: []*Transaction{
+			NewTransactionAt("THE BLOCKCHAIN", "1HTcXand8ezWUken1JLffVGMWi1GkKhnY8", 50, 1),
+			NewTransactionAt("1HTcXand8ezWUken1JLffVGMWi1GkKhnY8", "1nV5Np6exVRaZRoWHnJavQsVbDQwYZVPp", 1.5, -7),
+		},
+	}
+}
+
+func sameTransaction(a, b *Transaction) bool {
+	return a.senderBlockchainAddress == b.senderBlockchainAddress &&
+		a.recipientBlockchainAddress == b.recipientBlockchainAddress &&
+		math.Float32bits(a.value) == math.Float32bits(b.value) &&
+		a.timeStamp == b.timeStamp
+}
+
+func FuzzTransactionRoundTrip(f *testing.F) {
+	f.Add("", "", float32(0), int64(0))
+	f.Add("THE BLOCKCHAIN", "1HTcXand8ezWUken1JLffVGMWi1GkKhnY8", float32(50), int64(1735689600000000000))
+	f.Add("a", "b", float32(math.Inf(-1)), int64(math.MinInt64))
+
+	f.Fuzz(func(t *testing.T, sender, recipient string, value float32, timeStamp int64) {
+		tx := NewTransactionAt(sender, recipient, value, timeStamp)
+		data, _ := tx.MarshalBinary()
+
+		var decoded Transaction
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("decoding %x: %v", data, err)
+		}
+		if !sameTransaction(tx, &decoded) {
+			t.Fatalf("decoded %+v, want %+v", decoded, *tx)
+		}
+		if decoded.Hash() != tx.Hash() {
+			t.Fatalf("hash changed after a round trip")
+		}
+	})
+}
+
+// FuzzBlockDecode checks that whatever decodes encodes back to the same
+// bytes: a block has a single encoding, and so a single hash.
+func FuzzBlockDecode(f *testing.F) {
+	data, _ := testBlock().MarshalBinary()
+	f.Add(data)
+	data, _ = RegtestParams().GenesisBlock().MarshalBinary()
+	f.Add(data)
+	f.Add([]byte{ENCODING_VERSION})
+	f.Add([]byte{2, 0, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var b Block
+		if err := b.UnmarshalBinary(data); err != nil {
+			return
+		}
+		encoded, _ := b.MarshalBinary()
+		if !bytes.Equal(encoded, data) {
+			t.Fatalf("decoded %x, encoded back to %x", data, encoded)
+		}
+	})
+}
+
+func FuzzChainDecode(f *testing.F) {
+	f.Add(EncodeChain([]*Block{RegtestParams().GenesisBlock(), testBlock()}))
+	f.Add(EncodeChain(nil))
+	f.Add([]byte{ENCODING_VERSION, 0xff, 0xff, 0xff, 0xff, 0x0f})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		blocks, err := DecodeChain(data)
+		if err != nil {
+			return
+		}
+		if encoded := EncodeChain(blocks); !bytes.Equal(encoded, data) {
+			t.Fatalf("decoded %x, encoded back to %x", data, encoded)
+		}
+	})
+}
+
+func TestBlockRoundTrip(t *testing.T) {
+	b := testBlock()
+	data, _ := b.MarshalBinary()
+
+	var decoded Block
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Hash() != b.Hash() {
+		t.Fatalf("hash changed after a round trip")
+	}
+	if decoded.timeStamp != b.timeStamp || decoded.nonce != b.nonce || decoded.previousHash != b.previousHash {
+		t.Fatalf("decoded header %+v, want %+v", decoded, *b)
+	}
+	for i, tx := range b.transactions {
+		if !sameTransaction(tx, decoded.transactions[i]) {
+			t.Fatalf("transaction %d: decoded %+v, want %+v", i, *decoded.transactions[i], *tx)
+		}
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	data, _ := testBlock().MarshalBinary()
+	tests := map[string][]byte{
+		"empty":       {},
+		"version":     append([]byte{ENCODING_VERSION + 1}, data[1:]...),
+		"truncated":   data[:len(data)-1],
+		"trailing":    append(append([]byte{}, data...), 0),
+		"non minimal": {ENCODING_VERSION, 0x80, 0x00},
+	}
+	for name, data := range tests {
+		var b Block
+		if err := b.UnmarshalBinary(data); err == nil {
+			t.Errorf("%s: decoded %x without error", name, data)
+		}
+	}
+}
+
+func TestProofTemplate(t *testing.T) {
+	b := testBlock()
+	prefix, suffix := ProofTemplate(b.previousHash, b.transactions)
+
+	guess := Block{nonce: b.nonce, previousHash: b.previousHash, transactions: b.transactions}
+	want, _ := guess.MarshalBinary()
+	got := append(append(append([]byte{}, prefix...), 0x54), suffix...) // 42 as a zigzag varint
+	if !bytes.Equal(got, want) {
+		t.Fatalf("template gives %x, want %x", got, want)
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis := RegtestParams().GenesisBlock()
+	for _, b := range []*Block{genesis, testBlock(), testBlock()} {
+		if err := s.Append(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Truncate(2); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// A crash in the middle of an append leaves half a record behind.
+	path := filepath.Join(dir, BLOCKS_FILE)
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	f.Write([]byte{100, 1, 2})
+	f.Close()
+
+	s, err = OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	blocks := s.Blocks()
+	if len(blocks) != 2 || blocks[0].Hash() != genesis.Hash() || blocks[1].Hash() != testBlock().Hash() {
+		t.Fatalf("reopened store holds %d blocks", len(blocks))
+	}
+}
@@ -0,0 +1,361 @@
+package block
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	crand "crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Nico2220/blockchain/utils"
+)
+
+// The decoders below are fed by peers and clients. Whatever they get, they
+// must return an error rather than panic, and what they accept must encode
+// back to an equivalent value.
+
+func FuzzTransactionJSON(f *testing.F) {
+	data, _ := json.Marshal(testBlock().transactions[1])
+	f.Add(data)
+	f.Add([]byte(`{"sender_blochain_address":"a","recipient_blockchain_address":"b","value":1e39,"timestamp":1}`))
+	f.Add([]byte(`null`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var tx Transaction
+		if err := json.Unmarshal(data, &tx); err != nil {
+			return
+		}
+		encoded, _ := json.Marshal(&tx)
+		var decoded Transaction
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatalf("%s decoded, but not its encoding %s: %v", data, encoded, err)
+		}
+		if !sameTransaction(&tx, &decoded) || tx.Hash() != decoded.Hash() {
+			t.Fatalf("%s changed after a round trip", data)
+		}
+	})
+}
+
+func FuzzBlockJSON(f *testing.F) {
+	data, _ := json.Marshal(testBlock())
+	f.Add(data)
+	f.Add([]byte(`{"timestamp":0,"nonce":0,"previousHash":"00","transactions":[null]}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var b Block
+		if err := json.Unmarshal(data, &b); err != nil {
+			return
+		}
+		encoded, _ := json.Marshal(&b)
+		var decoded Block
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			t.Fatalf("%s decoded, but not its encoding %s: %v", data, encoded, err)
+		}
+		if decoded.Hash() != b.Hash() {
+			t.Fatalf("%s changed after a round trip", data)
+		}
+	})
+}
+
+// FuzzImportChainJSON runs a chain served by a peer through decoding and
+// validation.
+func FuzzImportChainJSON(f *testing.F) {
+	data, _ := testChain(f, 2).ExportChainJSON()
+	f.Add(data)
+	f.Add([]byte(`{"chain":[]}`))
+	f.Add([]byte(`{"chain":[null]}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var decoded Blockchain
+		if err := json.Unmarshal(data, &decoded); err == nil {
+			decoded.LasBlock()
+		}
+
+		bc := NewBlockchain(testBob, 0, testParams())
+		if err := bc.ImportChainJSON(data); err != nil {
+			return
+		}
+		if err := bc.VerifyChain(bc.Chain()); err != nil {
+			t.Fatalf("imported a chain that does not verify: %v", err)
+		}
+	})
+}
+
+// FuzzAddBlock submits a decoded block on top of a short chain.
+func FuzzAddBlock(f *testing.F) {
+	source := testChain(f, 2)
+	for _, b := range source.Chain()[1:] {
+		data, _ := b.MarshalBinary()
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var b Block
+		if err := b.UnmarshalBinary(data); err != nil {
+			return
+		}
+		bc := NewBlockchain(testBob, 0, testParams())
+		if err := bc.AddBlock(source.Chain()[1]); err != nil {
+			t.Fatal(err)
+		}
+		if err := bc.AddBlock(&b); err != nil {
+			return
+		}
+		if err := bc.VerifyChain(bc.Chain()); err != nil {
+			t.Fatalf("added a block the chain does not verify with: %v", err)
+		}
+	})
+}
+
+// FuzzTransactionRequest runs a transaction posted by a client through the
+// validation the servers do before adding it to the pool. The pool must only
+// take what the key of the sender signed: any other request is forged.
+func FuzzTransactionRequest(f *testing.F) {
+	key := testKeys[testAlice]
+	t := NewTransactionAt(testAlice, testBob, 1, 1735689600000000001)
+	signature, err := t.Sign(key, crand.Reader)
+	if err != nil {
+		f.Fatal(err)
+	}
+	data, _ := json.Marshal(map[string]any{
+		"sender_blochain_address":      testAlice,
+		"recipient_blockchain_address": testBob,
+		"sender_public_key":            fmt.Sprintf("%064x%064x", key.X.Bytes(), key.Y.Bytes()),
+		"value":                        t.value,
+		"timestamp":                    t.timeStamp,
+		"signature":                    signature.String(),
+	})
+	f.Add(data)
+	f.Add([]byte(`{}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var tr TransactionRequest
+		if err := json.Unmarshal(data, &tr); err != nil {
+			return
+		}
+		if len(tr.Validate()) > 0 || *tr.SenderBlockchainAddress == testParams().MiningSender {
+			return
+		}
+		publicKey, err := utils.PublickKeyFromString(*tr.SenderPublicKey)
+		if err != nil {
+			t.Fatalf("validated a request with %v", err)
+		}
+		signature, err := utils.SignatureFromString(*tr.Signature)
+		if err != nil {
+			t.Fatalf("validated a request with %v", err)
+		}
+
+		tx := NewTransactionAt(*tr.SenderBlockchainAddress, *tr.RecipientBlochainAddress, *tr.Value, *tr.Timestamp)
+		id := tx.Hash()
+		signed := utils.AddressFromPublicKey(publicKey) == tx.senderBlockchainAddress &&
+			ecdsa.Verify(publicKey, id[:], signature.R, signature.S)
+
+		bc := NewBlockchain(testBob, 0, testParams())
+		added := bc.AddTransactionAt(tx.senderBlockchainAddress, tx.recipientBlockchainAddress, tx.value, tx.timeStamp, publicKey, signature)
+		if added && !signed {
+			t.Fatalf("forged transaction %s added to the pool", data)
+		}
+		if !added && signed && tx.senderBlockchainAddress == testAlice && validValue(tx.value) && tx.value <= 100 {
+			t.Fatalf("transaction %s signed by alice rejected", data)
+		}
+	})
+}
+
+func FuzzSnapshotDecode(f *testing.F) {
+	s, err := testChain(f, 12).StateAt(8)
+	if err != nil {
+		f.Fatal(err)
+	}
+	data, _ := s.MarshalBinary()
+	f.Add(data)
+	f.Add([]byte{ENCODING_VERSION})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var s Snapshot
+		if err := s.UnmarshalBinary(data); err != nil {
+			return
+		}
+		encoded, _ := s.MarshalBinary()
+		var decoded Snapshot
+		if err := decoded.UnmarshalBinary(encoded); err != nil || decoded.Commitment() != s.Commitment() {
+			t.Fatalf("decoded %x, which does not round trip: %v", data, err)
+		}
+	})
+}
+
+func FuzzArchiveReader(f *testing.F) {
+	source := testChain(f, 2)
+	for _, format := range []string{FORMAT_JSONL, FORMAT_BINARY} {
+		var buf bytes.Buffer
+		aw, _ := NewArchiveWriter(&buf, format, 0)
+		for _, b := range source.Chain() {
+			aw.Write(b)
+		}
+		aw.Flush()
+		f.Add(buf.Bytes())
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ar, err := NewArchiveReader(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		for {
+			if _, _, err := ar.Next(); err != nil {
+				return
+			}
+		}
+	})
+}
+
+func FuzzHeaders(f *testing.F) {
+	data, _ := json.Marshal(testChain(f, 2).Headers(0, 3))
+	f.Add(data)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var headers []*Header
+		if err := json.Unmarshal(data, &headers); err != nil {
+			return
+		}
+		for _, h := range headers {
+			if h != nil {
+				h.block()
+			}
+		}
+	})
+}
+
+func FuzzLoadGenesis(f *testing.F) {
+	data, _ := os.ReadFile("../genesis.example.json")
+	f.Add(data)
+	f.Add([]byte(`{"allocations":[null]}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		path := filepath.Join(t.TempDir(), "genesis.json")
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		g, err := LoadGenesis(path)
+		if err != nil {
+			return
+		}
+		params := RegtestParams()
+		params.Genesis = g
+		NewBlockchain(testBob, 0, params).VerifyChain(nil)
+	})
+}
+
+// TestBalanceConservation mines random transfers and checks after every
+// block that no coin is created or lost: the balances add up to the
+// circulating supply and none is negative.
+func TestBalanceConservation(t *testing.T) {
+	miner := testAddress()
+	addresses := []string{testAlice, testBob, testAddress(), testAddress(), miner}
+	bc := NewBlockchain(miner, 0, testParams())
+	r := rand.New(rand.NewSource(1))
+
+	for round := 0; round < 50; round++ {
+		for n := r.Intn(6); n > 0; n-- {
+			from, to := addresses[r.Intn(len(addresses))], addresses[r.Intn(len(addresses))]
+			send(bc, from, to, float32(1+r.Intn(20)))
+		}
+		// Nothing is mined when every transfer was rejected.
+		if !bc.Mining() {
+			continue
+		}
+		height := bc.Height()
+
+		var total float64
+		for _, address := range addresses {
+			balance := bc.CalculateBalance(address)
+			if balance.Amount < 0 || balance.Spendable < 0 {
+				t.Fatalf("block %d: %s has the balance %+v", height, address, *balance)
+			}
+			total += float64(balance.Amount)
+		}
+		if supply := bc.Supply().Circulating; total != supply {
+			t.Fatalf("block %d: balances add up to %v, the supply is %v", height, total, supply)
+		}
+	}
+	if bc.Height() < 25 {
+		t.Fatalf("only %d blocks mined", bc.Height())
+	}
+}
+
+// tamperChain mines a chain with fixed addresses and times, at a difficulty
+// where a tampered block meeting the proof of work by chance is unlikely, so
+// that TestTamperRejected always checks the same mutations.
+func tamperChain(t *testing.T) (*Blockchain, []*Block) {
+	params := testParams()
+	params.MiningDifficulty = 4
+	params.Genesis.Allocations = []*Allocation{{Address: "1Alice", Amount: 100}}
+	bc := NewBlockchain("1Miner", 0, params)
+	for height := 1; height <= 3; height++ {
+		b := solve(bc, bc.LasBlock().Hash(), []*Transaction{
+			NewTransactionAt(params.MiningSender, "1Miner", params.Subsidy(height), int64(height)),
+			NewTransactionAt("1Alice", "1Bob", float32(height), int64(height)),
+		})
+		b.timeStamp = params.Genesis.Timestamp + int64(height)
+		if err := bc.AddBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bc, bc.Chain()
+}
+
+// TestTamperRejected changes every byte of an encoded chain in turn. A
+// tampered chain that still decodes must fail to verify unless it holds the
+// same blocks. The time of the tip is the one exception: the proof of work
+// doesn't cover block times and no later block links to the tip.
+func TestTamperRejected(t *testing.T) {
+	source, chain := tamperChain(t)
+	bc := NewBlockchain("1Miner", 0, source.params)
+
+	committed := func(b *Block) [32]byte {
+		c := *b
+		c.timeStamp = 0
+		return c.Hash()
+	}
+	changed := func(blocks []*Block) bool {
+		if len(blocks) != len(chain) {
+			return true
+		}
+		tip := len(chain) - 1
+		for height, b := range blocks[:tip] {
+			if b.Hash() != chain[height].Hash() {
+				return true
+			}
+		}
+		return committed(blocks[tip]) != committed(chain[tip])
+	}
+
+	encoded := EncodeChain(chain)
+	for i := range encoded {
+		for _, mask := range []byte{0x01, 0x80, 0xff} {
+			tampered := append([]byte{}, encoded...)
+			tampered[i] ^= mask
+			blocks, err := DecodeChain(tampered)
+			if err == nil && changed(blocks) && bc.VerifyChain(blocks) == nil {
+				t.Fatalf("binary: tampering with byte %d (^%#x) went unnoticed", i, mask)
+			}
+		}
+	}
+
+	// JSON has more than one encoding of a chain, so only the documents that
+	// decode to other blocks must fail.
+	data, _ := source.ExportChainJSON()
+	for i := range data {
+		for _, mask := range []byte{0x01, 0x20} {
+			tampered := append([]byte{}, data...)
+			tampered[i] ^= mask
+			blocks, err := DecodeChainJSON(tampered)
+			if err == nil && changed(blocks) && bc.VerifyChain(blocks) == nil {
+				t.Fatalf("json: tampering with byte %d (^%#x) went unnoticed", i, mask)
+			}
+		}
+	}
+}
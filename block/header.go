@@ -0,0 +1,149 @@
+package block
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Nico2220/blockchain/utils"
+)
+
+// MAX_HEADERS is the largest number of headers served in one response.
+const MAX_HEADERS = 2000
+
+var ErrNoBody = errors.New("block body is not available on this node")
+
+// Header returns b without its transactions. A header still links to the
+// previous block but its proof and transactions can no longer be checked.
+func (b *Block) Header() *Block {
+	if b.headerOnly {
+		return b
+	}
+	return &Block{
+		timeStamp:    b.timeStamp,
+		nonce:        b.nonce,
+		previousHash: b.previousHash,
+		headerOnly:   true,
+		hash:         b.Hash(),
+	}
+}
+
+// HasBody reports whether the transactions of b are known.
+func (b *Block) HasBody() bool {
+	return !b.headerOnly
+}
+
+// appendHeader encodes a header as its hash, timestamp, nonce and previous
+// hash.
+func (b *Block) appendHeader(buf []byte) []byte {
+	hash := b.Hash()
+	buf = append(buf, hash[:]...)
+	buf = binary.AppendVarint(buf, b.timeStamp)
+	buf = binary.AppendVarint(buf, int64(b.nonce))
+	return append(buf, b.previousHash[:]...)
+}
+
+func (b *Block) decodeHeader(d *decoder) {
+	b.headerOnly = true
+	copy(b.hash[:], d.bytes(32))
+	b.timeStamp = d.varint()
+	b.nonce = int(d.varint())
+	copy(b.previousHash[:], d.bytes(32))
+}
+
+// Header is the JSON form of a block header served by /headers.
+type Header struct {
+	Height       int    `json:"height"`
+	Hash         string `json:"hash"`
+	PreviousHash string `json:"previousHash"`
+	Timestamp    int64  `json:"timestamp"`
+	Nonce        int    `json:"nonce"`
+}
+
+// Headers returns up to limit headers from height from on.
+func (bc *Blockchain) Headers(from, limit int) []*Header {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+
+	headers := []*Header{}
+	for height := from; height >= 0 && height < len(bc.chain) && len(headers) < limit; height++ {
+		b := bc.chain[height]
+		headers = append(headers, &Header{
+			Height:       height,
+			Hash:         fmt.Sprintf("%x", b.Hash()),
+			PreviousHash: fmt.Sprintf("%x", b.previousHash),
+			Timestamp:    b.timeStamp,
+			Nonce:        b.nonce,
+		})
+	}
+	return headers
+}
+
+func (h *Header) block() (*Block, error) {
+	hash, err := utils.HashFromString(h.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("header %d: hash: %w", h.Height, err)
+	}
+	previousHash, err := utils.HashFromString(h.PreviousHash)
+	if err != nil {
+		return nil, fmt.Errorf("header %d: previousHash: %w", h.Height, err)
+	}
+	return &Block{
+		timeStamp:    h.Timestamp,
+		nonce:        h.Nonce,
+		previousHash: previousHash,
+		headerOnly:   true,
+		hash:         hash,
+	}, nil
+}
+
+// fetchHeaders downloads the headers of a neighbor up to height to, and
+// checks that they link to each other from the genesis block of the network.
+func (bc *Blockchain) fetchHeaders(neighbor string, to int) ([]*Block, error) {
+	headers := make([]*Block, 0, to+1)
+	for len(headers) <= to {
+		url := fmt.Sprintf("http://%s/headers?from=%d&limit=%d", neighbor, len(headers), min(MAX_HEADERS, to+1-len(headers)))
+		response, err := bc.client.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		var v struct {
+			Headers []*Header `json:"headers"`
+		}
+		err = json.NewDecoder(response.Body).Decode(&v)
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("headers request answered %s", response.Status)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(v.Headers) == 0 {
+			return nil, fmt.Errorf("headers end at height %d", len(headers)-1)
+		}
+
+		for _, h := range v.Headers {
+			if h == nil || h.Height != len(headers) {
+				return nil, fmt.Errorf("header %d is missing", len(headers))
+			}
+			b, err := h.block()
+			if err != nil {
+				return nil, err
+			}
+			if len(headers) == 0 {
+				if b.Hash() != bc.params.GenesisBlock().Hash() {
+					return nil, ErrGenesisMismatch
+				}
+			} else if b.previousHash != headers[len(headers)-1].Hash() {
+				return nil, fmt.Errorf("%w: header %d does not link to header %d", ErrInvalidChain, h.Height, h.Height-1)
+			}
+			headers = append(headers, b)
+			if len(headers) > to {
+				break
+			}
+		}
+	}
+	return headers, nil
+}
@@ -0,0 +1,88 @@
+package block
+
+import "fmt"
+
+const (
+	DIRECTION_INCOMING = "incoming"
+	DIRECTION_OUTGOING = "outgoing"
+	DIRECTION_SELF     = "self"
+
+	STATUS_PENDING   = "pending"
+	STATUS_IMMATURE  = "immature"
+	STATUS_CONFIRMED = "confirmed"
+)
+
+// HistoryEntry is a transfer seen from the point of view of one address.
+// Pending entries have a zero height and the timestamp of the transaction,
+// confirmed ones the timestamp of their block.
+type HistoryEntry struct {
+	ID            string  `json:"id"`
+	Direction     string  `json:"direction"`
+	Status        string  `json:"status"`
+	Counterparty  string  `json:"counterparty"`
+	Amount        float32 `json:"amount"`
+	Height        int     `json:"height"`
+	Confirmations int     `json:"confirmations"`
+	Timestamp     int64   `json:"timestamp"`
+}
+
+// AddressHistory lists the pending transfers of address followed by the
+// confirmed ones, newest first. offset and limit apply to the whole list,
+// the total number of entries is returned with the page.
+func (bc *Blockchain) AddressHistory(address string, offset, limit int) ([]*HistoryEntry, int) {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+
+	var pending []*HistoryEntry
+	for _, t := range bc.transactionPool {
+		if t.senderBlockchainAddress == address || t.recipientBlockchainAddress == address {
+			e := newHistoryEntry(address, t)
+			e.Status = STATUS_PENDING
+			e.Timestamp = t.timeStamp
+			pending = append([]*HistoryEntry{e}, pending...)
+		}
+	}
+
+	locs := bc.index.addresses[address]
+	total := len(pending) + len(locs)
+	entries := make([]*HistoryEntry, 0, limit)
+	for i := offset; i < total && len(entries) < limit; i++ {
+		if i < len(pending) {
+			entries = append(entries, pending[i])
+			continue
+		}
+
+		loc := locs[len(locs)-1-(i-len(pending))]
+		b := bc.chain[loc.Height]
+		t := b.transactions[loc.Index]
+		e := newHistoryEntry(address, t)
+		e.Status = STATUS_CONFIRMED
+		if t.senderBlockchainAddress == bc.params.MiningSender && !bc.isMature(loc.Height) {
+			e.Status = STATUS_IMMATURE
+		}
+		e.Height = loc.Height
+		e.Confirmations = len(bc.chain) - loc.Height
+		e.Timestamp = b.timeStamp
+		entries = append(entries, e)
+	}
+	return entries, total
+}
+
+func newHistoryEntry(address string, t *Transaction) *HistoryEntry {
+	e := &HistoryEntry{
+		ID:     fmt.Sprintf("%x", t.Hash()),
+		Amount: t.value,
+	}
+	switch {
+	case t.senderBlockchainAddress == address && t.recipientBlockchainAddress == address:
+		e.Direction = DIRECTION_SELF
+		e.Counterparty = address
+	case t.senderBlockchainAddress == address:
+		e.Direction = DIRECTION_OUTGOING
+		e.Counterparty = t.recipientBlockchainAddress
+	default:
+		e.Direction = DIRECTION_INCOMING
+		e.Counterparty = t.senderBlockchainAddress
+	}
+	return e
+}
@@ -0,0 +1,385 @@
+package block
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Nico2220/blockchain/events"
+)
+
+// TxLocation is where a transaction sits in the chain.
+type TxLocation struct {
+	Height int
+	Index  int
+}
+
+// chainIndex maps block hashes, transaction IDs and addresses to the
+// position of their blocks and transactions. It is updated every time a
+// block is connected to or disconnected from the tip.
+type chainIndex struct {
+	blocks    map[[32]byte]int
+	txs       map[[32]byte]TxLocation
+	addresses map[string][]TxLocation
+}
+
+func newChainIndex() *chainIndex {
+	return &chainIndex{
+		blocks:    make(map[[32]byte]int),
+		txs:       make(map[[32]byte]TxLocation),
+		addresses: make(map[string][]TxLocation),
+	}
+}
+
+func (ix *chainIndex) connect(b *Block, height int) {
+	ix.blocks[b.Hash()] = height
+	for i, t := range b.transactions {
+		loc := TxLocation{Height: height, Index: i}
+		ix.txs[t.Hash()] = loc
+		ix.addresses[t.senderBlockchainAddress] = append(ix.addresses[t.senderBlockchainAddress], loc)
+		if t.recipientBlockchainAddress != t.senderBlockchainAddress {
+			ix.addresses[t.recipientBlockchainAddress] = append(ix.addresses[t.recipientBlockchainAddress], loc)
+		}
+	}
+}
+
+// disconnect undoes connect. Only the tip is ever disconnected so the
+// entries of b are always the last ones of every address.
+func (ix *chainIndex) disconnect(b *Block, height int) {
+	delete(ix.blocks, b.Hash())
+	for _, t := range b.transactions {
+		delete(ix.txs, t.Hash())
+		for _, address := range []string{t.senderBlockchainAddress, t.recipientBlockchainAddress} {
+			locs := ix.addresses[address]
+			for len(locs) > 0 && locs[len(locs)-1].Height == height {
+				locs = locs[:len(locs)-1]
+			}
+			if len(locs) == 0 {
+				delete(ix.addresses, address)
+			} else {
+				ix.addresses[address] = locs
+			}
+		}
+	}
+}
+
+// prune removes the transactions of b from the index. Blocks are pruned from
+// the oldest on so the entries of b are always the first ones of every
+// address. The block itself stays indexed by its hash.
+func (ix *chainIndex) prune(b *Block, height int) {
+	for _, t := range b.transactions {
+		delete(ix.txs, t.Hash())
+		for _, address := range []string{t.senderBlockchainAddress, t.recipientBlockchainAddress} {
+			locs := ix.addresses[address]
+			for len(locs) > 0 && locs[0].Height == height {
+				locs = locs[1:]
+			}
+			if len(locs) == 0 {
+				delete(ix.addresses, address)
+			} else {
+				ix.addresses[address] = locs
+			}
+		}
+	}
+}
+
+// connectBlock appends b to the chain, indexes it, removes its
+// transactions from the transaction pool and publishes it. Readers see the
+// chain and the pool change at once. Like every function changing the
+// chain, its caller holds mu.
+func (bc *Blockchain) connectBlock(b *Block) {
+	bc.muState.Lock()
+	bc.chain = append(bc.chain, b)
+	height := len(bc.chain) - 1
+	bc.index.connect(b, height)
+	if len(bc.transactionPool) > 0 {
+		mined := make(map[[32]byte]bool, len(b.transactions))
+		for _, t := range b.transactions {
+			mined[t.Hash()] = true
+		}
+		pool := make([]*Transaction, 0, len(bc.transactionPool))
+		for _, t := range bc.transactionPool {
+			if !mined[t.Hash()] {
+				pool = append(pool, t)
+			}
+		}
+		bc.transactionPool = pool
+	}
+	info := bc.blockInfo(height)
+	bc.muState.Unlock()
+
+	if bc.store != nil {
+		if err := bc.store.Append(b); err != nil {
+			storeLog.Error("cannot store block", "height", height, "err", err)
+		}
+		bc.takeSnapshot(height)
+		bc.prune(height)
+	}
+
+	blockHash := fmt.Sprintf("%x", info.Hash)
+	bc.events.Publish(events.Event{
+		Type:      events.NEW_BLOCK,
+		Height:    height,
+		Hash:      blockHash,
+		Addresses: b.Addresses(),
+		Data:      info,
+	})
+
+	for _, t := range b.transactions {
+		if t.recipientBlockchainAddress == t.senderBlockchainAddress {
+			continue
+		}
+		id := fmt.Sprintf("%x", t.Hash())
+		bc.events.Publish(events.Event{
+			Type:      events.PAYMENT_RECEIVED,
+			Height:    height,
+			Hash:      id,
+			Addresses: []string{t.recipientBlockchainAddress},
+			Data: &events.Payment{
+				TransactionID: id,
+				From:          t.senderBlockchainAddress,
+				To:            t.recipientBlockchainAddress,
+				Amount:        t.value,
+				Height:        height,
+				BlockHash:     blockHash,
+			},
+		})
+	}
+}
+
+// disconnectBlock removes the tip from the chain and from the index.
+func (bc *Blockchain) disconnectBlock() *Block {
+	bc.muState.Lock()
+	height := len(bc.chain) - 1
+	b := bc.chain[height]
+	info := bc.blockInfo(height)
+	bc.index.disconnect(b, height)
+	bc.chain = bc.chain[:height]
+	bc.muState.Unlock()
+
+	if bc.store != nil {
+		if err := bc.store.Truncate(height); err != nil {
+			storeLog.Error("cannot remove block from the store", "height", height, "err", err)
+		}
+	}
+
+	bc.events.Publish(events.Event{
+		Type:      events.BLOCK_DISCONNECTED,
+		Height:    height,
+		Hash:      fmt.Sprintf("%x", info.Hash),
+		Addresses: b.Addresses(),
+		Data:      info,
+	})
+	return b
+}
+
+// replaceChain switches to chain, disconnecting the blocks after the fork
+// point and connecting the new ones.
+func (bc *Blockchain) replaceChain(chain []*Block) {
+	fork := 0
+	for fork < len(bc.chain) && fork < len(chain) && bc.chain[fork].Hash() == chain[fork].Hash() {
+		fork++
+	}
+
+	oldTip := bc.LasBlock().Hash()
+	depth := len(bc.chain) - fork
+	for len(bc.chain) > fork {
+		bc.disconnectBlock()
+	}
+	for _, b := range chain[fork:] {
+		bc.connectBlock(b)
+	}
+
+	if depth > 0 {
+		bc.metrics.reorgs.Inc()
+		bc.metrics.reorgDepth.Observe(float64(depth))
+		newTip := bc.LasBlock().Hash()
+		bc.events.Publish(events.Event{
+			Type:   events.REORG,
+			Height: len(bc.chain) - 1,
+			Hash:   fmt.Sprintf("%x", newTip),
+			Data: &events.Reorg{
+				ForkHeight: fork - 1,
+				OldTip:     fmt.Sprintf("%x", oldTip),
+				NewTip:     fmt.Sprintf("%x", newTip),
+				Depth:      depth,
+			},
+		})
+	}
+}
+
+// Events returns the bus the blockchain publishes its events on.
+func (bc *Blockchain) Events() *events.Bus {
+	return bc.events
+}
+
+// Addresses returns every address that sends or receives in the block.
+func (b *Block) Addresses() []string {
+	seen := map[string]bool{}
+	var addresses []string
+	for _, t := range b.transactions {
+		for _, address := range t.Addresses() {
+			if !seen[address] {
+				seen[address] = true
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	return addresses
+}
+
+// Addresses returns the sender and the recipient of the transaction.
+func (t *Transaction) Addresses() []string {
+	if t.senderBlockchainAddress == t.recipientBlockchainAddress {
+		return []string{t.senderBlockchainAddress}
+	}
+	return []string{t.senderBlockchainAddress, t.recipientBlockchainAddress}
+}
+
+// Height returns the height of the tip. The genesis block is at height 0.
+func (bc *Blockchain) Height() int {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+	return len(bc.chain) - 1
+}
+
+func (bc *Blockchain) BlockByHash(hash [32]byte) (*BlockInfo, bool) {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+
+	height, ok := bc.index.blocks[hash]
+	if !ok {
+		return nil, false
+	}
+	return bc.blockInfo(height), true
+}
+
+// LatestBlocks returns up to limit blocks starting offset blocks below the
+// tip, newest first. The page stops at the first block whose body was
+// pruned.
+func (bc *Blockchain) LatestBlocks(offset, limit int) []*BlockInfo {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+
+	blocks := make([]*BlockInfo, 0, limit)
+	for height := len(bc.chain) - 1 - offset; height >= 0 && len(blocks) < limit && bc.chain[height].HasBody(); height-- {
+		blocks = append(blocks, bc.blockInfo(height))
+	}
+	return blocks
+}
+
+// HasTransaction reports whether a transaction with this ID is in the chain
+// or in the transaction pool.
+func (bc *Blockchain) HasTransaction(id [32]byte) bool {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+	return bc.hasTransaction(id)
+}
+
+func (bc *Blockchain) hasTransaction(id [32]byte) bool {
+	if _, ok := bc.index.txs[id]; ok {
+		return true
+	}
+
+	for _, t := range bc.transactionPool {
+		if t.Hash() == id {
+			return true
+		}
+	}
+	return false
+}
+
+// TransactionByID looks a transaction up in the chain, then in the
+// transaction pool.
+func (bc *Blockchain) TransactionByID(id [32]byte) (*TransactionInfo, bool) {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+
+	if loc, ok := bc.index.txs[id]; ok {
+		return bc.transactionInfo(loc), true
+	}
+	for _, t := range bc.transactionPool {
+		if t.Hash() == id {
+			return &TransactionInfo{ID: fmt.Sprintf("%x", id), Transaction: t, Pending: true}, true
+		}
+	}
+	return nil, false
+}
+
+// AddressTransactions returns up to limit confirmed transactions sent or
+// received by address, newest first, skipping the offset newest ones.
+func (bc *Blockchain) AddressTransactions(address string, offset, limit int) ([]*TransactionInfo, int) {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+
+	locs := bc.index.addresses[address]
+	txs := make([]*TransactionInfo, 0, limit)
+	for i := len(locs) - 1 - offset; i >= 0 && len(txs) < limit; i-- {
+		txs = append(txs, bc.transactionInfo(locs[i]))
+	}
+	return txs, len(locs)
+}
+
+type BlockInfo struct {
+	Height        int
+	Hash          [32]byte
+	Confirmations int
+	Block         *Block
+}
+
+func (bi *BlockInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Height        int    `json:"height"`
+		Hash          string `json:"hash"`
+		Confirmations int    `json:"confirmations"`
+		Block         *Block `json:"block"`
+	}{
+		Height:        bi.Height,
+		Hash:          fmt.Sprintf("%x", bi.Hash),
+		Confirmations: bi.Confirmations,
+		Block:         bi.Block,
+	})
+}
+
+// TransactionInfo is a transaction with the block that contains it. Pending
+// transactions have no block.
+type TransactionInfo struct {
+	ID            string       `json:"id"`
+	Transaction   *Transaction `json:"transaction"`
+	Pending       bool         `json:"pending"`
+	BlockHash     string       `json:"block_hash,omitempty"`
+	Height        int          `json:"height"`
+	Confirmations int          `json:"confirmations"`
+}
+
+// BlockByHeight returns the block at height with its hash and confirmations.
+func (bc *Blockchain) BlockByHeight(height int) (*BlockInfo, bool) {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+
+	if height < 0 || height >= len(bc.chain) {
+		return nil, false
+	}
+	return bc.blockInfo(height), true
+}
+
+func (bc *Blockchain) blockInfo(height int) *BlockInfo {
+	b := bc.chain[height]
+	return &BlockInfo{
+		Height:        height,
+		Hash:          b.Hash(),
+		Confirmations: len(bc.chain) - height,
+		Block:         b,
+	}
+}
+
+func (bc *Blockchain) transactionInfo(loc TxLocation) *TransactionInfo {
+	b := bc.chain[loc.Height]
+	t := b.transactions[loc.Index]
+	return &TransactionInfo{
+		ID:            fmt.Sprintf("%x", t.Hash()),
+		Transaction:   t,
+		BlockHash:     fmt.Sprintf("%x", b.Hash()),
+		Height:        loc.Height,
+		Confirmations: len(bc.chain) - loc.Height,
+	}
+}
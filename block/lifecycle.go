@@ -0,0 +1,159 @@
+package block
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MEMPOOL_FILE keeps the pending transactions of a stopped node.
+const MEMPOOL_FILE = "mempool.dat"
+
+// Start syncs with the neighbors, then keeps rescanning them and resolving
+// conflicts with them every NeighborSyncTimeSec in the background until ctx
+// is done or Stop is called. Resolving refreshes the height of the best
+// neighbor, so SyncLag stays current and a node that missed a broadcast
+// catches up.
+func (bc *Blockchain) Start(ctx context.Context) {
+	bc.muLoops.Lock()
+	bc.ctx, bc.cancel = context.WithCancel(ctx)
+	bc.muLoops.Unlock()
+
+	bc.syncWithNeighbors()
+	bc.every(time.Duration(bc.params.NeighborSyncTimeSec)*time.Second, bc.syncWithNeighbors)
+}
+
+func (bc *Blockchain) syncWithNeighbors() {
+	bc.SyncNeighbors()
+	bc.ResolveConfilcts()
+}
+
+// StartMining mines a block with the pending transactions, then again every
+// MiningTimerSec until the chain is stopped. It does nothing when the miner
+// already runs.
+func (bc *Blockchain) StartMining() {
+	if bc.mining.Swap(true) {
+		return
+	}
+	bc.Mining()
+	bc.every(time.Duration(bc.params.MiningTimerSec)*time.Second, func() { bc.Mining() })
+}
+
+// every calls f in the background, interval after the previous call ended on
+// the chain clock, until the chain is stopped.
+func (bc *Blockchain) every(interval time.Duration, f func()) {
+	bc.muLoops.Lock()
+	defer bc.muLoops.Unlock()
+	if bc.ctx == nil {
+		bc.ctx, bc.cancel = context.WithCancel(context.Background())
+	}
+	ctx := bc.ctx
+
+	bc.loops.Add(1)
+	go func() {
+		defer bc.loops.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-bc.clock.After(interval):
+				f()
+			}
+		}
+	}()
+}
+
+// Stop stops the neighbor sync and mining loops and waits for the block
+// being mined, if any.
+func (bc *Blockchain) Stop() {
+	bc.muLoops.Lock()
+	if bc.cancel != nil {
+		bc.cancel()
+	}
+	bc.muLoops.Unlock()
+
+	bc.loops.Wait()
+	bc.mining.Store(false)
+}
+
+// Close stops the chain, saves the transaction pool next to the store and
+// closes it. Subscribers of the events see their channel closed.
+func (bc *Blockchain) Close() error {
+	bc.Stop()
+	bc.events.Close()
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if bc.store == nil {
+		return nil
+	}
+
+	err := bc.store.SaveMempool(bc.transactionPool)
+	if err != nil {
+		err = fmt.Errorf("saving the mempool: %w", err)
+	}
+	return errors.Join(err, bc.store.Close())
+}
+
+// loadMempool adds back the transactions saved by Close. Their signatures
+// were checked when they first came in. Those mined or no longer affordable
+// meanwhile are dropped.
+func (bc *Blockchain) loadMempool(s *Store) error {
+	pool, err := s.LoadMempool()
+	if err != nil {
+		return err
+	}
+	added := 0
+	for _, t := range pool {
+		if t.senderBlockchainAddress != bc.params.MiningSender && bc.admitTransaction(t) {
+			added++
+		}
+	}
+	if len(pool) > 0 {
+		mempoolLog.Info("mempool restored", "transactions", added, "dropped", len(pool)-added)
+	}
+	return nil
+}
+
+// SaveMempool writes the pending transactions: the encoding version, their
+// uvarint count and the transactions without their version byte.
+func (s *Store) SaveMempool(pool []*Transaction) error {
+	buf := binary.AppendUvarint([]byte{ENCODING_VERSION}, uint64(len(pool)))
+	for _, t := range pool {
+		buf = t.appendBinary(buf)
+	}
+
+	path := filepath.Join(s.dir, MEMPOOL_FILE)
+	if err := os.WriteFile(path+".tmp", buf, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// LoadMempool reads the transactions saved by SaveMempool, none when the
+// file doesn't exist.
+func (s *Store) LoadMempool() ([]*Transaction, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, MEMPOOL_FILE))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	d := decoder{data: data}
+	d.version()
+	pool := make([]*Transaction, d.count(minTransactionSize))
+	for i := range pool {
+		pool[i] = &Transaction{}
+		pool[i].decode(&d)
+	}
+	if err := d.finish(); err != nil {
+		return nil, fmt.Errorf("%s: %w", MEMPOOL_FILE, err)
+	}
+	return pool, nil
+}
@@ -0,0 +1,120 @@
+package block
+
+import (
+	"time"
+
+	"github.com/Nico2220/blockchain/metrics"
+)
+
+// Reasons blocks_rejected_total is labelled with.
+const (
+	REJECT_STALE    = "stale"
+	REJECT_PROOF    = "proof"
+	REJECT_COINBASE = "coinbase"
+	REJECT_INVALID  = "invalid"
+)
+
+// Endpoints neighbor_request_failures_total is labelled with.
+const (
+	ENDPOINT_CHAIN        = "chain"
+	ENDPOINT_HEADERS      = "headers"
+	ENDPOINT_SNAPSHOT     = "snapshot"
+	ENDPOINT_TRANSACTIONS = "transactions"
+	ENDPOINT_CONSENSUS    = "consensus"
+)
+
+var reorgDepthBuckets = []float64{1, 2, 3, 5, 10, 20, 50, 100}
+
+// chainMetrics are the counters the blockchain updates as it goes. Gauges
+// that can be read off the chain are computed at scrape time instead.
+type chainMetrics struct {
+	registry *metrics.Registry
+
+	blocksMined      *metrics.Counter
+	blocksRejected   *metrics.Counter
+	reorgs           *metrics.Counter
+	reorgDepth       *metrics.Histogram
+	neighborFailures *metrics.Counter
+	hashes           *metrics.Counter
+	hashrate         *metrics.Gauge
+
+	// bestPeerHeight is the height of the longest chain seen at a neighbor.
+	bestPeerHeight int
+	// neighborsAnswered tells whether a neighbor answered the last conflict
+	// resolution with a valid chain.
+	neighborsAnswered bool
+}
+
+func newChainMetrics(bc *Blockchain) *chainMetrics {
+	r := metrics.NewRegistry()
+	m := &chainMetrics{
+		registry:         r,
+		blocksMined:      r.NewCounter("blockchain_blocks_mined_total", "Blocks mined by the node or submitted by pool miners.", "source"),
+		blocksRejected:   r.NewCounter("blockchain_blocks_rejected_total", "Blocks and peer chains rejected, by reason.", "reason"),
+		reorgs:           r.NewCounter("blockchain_reorgs_total", "Chain reorganisations."),
+		reorgDepth:       r.NewHistogram("blockchain_reorg_depth_blocks", "Blocks disconnected by chain reorganisations.", reorgDepthBuckets),
+		neighborFailures: r.NewCounter("blockchain_neighbor_request_failures_total", "Failed requests to neighbors, by endpoint.", "endpoint"),
+		hashes:           r.NewCounter("blockchain_miner_hashes_total", "Nonces tried by the built-in miner."),
+		hashrate:         r.NewGauge("blockchain_miner_hashrate", "Hashes per second of the last proof of work."),
+	}
+
+	r.NewGaugeFunc("blockchain_height", "Height of the chain tip.", func() float64 {
+		return float64(bc.Height())
+	})
+	r.NewGaugeFunc("blockchain_tip_age_seconds", "Seconds since the chain tip was mined.", func() float64 {
+		return bc.clock.Now().Sub(time.Unix(0, bc.LasBlock().Timestamp())).Seconds()
+	})
+	r.NewGaugeFunc("blockchain_mempool_transactions", "Transactions waiting in the pool.", func() float64 {
+		return float64(len(bc.TransactionPool()))
+	})
+	r.NewGaugeFunc("blockchain_mempool_bytes", "Size of the pooled transactions in the binary encoding.", func() float64 {
+		size := 0
+		for _, t := range bc.TransactionPool() {
+			size += len(t.appendBinary(nil))
+		}
+		return float64(size)
+	})
+	r.NewGaugeFunc("blockchain_peers", "Neighbors the node talks to.", func() float64 {
+		return float64(len(bc.Neighbors()))
+	})
+	r.NewGaugeFunc("blockchain_sync_lag_blocks", "Blocks the best known peer is ahead of the node.", func() float64 {
+		return float64(bc.SyncLag())
+	})
+	return m
+}
+
+// Metrics returns the registry the blockchain metrics are exposed on.
+func (bc *Blockchain) Metrics() *metrics.Registry {
+	return bc.metrics.registry
+}
+
+// SyncLag is the number of blocks the longest chain seen at a neighbor
+// during the last conflict resolution a neighbor answered is ahead of the
+// node.
+func (bc *Blockchain) SyncLag() int {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+	return max(0, bc.metrics.bestPeerHeight-(len(bc.chain)-1))
+}
+
+// NeighborsAnswered reports whether a neighbor answered the last conflict
+// resolution with a valid chain. When none did, SyncLag is as old as the
+// last resolution one answered.
+func (bc *Blockchain) NeighborsAnswered() bool {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+	return bc.metrics.neighborsAnswered
+}
+
+// rejectReason maps the errors of SubmitWork to a blocks_rejected_total label.
+func rejectReason(err error) string {
+	switch err {
+	case ErrStaleWork:
+		return REJECT_STALE
+	case ErrInvalidProof:
+		return REJECT_PROOF
+	case ErrInvalidCoinbase:
+		return REJECT_COINBASE
+	}
+	return REJECT_INVALID
+}
@@ -0,0 +1,241 @@
+package block
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// MAX_HALVINGS is the number of halvings after which blocks stop paying a
+// subsidy, which caps the supply.
+const MAX_HALVINGS = 32
+
+// NetworkParams holds everything that has to be identical on every node of a
+// network: consensus rules, the default peer discovery ranges and the genesis
+// block.
+type NetworkParams struct {
+	Name                 string
+	MiningDifficulty     int
+	MiningReward         float32
+	HalvingInterval      int
+	CoinbaseMaturity     int
+	MiningSender         string
+	MiningTimerSec       int
+	PortRangeStart       int
+	PortRangeEnd         int
+	NeighborIPRangeStart int
+	NeighborIPRangeEnd   int
+	NeighborSyncTimeSec  int
+	SnapshotInterval     int
+	Genesis              *Genesis
+}
+
+// Allocation is a balance credited to an address by the genesis block.
+type Allocation struct {
+	Address string  `json:"address"`
+	Amount  float32 `json:"amount"`
+}
+
+// Genesis describes the first block of a network. Two nodes that load the
+// same Genesis always build the same genesis block.
+type Genesis struct {
+	Network     string        `json:"network"`
+	Timestamp   int64         `json:"timestamp"`
+	Nonce       int           `json:"nonce"`
+	Allocations []*Allocation `json:"allocations"`
+}
+
+func MainnetParams() *NetworkParams {
+	return &NetworkParams{
+		Name:                 "mainnet",
+		MiningDifficulty:     3,
+		MiningReward:         1.0,
+		HalvingInterval:      100000,
+		CoinbaseMaturity:     100,
+		MiningSender:         "THE BLOCKCHAIN",
+		MiningTimerSec:       20,
+		PortRangeStart:       5001,
+		PortRangeEnd:         5003,
+		NeighborIPRangeStart: 0,
+		NeighborIPRangeEnd:   1,
+		NeighborSyncTimeSec:  20,
+		SnapshotInterval:     1000,
+		Genesis:              &Genesis{Network: "mainnet", Timestamp: 1735689600000000000},
+	}
+}
+
+func TestnetParams() *NetworkParams {
+	return &NetworkParams{
+		Name:                 "testnet",
+		MiningDifficulty:     2,
+		MiningReward:         1.0,
+		HalvingInterval:      1000,
+		CoinbaseMaturity:     20,
+		MiningSender:         "THE BLOCKCHAIN",
+		MiningTimerSec:       10,
+		PortRangeStart:       6001,
+		PortRangeEnd:         6003,
+		NeighborIPRangeStart: 0,
+		NeighborIPRangeEnd:   1,
+		NeighborSyncTimeSec:  10,
+		SnapshotInterval:     100,
+		Genesis:              &Genesis{Network: "testnet", Timestamp: 1735689600000000001},
+	}
+}
+
+// RegtestParams is meant for local testing: blocks are cheap to mine and
+// only the local machine is scanned for neighbors.
+func RegtestParams() *NetworkParams {
+	return &NetworkParams{
+		Name:                 "regtest",
+		MiningDifficulty:     1,
+		MiningReward:         1.0,
+		HalvingInterval:      150,
+		CoinbaseMaturity:     10,
+		MiningSender:         "THE BLOCKCHAIN",
+		MiningTimerSec:       5,
+		PortRangeStart:       7001,
+		PortRangeEnd:         7003,
+		NeighborIPRangeStart: 0,
+		NeighborIPRangeEnd:   0,
+		NeighborSyncTimeSec:  5,
+		SnapshotInterval:     10,
+		Genesis:              &Genesis{Network: "regtest", Timestamp: 1735689600000000002},
+	}
+}
+
+// Subsidy returns the block reward of the block at height. It starts at
+// MiningReward and halves every HalvingInterval blocks until MAX_HALVINGS.
+// The genesis block has no subsidy, only allocations.
+func (p *NetworkParams) Subsidy(height int) float32 {
+	if height <= 0 {
+		return 0
+	}
+	if p.HalvingInterval <= 0 {
+		return p.MiningReward
+	}
+
+	halvings := (height - 1) / p.HalvingInterval
+	if halvings >= MAX_HALVINGS {
+		return 0
+	}
+	return p.MiningReward / float32(uint64(1)<<halvings)
+}
+
+// NextHalving returns the height of the first block after height whose
+// subsidy is halved, or 0 when the subsidy never changes again.
+func (p *NetworkParams) NextHalving(height int) int {
+	if p.HalvingInterval <= 0 {
+		return 0
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	halvings := (height-1)/p.HalvingInterval + 1
+	if halvings > MAX_HALVINGS {
+		return 0
+	}
+	return halvings*p.HalvingInterval + 1
+}
+
+// MaxSupply returns the genesis allocations plus every subsidy that will ever
+// be paid. It is unbounded (0) when the subsidy never halves.
+func (p *NetworkParams) MaxSupply() float64 {
+	if p.HalvingInterval <= 0 {
+		return 0
+	}
+
+	var supply float64
+	for _, a := range p.Genesis.Allocations {
+		supply += float64(a.Amount)
+	}
+	for halving := 0; halving < MAX_HALVINGS; halving++ {
+		supply += float64(p.HalvingInterval) * float64(p.Subsidy(halving*p.HalvingInterval+1))
+	}
+	return supply
+}
+
+// NetworkParamsByName returns the preset called name. An empty name selects
+// mainnet.
+func NetworkParamsByName(name string) (*NetworkParams, error) {
+	switch name {
+	case "", "mainnet":
+		return MainnetParams(), nil
+	case "testnet":
+		return TestnetParams(), nil
+	case "regtest":
+		return RegtestParams(), nil
+	}
+	return nil, fmt.Errorf("unknown network %q", name)
+}
+
+// LoadGenesis reads a genesis JSON file.
+func LoadGenesis(path string) (*Genesis, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var g Genesis
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, fmt.Errorf("genesis %s: %w", path, err)
+	}
+	for i, a := range g.Allocations {
+		if a == nil || a.Address == "" {
+			return nil, fmt.Errorf("genesis %s: allocation %d has no address", path, i)
+		}
+		if a.Amount <= 0 {
+			return nil, fmt.Errorf("genesis %s: allocation %d has a non positive amount", path, i)
+		}
+	}
+	return &g, nil
+}
+
+// LoadNetworkParams returns the preset of the network named in the genesis
+// file at path, using the genesis of the file instead of the preset one.
+// When path is empty the preset called name is returned unchanged.
+func LoadNetworkParams(name, path string) (*NetworkParams, error) {
+	if path == "" {
+		return NetworkParamsByName(name)
+	}
+
+	g, err := LoadGenesis(path)
+	if err != nil {
+		return nil, err
+	}
+	if name != "" && g.Network != "" && name != g.Network {
+		return nil, fmt.Errorf("genesis %s is for network %q, not %q", path, g.Network, name)
+	}
+	if g.Network == "" {
+		g.Network = name
+	}
+
+	params, err := NetworkParamsByName(g.Network)
+	if err != nil {
+		return nil, err
+	}
+	params.Genesis = g
+	return params, nil
+}
+
+// GenesisBlock builds the genesis block. Allocations are paid by the mining
+// sender, sorted by address so the block never depends on the file order.
+func (p *NetworkParams) GenesisBlock() *Block {
+	allocations := append([]*Allocation{}, p.Genesis.Allocations...)
+	sort.SliceStable(allocations, func(i, j int) bool {
+		return allocations[i].Address < allocations[j].Address
+	})
+
+	transactions := make([]*Transaction, 0, len(allocations))
+	for _, a := range allocations {
+		transactions = append(transactions, NewTransactionAt(p.MiningSender, a.Address, a.Amount, p.Genesis.Timestamp))
+	}
+
+	return &Block{
+		timeStamp:    p.Genesis.Timestamp,
+		nonce:        p.Genesis.Nonce,
+		transactions: transactions,
+	}
+}
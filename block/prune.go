@@ -0,0 +1,84 @@
+package block
+
+// MIN_PRUNE_BLOCKS is the fewest recent bodies a pruned node keeps, so that
+// it can still follow short reorgs.
+const MIN_PRUNE_BLOCKS = 10
+
+// Limits tells peers which blocks a node can serve. Pruned nodes and nodes
+// synced from a snapshot only have the bodies from FirstAvailable on, and
+// the headers of the blocks before.
+type Limits struct {
+	Pruned         bool `json:"pruned"`
+	KeepBlocks     int  `json:"keep_blocks"`
+	FirstAvailable int  `json:"first_available"`
+	Height         int  `json:"height"`
+}
+
+// SetPruning makes the node keep only the last keep block bodies, with the
+// headers of every block and a snapshot of the state before the kept
+// bodies. Bodies are dropped a SnapshotInterval at a time, so the node holds
+// from keep to keep+SnapshotInterval-1 of them. 0 keeps every block. Call it
+// before LoadStore.
+func (bc *Blockchain) SetPruning(keep int) {
+	bc.keepBlocks = keep
+}
+
+func (bc *Blockchain) Limits() *Limits {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+	first := bc.firstBody()
+	return &Limits{
+		Pruned:         first > 0,
+		KeepBlocks:     bc.keepBlocks,
+		FirstAvailable: first,
+		Height:         len(bc.chain) - 1,
+	}
+}
+
+// prune drops the bodies that fell more than keepBlocks below tip, up to the
+// last multiple of SnapshotInterval. It runs on every connected block but
+// only drops bodies when that height moves, once every SnapshotInterval
+// blocks. The state at the last pruned height becomes the base snapshot,
+// saved before the store is rewritten so that a crash in between leaves a
+// usable store.
+func (bc *Blockchain) prune(tip int) {
+	if bc.keepBlocks <= 0 {
+		return
+	}
+	height := tip - bc.keepBlocks
+	if interval := bc.params.SnapshotInterval; interval > 0 {
+		height = height / interval * interval
+	}
+	first := bc.FirstBody()
+	if height <= 0 || height < first {
+		return
+	}
+
+	s, err := bc.StateAt(height)
+	if err != nil {
+		storeLog.Error("cannot snapshot before pruning", "height", height, "err", err)
+		return
+	}
+	if err := bc.store.SaveSnapshot(BASE_SNAPSHOT_FILE, s); err != nil {
+		storeLog.Error("cannot save the snapshot", "height", height, "err", err)
+		return
+	}
+
+	bc.muState.Lock()
+	for h := max(first, 1); h <= height; h++ {
+		bc.index.prune(bc.chain[h], h)
+		bc.chain[h] = bc.chain[h].Header()
+	}
+	bc.base = s
+	if bc.snapshot == nil || bc.snapshot.Height < height {
+		bc.snapshot = s
+	}
+	blocks := append([]*Block{}, bc.chain...)
+	bc.muState.Unlock()
+
+	if err := bc.store.Rewrite(blocks); err != nil {
+		storeLog.Error("cannot rewrite the store after pruning", "err", err)
+		return
+	}
+	storeLog.Info("pruned", "height", height, "first_available", height+1)
+}
@@ -0,0 +1,81 @@
+package block
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPruning(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := NewBlockchain(testBob, 0, testParams())
+	bc.SetPruning(MIN_PRUNE_BLOCKS)
+	if err := bc.LoadStore(store); err != nil {
+		t.Fatal(err)
+	}
+
+	reference := NewBlockchain(testBob, 0, testParams())
+	firstTx := [32]byte{}
+	for i := 0; i < 30; i++ {
+		send(bc, testAlice, testBob, 1)
+		if !bc.Mining() {
+			t.Fatalf("block %d not mined", i)
+		}
+		if i == 0 {
+			firstTx = bc.LasBlock().Transactions()[0].Hash()
+		}
+		if err := reference.AddBlock(bc.LasBlock()); err != nil {
+			t.Fatal(err)
+		}
+		if l := bc.Limits(); l.Pruned {
+			if kept := l.Height - l.FirstAvailable + 1; kept < MIN_PRUNE_BLOCKS || kept >= MIN_PRUNE_BLOCKS+testParams().SnapshotInterval {
+				t.Fatalf("height %d: %d bodies kept", l.Height, kept)
+			}
+		}
+	}
+
+	// At height 30 the bodies up to the last multiple of the snapshot
+	// interval 10 blocks below are dropped.
+	if got := bc.Limits(); !got.Pruned || got.FirstAvailable != 21 || got.Height != 30 {
+		t.Fatalf("limits %+v", got)
+	}
+	if bc.Chain()[20].HasBody() || !bc.Chain()[21].HasBody() || !bc.Chain()[0].HasBody() {
+		t.Fatal("wrong bodies pruned")
+	}
+	if _, ok := bc.TransactionByID(firstTx); ok {
+		t.Fatal("pruned transaction still indexed")
+	}
+	if _, err := bc.ChainFrom(5); !errors.Is(err, ErrNoBody) {
+		t.Fatalf("served pruned blocks: %v", err)
+	}
+	// Heights 25 down to 21 have a body, 20 and below don't.
+	if page := bc.LatestBlocks(5, 10); len(page) != 5 || page[len(page)-1].Height != 21 {
+		t.Fatalf("page of %d blocks across the first body", len(page))
+	}
+	for _, address := range []string{testAlice, testBob} {
+		if got, want := *bc.CalculateBalance(address), *reference.CalculateBalance(address); got != want {
+			t.Errorf("%s: balance %+v, want %+v", address, got, want)
+		}
+	}
+	store.Close()
+
+	store, err = OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	reopened := NewBlockchain(testBob, 0, testParams())
+	reopened.SetPruning(MIN_PRUNE_BLOCKS)
+	if err := reopened.LoadStore(store); err != nil {
+		t.Fatal(err)
+	}
+	if reopened.LasBlock().Hash() != bc.LasBlock().Hash() || reopened.FirstBody() != 21 {
+		t.Fatal("pruned store reloaded differently")
+	}
+	if got, want := *reopened.CalculateBalance(testBob), *reference.CalculateBalance(testBob); got != want {
+		t.Errorf("balance %+v after a restart, want %+v", got, want)
+	}
+}
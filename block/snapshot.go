@@ -0,0 +1,439 @@
+package block
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	// SNAPSHOT_FILE holds the latest snapshot taken by the node, the one it
+	// serves to its peers.
+	SNAPSHOT_FILE = "snapshot.dat"
+	// BASE_SNAPSHOT_FILE holds the snapshot the stored bodies start after,
+	// when the node did not download the blocks before it.
+	BASE_SNAPSHOT_FILE = "base_snapshot.dat"
+
+	// SNAPSHOT_CONFIRMATIONS is how deep a block must be before the state at
+	// its height is snapshotted, so that snapshots survive short reorgs.
+	SNAPSHOT_CONFIRMATIONS = 6
+)
+
+var ErrSnapshotMismatch = errors.New("snapshot does not match the chain")
+
+// Snapshot is the state of the chain at Height: the balance of every
+// address, the number of transactions it sent and the block rewards that
+// were still immature. Accounts are sorted by address so that a state has a
+// single encoding, whose sha256 is the commitment of the snapshot.
+//
+// A node synced from a snapshot does not index the transactions confirmed
+// before it, so it can't tell when one of them is sent again.
+type Snapshot struct {
+	Height    int
+	BlockHash [32]byte
+	Supply    float64
+	Accounts  []*Account
+}
+
+type Account struct {
+	Address string
+	Balance float32
+	Nonce   uint64
+	Rewards []*Reward
+}
+
+// Reward is a block reward that was not mature at the height of the
+// snapshot.
+type Reward struct {
+	Height int
+	Amount float32
+}
+
+// Account returns the account of address, nil when it has none.
+func (s *Snapshot) Account(address string) *Account {
+	i := sort.Search(len(s.Accounts), func(i int) bool { return s.Accounts[i].Address >= address })
+	if i < len(s.Accounts) && s.Accounts[i].Address == address {
+		return s.Accounts[i]
+	}
+	return nil
+}
+
+// Commitment is the sha256 of the binary encoding of the snapshot.
+func (s *Snapshot) Commitment() [32]byte {
+	data, _ := s.MarshalBinary()
+	return sha256.Sum256(data)
+}
+
+// MarshalBinary encodes the snapshot as its height, block hash, supply as
+// a little endian float64 and its accounts, each one as its address,
+// balance, nonce and immature rewards. It never fails.
+func (s *Snapshot) MarshalBinary() ([]byte, error) {
+	buf := []byte{ENCODING_VERSION}
+	buf = binary.AppendUvarint(buf, uint64(s.Height))
+	buf = append(buf, s.BlockHash[:]...)
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(s.Supply))
+	buf = binary.AppendUvarint(buf, uint64(len(s.Accounts)))
+	for _, a := range s.Accounts {
+		buf = appendString(buf, a.Address)
+		buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(a.Balance))
+		buf = binary.AppendUvarint(buf, a.Nonce)
+		buf = binary.AppendUvarint(buf, uint64(len(a.Rewards)))
+		for _, r := range a.Rewards {
+			buf = binary.AppendUvarint(buf, uint64(r.Height))
+			buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(r.Amount))
+		}
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a snapshot. Accounts must be sorted and rewards
+// no higher than the snapshot.
+func (s *Snapshot) UnmarshalBinary(data []byte) error {
+	d := decoder{data: data}
+	d.version()
+	s.Height = d.int()
+	copy(s.BlockHash[:], d.bytes(32))
+	s.Supply = math.Float64frombits(binary.LittleEndian.Uint64(d.bytes(8)))
+
+	// An account takes at least its address, balance, nonce and count.
+	s.Accounts = make([]*Account, d.count(1+4+1+1))
+	for i := range s.Accounts {
+		a := &Account{Address: d.string()}
+		a.Balance = math.Float32frombits(binary.LittleEndian.Uint32(d.bytes(4)))
+		a.Nonce = d.uvarint()
+		a.Rewards = make([]*Reward, d.count(1+4))
+		for j := range a.Rewards {
+			r := &Reward{Height: d.int()}
+			r.Amount = math.Float32frombits(binary.LittleEndian.Uint32(d.bytes(4)))
+			if d.err == nil && (r.Height > s.Height || j > 0 && r.Height < a.Rewards[j-1].Height) {
+				d.fail(fmt.Errorf("account %s: reward at height %d out of order", a.Address, r.Height))
+			}
+			a.Rewards[j] = r
+		}
+		if d.err == nil && i > 0 && a.Address <= s.Accounts[i-1].Address {
+			d.fail(fmt.Errorf("account %s out of order", a.Address))
+		}
+		s.Accounts[i] = a
+	}
+	return d.finish()
+}
+
+func (s *Snapshot) MarshalJSON() ([]byte, error) {
+	type reward struct {
+		Height int     `json:"height"`
+		Amount float32 `json:"amount"`
+	}
+	type account struct {
+		Address string   `json:"address"`
+		Balance float32  `json:"balance"`
+		Nonce   uint64   `json:"nonce"`
+		Rewards []reward `json:"immature_rewards"`
+	}
+	accounts := make([]account, len(s.Accounts))
+	for i, a := range s.Accounts {
+		accounts[i] = account{Address: a.Address, Balance: a.Balance, Nonce: a.Nonce, Rewards: []reward{}}
+		for _, r := range a.Rewards {
+			accounts[i].Rewards = append(accounts[i].Rewards, reward{r.Height, r.Amount})
+		}
+	}
+	return json.Marshal(struct {
+		Height     int       `json:"height"`
+		BlockHash  string    `json:"block_hash"`
+		Commitment string    `json:"commitment"`
+		Supply     float64   `json:"supply"`
+		Accounts   []account `json:"accounts"`
+	}{
+		Height:     s.Height,
+		BlockHash:  fmt.Sprintf("%x", s.BlockHash),
+		Commitment: fmt.Sprintf("%x", s.Commitment()),
+		Supply:     s.Supply,
+		Accounts:   accounts,
+	})
+}
+
+// StateAt builds the snapshot of the state at height by replaying the
+// blocks from the base snapshot, or from the genesis block.
+func (bc *Blockchain) StateAt(height int) (*Snapshot, error) {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+
+	if height < 0 || height >= len(bc.chain) {
+		return nil, fmt.Errorf("no block at height %d", height)
+	}
+	start := 0
+	accounts := make(map[string]*Account)
+	var supply float64
+	if bc.base != nil {
+		if height < bc.base.Height {
+			return nil, fmt.Errorf("block %d: %w", height, ErrNoBody)
+		}
+		start = bc.base.Height + 1
+		supply = bc.base.Supply
+		for _, a := range bc.base.Accounts {
+			copied := *a
+			copied.Rewards = append([]*Reward{}, a.Rewards...)
+			accounts[a.Address] = &copied
+		}
+	}
+	account := func(address string) *Account {
+		a, ok := accounts[address]
+		if !ok {
+			a = &Account{Address: address}
+			accounts[address] = a
+		}
+		return a
+	}
+
+	// Follow the order of CalculateBalance so that the float32 sums stay
+	// exactly the same.
+	for h := start; h <= height; h++ {
+		for _, t := range bc.chain[h].transactions {
+			recipient := account(t.recipientBlockchainAddress)
+			recipient.Balance += t.value
+			if t.senderBlockchainAddress == bc.params.MiningSender {
+				supply += float64(t.value)
+				if h > 0 {
+					recipient.Rewards = append(recipient.Rewards, &Reward{Height: h, Amount: t.value})
+				}
+			}
+			sender := account(t.senderBlockchainAddress)
+			sender.Balance -= t.value
+			sender.Nonce++
+		}
+	}
+
+	s := &Snapshot{Height: height, BlockHash: bc.chain[height].Hash(), Supply: supply}
+	for _, a := range accounts {
+		immature := a.Rewards[:0]
+		for _, r := range a.Rewards {
+			if height-r.Height < bc.params.CoinbaseMaturity {
+				immature = append(immature, r)
+			}
+		}
+		a.Rewards = immature
+		if a.Balance != 0 || a.Nonce != 0 || len(a.Rewards) > 0 {
+			s.Accounts = append(s.Accounts, a)
+		}
+	}
+	sort.Slice(s.Accounts, func(i, j int) bool { return s.Accounts[i].Address < s.Accounts[j].Address })
+	return s, nil
+}
+
+// Snapshot returns the latest snapshot of the node, nil before the first
+// one.
+func (bc *Blockchain) Snapshot() *Snapshot {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+	return bc.snapshot
+}
+
+// Base returns the snapshot the chain bodies start after, nil when the node
+// has every block since the genesis.
+func (bc *Blockchain) Base() *Snapshot {
+	bc.muState.RLock()
+	defer bc.muState.RUnlock()
+	return bc.base
+}
+
+// snapshotHeight returns the height of the latest snapshot due with the
+// chain ending at tip. Snapshots are taken every SnapshotInterval blocks,
+// SNAPSHOT_CONFIRMATIONS blocks late.
+func (bc *Blockchain) snapshotHeight(tip int) int {
+	if bc.params.SnapshotInterval <= 0 || tip < SNAPSHOT_CONFIRMATIONS {
+		return 0
+	}
+	return (tip - SNAPSHOT_CONFIRMATIONS) / bc.params.SnapshotInterval * bc.params.SnapshotInterval
+}
+
+// takeSnapshot snapshots the state at the height due with the chain ending
+// at tip, unless it already has.
+func (bc *Blockchain) takeSnapshot(tip int) {
+	height := bc.snapshotHeight(tip)
+	if height == 0 {
+		return
+	}
+	if s := bc.Snapshot(); s != nil && s.Height >= height {
+		if s.Height > height || s.BlockHash == bc.chain[height].Hash() {
+			return
+		}
+	}
+	if base := bc.Base(); base != nil && height <= base.Height {
+		return
+	}
+
+	s, err := bc.StateAt(height)
+	if err != nil {
+		storeLog.Error("cannot snapshot", "height", height, "err", err)
+		return
+	}
+	if err := bc.store.SaveSnapshot(SNAPSHOT_FILE, s); err != nil {
+		storeLog.Error("cannot save the snapshot", "height", height, "err", err)
+		return
+	}
+	bc.muState.Lock()
+	bc.snapshot = s
+	bc.muState.Unlock()
+	storeLog.Info("snapshot taken", "height", height, "commitment", fmt.Sprintf("%x", s.Commitment()), "accounts", len(s.Accounts))
+}
+
+// loadSnapshots reads the snapshots of the store before its blocks are
+// connected. The base snapshot is required as soon as a block has no body.
+func (bc *Blockchain) loadSnapshots(s *Store, blocks []*Block) error {
+	headerOnly := false
+	for _, b := range blocks {
+		headerOnly = headerOnly || !b.HasBody()
+	}
+	if headerOnly {
+		base, err := s.LoadSnapshot(BASE_SNAPSHOT_FILE)
+		if err != nil {
+			return fmt.Errorf("store: blocks without body need the base snapshot: %w", err)
+		}
+		bc.muState.Lock()
+		bc.base = base
+		bc.snapshot = base
+		bc.muState.Unlock()
+	}
+
+	latest, err := s.LoadSnapshot(SNAPSHOT_FILE)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	if latest.Height < len(blocks) && blocks[latest.Height].Hash() == latest.BlockHash &&
+		(bc.snapshot == nil || latest.Height > bc.snapshot.Height) {
+		bc.muState.Lock()
+		bc.snapshot = latest
+		bc.muState.Unlock()
+	}
+	return nil
+}
+
+// SaveSnapshot writes s to the file name of the data directory.
+func (s *Store) SaveSnapshot(name string, snapshot *Snapshot) error {
+	data, _ := snapshot.MarshalBinary()
+	path := filepath.Join(s.dir, name)
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// LoadSnapshot reads the snapshot saved as name.
+func (s *Store) LoadSnapshot(name string) (*Snapshot, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{}
+	if err := snapshot.UnmarshalBinary(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return snapshot, nil
+}
+
+// FastSync starts a new node from the snapshot of its neighbors whose
+// commitment is trusted instead of downloading and replaying every block.
+// The commitment must come from the operator: a snapshot is not committed to
+// by any block, so neither the headers nor the number of neighbors serving
+// it can vouch for it. The snapshot names the hash of its block, so the
+// blocks synced after it, which are validated as usual, extend the trusted
+// state. The headers before it only have to link from the genesis block to
+// that hash.
+func (bc *Blockchain) FastSync(trusted [32]byte) error {
+	if bc.Height() > 0 {
+		return nil
+	}
+	bc.SyncNeighbors()
+
+	for _, n := range bc.Neighbors() {
+		s, err := bc.fetchSnapshot(n)
+		if err != nil {
+			bc.metrics.neighborFailures.Inc(ENDPOINT_SNAPSHOT)
+			p2pLog.Warn("cannot fetch the snapshot", "neighbor", n, "err", err)
+			continue
+		}
+		if commitment := s.Commitment(); commitment != trusted {
+			consensusLog.Warn("untrusted snapshot", "neighbor", n, "commitment", fmt.Sprintf("%x", commitment), "height", s.Height)
+			continue
+		}
+
+		headers, err := bc.fetchHeaders(n, s.Height)
+		if err == nil && headers[s.Height].Hash() != s.BlockHash {
+			err = fmt.Errorf("%w: header %d is not the block of the snapshot", ErrSnapshotMismatch, s.Height)
+		}
+		if err != nil {
+			bc.metrics.neighborFailures.Inc(ENDPOINT_HEADERS)
+			p2pLog.Warn("cannot fetch the headers", "neighbor", n, "err", err)
+			continue
+		}
+
+		if err := bc.installSnapshot(s, headers); err != nil {
+			return fmt.Errorf("fast sync: %w", err)
+		}
+		consensusLog.Info("fast synced", "height", s.Height, "commitment", fmt.Sprintf("%x", trusted), "neighbor", n)
+		bc.ResolveConfilcts()
+		return nil
+	}
+	return fmt.Errorf("fast sync: no neighbor serves the snapshot %x", trusted)
+}
+
+// installSnapshot makes s the base of a chain that only holds the genesis
+// block, with headers up to the block of s.
+func (bc *Blockchain) installSnapshot(s *Snapshot, headers []*Block) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if len(bc.chain) != 1 {
+		return errors.New("the chain is not empty")
+	}
+	if bc.store != nil {
+		if err := bc.store.SaveSnapshot(BASE_SNAPSHOT_FILE, s); err != nil {
+			return err
+		}
+	}
+	bc.muState.Lock()
+	bc.base = s
+	bc.snapshot = s
+	bc.muState.Unlock()
+	for _, h := range headers[1:] {
+		bc.connectBlock(h.Header())
+	}
+	return nil
+}
+
+// fetchSnapshot downloads the latest snapshot of a neighbor.
+func (bc *Blockchain) fetchSnapshot(neighbor string) (*Snapshot, error) {
+	req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/snapshot", neighbor), nil)
+	req.Header.Set("Accept", BINARY_CONTENT_TYPE)
+	response, err := bc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("snapshot request answered %s", response.Status)
+	}
+	if response.Header.Get("Content-Type") != BINARY_CONTENT_TYPE {
+		return nil, errors.New("snapshot not served in the binary encoding")
+	}
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{}
+	if err := s.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
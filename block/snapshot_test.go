@@ -0,0 +1,168 @@
+package block
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	source := testChain(t, 12)
+	s, err := source.StateAt(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Account(testBob) == nil || len(s.Account(testBob).Rewards) == 0 {
+		t.Fatal("snapshot lost the immature rewards")
+	}
+
+	data, _ := s.MarshalBinary()
+	var decoded Snapshot
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Commitment() != s.Commitment() {
+		t.Fatal("commitment changed after a round trip")
+	}
+
+	for i := range data {
+		tampered := append([]byte{}, data...)
+		tampered[i] ^= 1
+		var other Snapshot
+		if other.UnmarshalBinary(tampered) == nil && other.Commitment() == s.Commitment() {
+			t.Fatalf("tampering with byte %d kept the commitment", i)
+		}
+	}
+}
+
+// TestSnapshotSync checks that a node started from a snapshot and fed the
+// blocks after it ends with exactly the balances of a node that replayed
+// every block.
+func TestSnapshotSync(t *testing.T) {
+	source := testChain(t, 15)
+	const height = 8
+	s, _ := source.StateAt(height)
+
+	bc := NewBlockchain(testBob, 0, testParams())
+	if err := bc.installSnapshot(s, source.Chain()[:height+1]); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range source.Chain()[height+1:] {
+		if err := bc.AddBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, address := range []string{testAlice, testBob, testParams().MiningSender} {
+		if got, want := *bc.CalculateBalance(address), *source.CalculateBalance(address); got != want {
+			t.Errorf("%s: balance %+v, want %+v", address, got, want)
+		}
+		if got, want := bc.CalculateTotalAmount(address), source.CalculateTotalAmount(address); got != want {
+			t.Errorf("%s: total %v, want %v", address, got, want)
+		}
+	}
+	if got, want := bc.Supply().Circulating, source.Supply().Circulating; got != want {
+		t.Errorf("supply %v, want %v", got, want)
+	}
+	if err := bc.VerifyChain(source.Chain()); err != nil {
+		t.Errorf("full chain rejected: %v", err)
+	}
+	if _, err := bc.ChainFrom(0); !errors.Is(err, ErrNoBody) {
+		t.Errorf("served blocks before the snapshot: %v", err)
+	}
+
+	later, _ := bc.StateAt(14)
+	want, _ := source.StateAt(14)
+	if later.Commitment() != want.Commitment() {
+		t.Error("snapshot taken after a fast sync differs")
+	}
+}
+
+func TestSnapshotStore(t *testing.T) {
+	source := testChain(t, 16)
+	s, _ := source.StateAt(8)
+
+	dir := t.TempDir()
+	store, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := NewBlockchain(testBob, 0, testParams())
+	bc.LoadStore(store)
+	bc.installSnapshot(s, source.Chain()[:9])
+	for _, b := range source.Chain()[9:] {
+		bc.AddBlock(b)
+	}
+	store.Close()
+
+	// Block 16 made the state at height 10 SNAPSHOT_CONFIRMATIONS deep.
+	store, err = OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	reopened := NewBlockchain(testBob, 0, testParams())
+	if err := reopened.LoadStore(store); err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Base() == nil || reopened.Base().Commitment() != s.Commitment() {
+		t.Fatal("base snapshot not reloaded")
+	}
+	if got, want := *reopened.CalculateBalance(testAlice), *source.CalculateBalance(testAlice); got != want {
+		t.Fatalf("balance %+v after a restart, want %+v", got, want)
+	}
+	if reopened.Snapshot() == nil || reopened.Snapshot().Height != 10 {
+		t.Fatal("periodic snapshot not reloaded")
+	}
+	if reopened.Chain()[3].HasBody() {
+		t.Fatal("block before the snapshot has a body")
+	}
+}
+
+// snapshotNeighbor serves s and the headers of source like a node does.
+func snapshotNeighbor(t *testing.T, source *Blockchain, s *Snapshot) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/snapshot":
+			data, _ := s.MarshalBinary()
+			w.Header().Set("Content-Type", BINARY_CONTENT_TYPE)
+			w.Write(data)
+		case "/headers":
+			from, _ := strconv.Atoi(r.URL.Query().Get("from"))
+			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+			json.NewEncoder(w).Encode(map[string]any{"headers": source.Headers(from, limit)})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestFastSync(t *testing.T) {
+	source := testChain(t, 12)
+	s, _ := source.StateAt(8)
+	forged, _ := source.StateAt(8)
+	forged.Accounts[0].Balance += 100
+
+	bc := NewBlockchain(testBob, 0, testParams())
+	bc.SetPeers([]string{snapshotNeighbor(t, source, forged)})
+	if err := bc.FastSync(s.Commitment()); err == nil || bc.Height() != 0 {
+		t.Fatalf("synced to height %d from a snapshot that is not the trusted one: %v", bc.Height(), err)
+	}
+
+	bc.SetPeers([]string{snapshotNeighbor(t, source, forged), snapshotNeighbor(t, source, s)})
+	if err := bc.FastSync(s.Commitment()); err != nil {
+		t.Fatal(err)
+	}
+	if bc.Base() == nil || bc.Base().Commitment() != s.Commitment() || bc.Height() != 8 {
+		t.Fatalf("synced to height %d, want the trusted snapshot at height 8", bc.Height())
+	}
+	if got, want := bc.CalculateBalance(testAlice).Amount, s.Account(testAlice).Balance; got != want {
+		t.Fatalf("balance %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,264 @@
+package block
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const BLOCKS_FILE = "blocks.dat"
+
+var ErrNoStore = errors.New("the chain has no block store")
+
+// Store keeps the chain in the data directory. The file starts with the
+// encoding version followed by one record per block: its uvarint length,
+// then its binary encoding without the version byte. A block known only by
+// its header has a record of length 0 followed by the uvarint length and the
+// encoding of its header. Blocks are only ever appended to or truncated from
+// the end, like the chain itself.
+type Store struct {
+	dir     string
+	f       *os.File
+	offsets []int64
+	size    int64
+	blocks  []*Block
+}
+
+// OpenStore opens or creates the block store of dir. A record cut short by
+// a crash is dropped.
+func OpenStore(dir string) (*Store, error) {
+	f, err := os.OpenFile(filepath.Join(dir, BLOCKS_FILE), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{dir: dir, f: f}
+	if err := s.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) load() error {
+	data, err := io.ReadAll(s.f)
+	if err != nil {
+		return err
+	}
+
+	if len(data) == 0 {
+		if _, err := s.f.Write([]byte{ENCODING_VERSION}); err != nil {
+			return err
+		}
+		s.size = 1
+		return s.f.Sync()
+	}
+
+	s.blocks, s.offsets, s.size, err = parseStore(s.f.Name(), data)
+	if err != nil {
+		return err
+	}
+	if s.size < int64(len(data)) {
+		storeLog.Warn("dropping the incomplete block", "file", s.f.Name(), "offset", s.size)
+		return s.f.Truncate(s.size)
+	}
+	return nil
+}
+
+// ReadStore reads the blocks of the store of dir without opening it for
+// writing, so that it can be read while a node uses it.
+func ReadStore(dir string) ([]*Block, error) {
+	path := filepath.Join(dir, BLOCKS_FILE)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	blocks, _, _, err := parseStore(path, data)
+	return blocks, err
+}
+
+// parseStore decodes the records of a store file. It stops at a record cut
+// short and returns the size of the complete ones.
+func parseStore(name string, data []byte) ([]*Block, []int64, int64, error) {
+	if data[0] != ENCODING_VERSION {
+		return nil, nil, 0, fmt.Errorf("%s: %w %d", name, ErrUnknownVersion, data[0])
+	}
+
+	var blocks []*Block
+	var offsets []int64
+	offset := int64(1)
+	for offset < int64(len(data)) {
+		d := decoder{data: data[offset:]}
+		size := d.count(1)
+		headerOnly := d.err == nil && size == 0
+		if headerOnly {
+			size = d.count(1)
+		}
+		b := &Block{}
+		bd := decoder{data: d.bytes(size), err: d.err}
+		if headerOnly {
+			b.decodeHeader(&bd)
+		} else {
+			b.decode(&bd)
+		}
+		if err := bd.finish(); err != nil {
+			if errors.Is(err, ErrTruncated) && d.err != nil {
+				break
+			}
+			return nil, nil, 0, fmt.Errorf("%s: block %d: %w", name, len(blocks), err)
+		}
+
+		offsets = append(offsets, offset)
+		blocks = append(blocks, b)
+		offset = int64(len(data)) - int64(len(d.data))
+	}
+	return blocks, offsets, offset, nil
+}
+
+// Blocks returns the blocks the store held when it was opened.
+func (s *Store) Blocks() []*Block {
+	return s.blocks
+}
+
+// Append writes b after the last block.
+func (s *Store) Append(b *Block) error {
+	record := appendRecord(nil, b)
+	if _, err := s.f.WriteAt(record, s.size); err != nil {
+		return err
+	}
+	s.offsets = append(s.offsets, s.size)
+	s.size += int64(len(record))
+	return s.f.Sync()
+}
+
+// Rewrite replaces the content of the store with blocks. It writes a new
+// file and renames it over the old one, so that a crash leaves one or the
+// other.
+func (s *Store) Rewrite(blocks []*Block) error {
+	buf := []byte{ENCODING_VERSION}
+	offsets := make([]int64, 0, len(blocks))
+	for _, b := range blocks {
+		offsets = append(offsets, int64(len(buf)))
+		buf = appendRecord(buf, b)
+	}
+
+	path := filepath.Join(s.dir, BLOCKS_FILE)
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		tmp.Close()
+		return err
+	}
+	s.f.Close()
+	s.f = tmp
+	s.offsets = offsets
+	s.size = int64(len(buf))
+	return nil
+}
+
+func appendRecord(buf []byte, b *Block) []byte {
+	if !b.HasBody() {
+		encoded := b.appendHeader(nil)
+		buf = binary.AppendUvarint(append(buf, 0), uint64(len(encoded)))
+		return append(buf, encoded...)
+	}
+	encoded := b.appendBinary(nil)
+	buf = binary.AppendUvarint(buf, uint64(len(encoded)))
+	return append(buf, encoded...)
+}
+
+// Truncate removes the blocks from height on.
+func (s *Store) Truncate(height int) error {
+	if height >= len(s.offsets) {
+		return nil
+	}
+	s.size = s.offsets[height]
+	s.offsets = s.offsets[:height]
+	if err := s.f.Truncate(s.size); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+func (s *Store) Close() error {
+	return s.f.Close()
+}
+
+// Check reports an error when the store file is closed or can't be read.
+func (s *Store) Check() error {
+	_, err := s.f.Stat()
+	return err
+}
+
+// CheckStore reports whether the chain has a usable store.
+func (bc *Blockchain) CheckStore() error {
+	bc.muState.RLock()
+	store := bc.store
+	bc.muState.RUnlock()
+	if store == nil {
+		return ErrNoStore
+	}
+	return store.Check()
+}
+
+// LoadStore makes s the store of the chain. Blocks already in s are
+// validated and connected, with the snapshots and the transaction pool saved
+// next to them, otherwise the current chain is written to it. From then on
+// every connected or disconnected block is persisted.
+func (bc *Blockchain) LoadStore(s *Store) error {
+	if err := bc.loadStore(s); err != nil {
+		return err
+	}
+	return bc.loadMempool(s)
+}
+
+func (bc *Blockchain) loadStore(s *Store) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	stored := s.Blocks()
+	if len(stored) == 0 {
+		for _, b := range bc.chain {
+			if err := s.Append(b); err != nil {
+				return err
+			}
+		}
+		bc.setStore(s)
+		return nil
+	}
+
+	if err := bc.loadSnapshots(s, stored); err != nil {
+		return err
+	}
+	if err := bc.VerifyChain(stored); err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	for _, b := range stored[len(bc.chain):] {
+		bc.connectBlock(b)
+	}
+	bc.setStore(s)
+	bc.takeSnapshot(len(bc.chain) - 1)
+	bc.prune(len(bc.chain) - 1)
+	return nil
+}
+
+func (bc *Blockchain) setStore(s *Store) {
+	bc.muState.Lock()
+	bc.store = s
+	bc.muState.Unlock()
+}
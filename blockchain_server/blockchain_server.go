@@ -1,25 +1,70 @@
 package main
 
 import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Nico2220/blockchain/block"
+	"github.com/Nico2220/blockchain/clock"
+	"github.com/Nico2220/blockchain/config"
+	"github.com/Nico2220/blockchain/entropy"
+	"github.com/Nico2220/blockchain/logging"
+	"github.com/Nico2220/blockchain/metrics"
+	"github.com/Nico2220/blockchain/ratelimit"
+	"github.com/Nico2220/blockchain/pool"
 	"github.com/Nico2220/blockchain/utils"
 	"github.com/Nico2220/blockchain/wallet"
+	"github.com/Nico2220/blockchain/webhook"
+	"google.golang.org/grpc"
 )
 
 type Wrapper map[string]any
 
-var cache map[string]*block.Blockchain = make(map[string]*block.Blockchain)
+var apiLog = logging.New(logging.API)
+
+const (
+	DEFAULT_PAGE_LIMIT = 10
+	MAX_PAGE_LIMIT     = 100
+)
 
 type BlockchainServer struct {
-	port int
+	port     int
+	cfg      *config.Config
+	params   *block.NetworkParams
+	webhooks *webhook.Manager
+	started  time.Time
+
+	// clock and entropy are the time and the randomness of the node, the
+	// real ones unless a test replaces them before the blockchain is
+	// created.
+	clock   clock.Clock
+	entropy io.Reader
+
+	// blockchain and pool are created on first use.
+	blockchain *block.Blockchain
+	pool       *pool.Pool
+	mu         sync.Mutex
+
+	httpServer *http.Server
+	grpcServer *grpc.Server
+	listeners  []net.Listener
+	serveErr   chan error
+	stopping   atomic.Bool
 }
 
-func NewBlockchainServer(port int) *BlockchainServer {
-	return &BlockchainServer{port: port}
+func NewBlockchainServer(cfg *config.Config, params *block.NetworkParams) *BlockchainServer {
+	return &BlockchainServer{port: config.Port(cfg.Node.Listen), cfg: cfg, params: params, started: time.Now(),
+		clock: clock.Real, entropy: entropy.Real}
 }
 
 func (bcs *BlockchainServer) Port() int {
@@ -27,28 +72,97 @@ func (bcs *BlockchainServer) Port() int {
 }
 
 func (bcs *BlockchainServer) GetBlockchain() *block.Blockchain {
-	bc, ok := cache["blockchain"]
-	if !ok {
-		minerWallet := wallet.NewWallet()
-		bc = block.NewBlockchain(minerWallet.BlockchainAddress(), bcs.Port())
-		cache["blockchain"] = bc
-		log.Printf("miner_wallet_private_key %v", minerWallet.PrivateKeyStr())
-		log.Printf("miner_wallet_public_key %v", minerWallet.PublicKeyStr())
-		log.Printf("miner_blockchain_address %v", minerWallet.BlockchainAddress())
+	bcs.mu.Lock()
+	defer bcs.mu.Unlock()
+	bc := bcs.blockchain
+	if bc == nil {
+		minerAddress := bcs.cfg.Node.Mining.Address
+		if minerAddress == "" {
+			minerWallet := bcs.newWallet()
+			minerAddress = minerWallet.BlockchainAddress()
+			apiLog.Info("miner wallet created",
+				"private_key", minerWallet.PrivateKeyStr(), "public_key", minerWallet.PublicKeyStr())
+		}
+		bc = block.NewBlockchain(minerAddress, bcs.Port(), bcs.params)
+		bc.SetClock(bcs.clock)
+		bc.SetPeers(bcs.cfg.Node.Peers)
+		bcs.blockchain = bc
+		apiLog.Info("mining", "address", minerAddress)
 	}
 
 	return bc
 }
 
+// newWallet creates a wallet from the entropy of the node. Neither the
+// system random source nor the fakes of the tests run dry.
+func (bcs *BlockchainServer) newWallet() *wallet.Wallet {
+	w, err := wallet.NewWalletFrom(bcs.entropy)
+	if err != nil {
+		panic(fmt.Sprintf("cannot create a wallet: %v", err))
+	}
+	return w
+}
+
+func (bcs *BlockchainServer) GetPool() *pool.Pool {
+	bc := bcs.GetBlockchain()
+	bcs.mu.Lock()
+	defer bcs.mu.Unlock()
+	p := bcs.pool
+	if p == nil {
+		poolWallet := bcs.newWallet()
+		shareDifficulty := bcs.cfg.Node.Mining.ShareDifficulty
+		if shareDifficulty == 0 {
+			shareDifficulty = pool.SHARE_DIFFICULTY
+		}
+		p = pool.NewPool(bc, poolWallet, shareDifficulty)
+		p.SetEntropy(bcs.entropy)
+		bcs.pool = p
+		apiLog.Info("pool wallet created", "private_key", poolWallet.PrivateKeyStr(),
+			"public_key", poolWallet.PublicKeyStr(), "address", poolWallet.BlockchainAddress())
+	}
+
+	return p
+}
+
 func HelloWorld(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, "hello world")
 }
 
+// GetChainHandler serves the chain as JSON, or in the binary encoding to
+// the peers that ask for it in the Accept header. With ?from=N only the
+// blocks from height N on are served.
 func (bcs *BlockchainServer) GetChainHandler(w http.ResponseWriter, r *http.Request) {
 	bc := bcs.GetBlockchain()
-	js, err := bc.MarsalJSON()
+	from := 0
+	if v := r.URL.Query().Get("from"); v != "" {
+		var err error
+		if from, err = strconv.Atoi(v); err != nil || from < 0 {
+			utils.WriteJSON(w, http.StatusBadRequest, Wrapper{"error": "from must be a non negative height"})
+			return
+		}
+	}
+	blocks, err := bc.ChainFrom(from)
+	if errors.Is(err, block.ErrNoBody) {
+		utils.WriteJSON(w, http.StatusGone, Wrapper{"error": err.Error(), "first_available": bc.FirstBody()})
+		return
+	}
+	if err != nil {
+		utils.WriteJSON(w, http.StatusNotFound, Wrapper{"error": err.Error()})
+		return
+	}
+
+	if r.Header.Get("Accept") == block.BINARY_CONTENT_TYPE {
+		w.Header().Set("Content-Type", block.BINARY_CONTENT_TYPE)
+		w.WriteHeader(http.StatusOK)
+		w.Write(block.EncodeChain(blocks))
+		return
+	}
+
+	js, err := json.Marshal(Wrapper{"chain": blocks})
 	if err != nil {
-		log.Fatal(err)
+		apiLog.ErrorContext(r.Context(), "cannot encode the chain", "from", from, "err", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, Wrapper{"error": "cannot encode the chain"})
+		return
 	}
 
 	js = append(js, '\n')
@@ -65,41 +179,18 @@ func (bcs *BlockchainServer) TransactionHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	if mapError := t.Validate(); len(mapError) > 0 {
-		utils.WriteJSON(w, http.StatusUnprocessableEntity, Wrapper{"error": mapError})
-		return
-	}
-
-	publicKey := utils.PublickKeyFromString(*t.SenderPublicKey)
-	signature := utils.SignatureFromString(*t.Signature)
-
-	bc := bcs.GetBlockchain()
-
-	isCreated := bc.CreateTransaction(*t.SenderBlockchainAddress, *t.RecipientBlochainAddress,
-		*t.Value, publicKey, signature)
-	fmt.Println("iscreated:", isCreated)
-
-	if !isCreated {
-		utils.WriteJSON(w, http.StatusBadRequest, Wrapper{"transaction": "transaction is not created"})
+	sent, err := bcs.Service().SendTransaction(&t)
+	if err != nil {
+		writeServiceError(w, err)
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusCreated, Wrapper{"transaction": t})
+	utils.WriteJSON(w, http.StatusCreated, Wrapper{"id": sent.ID, "transaction": sent.Transaction})
 }
 
 func (bcs *BlockchainServer) GetTransactionHandler(w http.ResponseWriter, r *http.Request) {
-	bc := bcs.GetBlockchain()
-	transactions := bc.TransactionPool()
-
-	var t struct {
-		Transactions []*block.Transaction `json:"transactions"`
-		Length       int                  `json:"length"`
-	}
-
-	t.Transactions = transactions
-	t.Length = len(transactions)
-
-	utils.WriteJSON(w, http.StatusOK, Wrapper{"transactions": t.Transactions, "length": t.Length})
+	mempool := bcs.Service().Mempool()
+	utils.WriteJSON(w, http.StatusOK, Wrapper{"transactions": mempool.Transactions, "length": mempool.Length})
 }
 
 func (bcs *BlockchainServer) UpdateTransactionHandler(w http.ResponseWriter, r *http.Request) {
@@ -115,14 +206,19 @@ func (bcs *BlockchainServer) UpdateTransactionHandler(w http.ResponseWriter, r *
 		return
 	}
 
-	publicKey := utils.PublickKeyFromString(*t.SenderPublicKey)
-	signature := utils.SignatureFromString(*t.Signature)
+	if *t.SenderBlockchainAddress == bcs.params.MiningSender {
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, Wrapper{"error": "only miners can send from the mining sender"})
+		return
+	}
+
+	// Validate checked that both decode.
+	publicKey, _ := utils.PublickKeyFromString(*t.SenderPublicKey)
+	signature, _ := utils.SignatureFromString(*t.Signature)
 
 	bc := bcs.GetBlockchain()
 
-	isUpdated := bc.AddTransaction(*t.SenderBlockchainAddress, *t.RecipientBlochainAddress,
-		*t.Value, publicKey, signature)
-	fmt.Println("iscreated:", isUpdated)
+	isUpdated := bc.AddTransactionAt(*t.SenderBlockchainAddress, *t.RecipientBlochainAddress,
+		*t.Value, *t.Timestamp, publicKey, signature)
 
 	if !isUpdated {
 		utils.WriteJSON(w, http.StatusBadRequest, Wrapper{"transaction": "transaction is not updated"})
@@ -132,43 +228,147 @@ func (bcs *BlockchainServer) UpdateTransactionHandler(w http.ResponseWriter, r *
 	utils.WriteJSON(w, http.StatusOK, Wrapper{"transaction": t})
 }
 
-func (bcs *BlockchainServer) ClearTransactionHandler(w http.ResponseWriter, r *http.Request) {
-	bc := bcs.GetBlockchain()
-	bc.ClearTransactionPool()
+func (bcs *BlockchainServer) Mine(w http.ResponseWriter, r *http.Request) {
+	if err := bcs.Service().Mine(); err != nil {
+		writeServiceError(w, err)
+		return
+	}
 
-	utils.WriteJSON(w, http.StatusOK, Wrapper{"message": "transaction pool deleted"})
+	utils.WriteJSON(w, http.StatusOK, Wrapper{"message": "mine succed"})
 }
 
-func (bcs *BlockchainServer) Mine(w http.ResponseWriter, r *http.Request) {
-	bc := bcs.GetBlockchain()
-	isMined := bc.Mining()
+func (bcs *BlockchainServer) StartMining(w http.ResponseWriter, r *http.Request) {
+	if err := bcs.Service().StartMining(); err != nil {
+		writeServiceError(w, err)
+		return
+	}
 
-	if !isMined {
-		utils.WriteJSON(w, http.StatusBadRequest, Wrapper{"error": "mine failed"})
+	utils.WriteJSON(w, http.StatusOK, Wrapper{"message": "start mining"})
+}
+
+func (bcs *BlockchainServer) GetAmount(w http.ResponseWriter, r *http.Request) {
+	balance, err := bcs.Service().Balance(r.URL.Query().Get("blockchain_address"))
+	if err != nil {
+		writeServiceError(w, err)
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusBadRequest, Wrapper{"message": "mine succed"})
+	utils.WriteJSON(w, http.StatusOK, Wrapper{
+		"amount":    balance.Amount,
+		"spendable": balance.Spendable,
+		"immature":  balance.Immature,
+	})
 }
 
-func (bcs *BlockchainServer) StartMining(w http.ResponseWriter, r *http.Request) {
+func (bcs *BlockchainServer) GetSupply(w http.ResponseWriter, r *http.Request) {
 	bc := bcs.GetBlockchain()
-	bc.StartMining()
+	utils.WriteJSON(w, http.StatusOK, Wrapper{"supply": bc.Supply()})
+}
 
-	utils.WriteJSON(w, http.StatusBadRequest, Wrapper{"message": "start mining"})
+// pagination reads the offset and limit query parameters.
+func pagination(r *http.Request) (int, int, error) {
+	offset, limit := 0, DEFAULT_PAGE_LIMIT
+	var err error
+	if v := r.URL.Query().Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non negative integer")
+		}
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > MAX_PAGE_LIMIT {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", MAX_PAGE_LIMIT)
+		}
+	}
+	return offset, limit, nil
 }
 
-func (bcs *BlockchainServer) GetAmount(w http.ResponseWriter, r *http.Request) {
+func (bcs *BlockchainServer) GetBlocksHandler(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := pagination(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, Wrapper{"error": err.Error()})
+		return
+	}
+
 	bc := bcs.GetBlockchain()
-	blockchainAddress := r.URL.Query().Get("blockchain_address")
+	// A page starting past the genesis block is empty, not pruned.
+	if first, height := bc.FirstBody(), bc.Height(); first > 0 && offset <= height && height-offset < first {
+		utils.WriteJSON(w, http.StatusGone, Wrapper{
+			"error":           fmt.Sprintf("blocks below height %d were pruned", first),
+			"first_available": first,
+		})
+		return
+	}
+	// A page reaching below the first body ends there.
+	blocks := bc.LatestBlocks(offset, limit)
+	utils.WriteJSON(w, http.StatusOK, Wrapper{"blocks": blocks, "height": bc.Height(), "offset": offset, "limit": limit})
+}
 
-	if blockchainAddress == "" {
-		utils.WriteJSON(w, http.StatusNotFound, Wrapper{"error": "missing blockchain address"})
+func (bcs *BlockchainServer) GetBlockByHeightHandler(w http.ResponseWriter, r *http.Request) {
+	height, err := strconv.Atoi(r.PathValue("height"))
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, Wrapper{"error": "height must be an integer"})
 		return
 	}
-	amount := bc.CalculateTotalAmount(blockchainAddress)
 
-	utils.WriteJSON(w, http.StatusOK, Wrapper{"amount": amount})
+	b, err := bcs.Service().BlockByHeight(height)
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, Wrapper{"block": b})
+}
+
+func (bcs *BlockchainServer) GetBlockByHashHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := bcs.Service().BlockByHash(r.PathValue("hash"))
+	if err != nil {
+		writeServiceError(w, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, Wrapper{"block": b})
+}
+
+func (bcs *BlockchainServer) GetTransactionByIDHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.HashFromString(r.PathValue("id"))
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, Wrapper{"error": err.Error()})
+		return
+	}
+
+	t, ok := bcs.GetBlockchain().TransactionByID(id)
+	if !ok {
+		utils.WriteJSON(w, http.StatusNotFound, Wrapper{"error": "transaction not found"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, Wrapper{"transaction": t})
+}
+
+func (bcs *BlockchainServer) GetAddressTransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := pagination(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, Wrapper{"error": err.Error()})
+		return
+	}
+
+	address := r.PathValue("address")
+	txs, total := bcs.GetBlockchain().AddressTransactions(address, offset, limit)
+	utils.WriteJSON(w, http.StatusOK, Wrapper{"transactions": txs, "total": total, "offset": offset, "limit": limit})
+}
+
+func (bcs *BlockchainServer) GetAddressHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	offset, limit, err := pagination(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, Wrapper{"error": err.Error()})
+		return
+	}
+
+	address := r.PathValue("address")
+	entries, total := bcs.GetBlockchain().AddressHistory(address, offset, limit)
+	utils.WriteJSON(w, http.StatusOK, Wrapper{"history": entries, "total": total, "offset": offset, "limit": limit})
 }
 
 func(bcs *BlockchainServer) ConsensusHandler(w http.ResponseWriter, r *http.Request){
@@ -178,20 +378,129 @@ func(bcs *BlockchainServer) ConsensusHandler(w http.ResponseWriter, r *http.Requ
 	utils.WriteJSON(w, http.StatusOK, Wrapper{"resolved": replaced})
 }
 
-func (bcs *BlockchainServer) Run() error {
-	bcs.GetBlockchain().Run()
-	fmt.Println("blockchain_server running on:", bcs.port)
-	router := http.NewServeMux()
+func (bcs *BlockchainServer) GetWorkHandler(w http.ResponseWriter, r *http.Request) {
+	worker := r.URL.Query().Get("worker_address")
+	if worker == "" {
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, Wrapper{"error": "missing worker address"})
+		return
+	}
+
+	job := bcs.GetPool().GetWork(worker)
+	utils.WriteJSON(w, http.StatusOK, Wrapper{"job": job})
+}
+
+func (bcs *BlockchainServer) SubmitShareHandler(w http.ResponseWriter, r *http.Request) {
+	var s pool.ShareRequest
+	err := utils.ReadJSON(r, &s)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, Wrapper{"error": err.Error()})
+		return
+	}
+
+	if mapError := s.Validate(); len(mapError) > 0 {
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, Wrapper{"error": mapError})
+		return
+	}
+
+	result, err := bcs.GetPool().SubmitShare(*s.WorkerAddress, *s.JobID, *s.Nonce)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, Wrapper{"error": err.Error()})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, Wrapper{"share": result})
+}
+
+func (bcs *BlockchainServer) PoolStatsHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, Wrapper{"pool": bcs.GetPool().Stats()})
+}
+
+// writeServiceError answers with the status code matching the kind of a
+// ServiceError: 422 for invalid fields, 400 for other bad parameters and
+// rejected requests, 404 for missing objects and 410 for pruned blocks.
+func writeServiceError(w http.ResponseWriter, err error) {
+	var se *ServiceError
+	if !errors.As(err, &se) {
+		utils.WriteJSON(w, http.StatusInternalServerError, Wrapper{"error": err.Error()})
+		return
+	}
+
+	switch {
+	case errors.Is(se, ErrInvalidParams) && se.Data != nil:
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, Wrapper{"error": se.Data})
+	case errors.Is(se, ErrNotFound):
+		utils.WriteJSON(w, http.StatusNotFound, Wrapper{"error": se.Message})
+	case errors.Is(se, ErrPruned):
+		utils.WriteJSON(w, http.StatusGone, Wrapper{"error": se.Message, "first_available": se.Data})
+	default:
+		utils.WriteJSON(w, http.StatusBadRequest, Wrapper{"error": se.Message})
+	}
+}
+
+// requireToken rejects the requests that don't carry the configured API
+// token. Without a token every request is let through.
+func (bcs *BlockchainServer) requireToken(next http.HandlerFunc) http.HandlerFunc {
+	token := bcs.cfg.Node.Auth.Token
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				utils.WriteJSON(w, http.StatusUnauthorized, Wrapper{"error": "invalid or missing api token"})
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// routes registers the HTTP API, timing and limiting every route.
+func (bcs *BlockchainServer) routes() http.Handler {
+	registry := metrics.NewRegistry()
+	registry.Include(bcs.GetBlockchain().Metrics())
+	router := metrics.NewServeMux(registry)
+	limits := ratelimit.New(bcs.cfg.Node.Limits, bcs.clock, registry)
+	handle := func(pattern string, handler http.HandlerFunc) {
+		router.HandleFunc(pattern, limits.Wrap(pattern, handler))
+	}
 	// router.HandleFunc("/", HelloWorld)
 
-	router.HandleFunc("POST /transactions", bcs.TransactionHandler)
-	router.HandleFunc("/transactions", bcs.GetTransactionHandler)
-	router.HandleFunc("PUT /transactions", bcs.UpdateTransactionHandler)
-	router.HandleFunc("DELETE /transactions", bcs.ClearTransactionHandler)
-	router.HandleFunc("/chain", bcs.GetChainHandler)
-	router.HandleFunc("/mine", bcs.Mine)
-	router.HandleFunc("/mine/start", bcs.StartMining)
-	router.HandleFunc("/amount", bcs.GetAmount)
-	router.HandleFunc("PUT /consensus", bcs.ConsensusHandler)
-	return http.ListenAndServe(fmt.Sprintf(":%d", bcs.port), router)
+	handle("POST /transactions", bcs.TransactionHandler)
+	handle("/transactions", bcs.GetTransactionHandler)
+	handle("PUT /transactions", bcs.UpdateTransactionHandler)
+	handle("/chain", bcs.GetChainHandler)
+	handle("GET /headers", bcs.GetHeadersHandler)
+	handle("GET /snapshot", bcs.GetSnapshotHandler)
+	handle("GET /limits", bcs.GetLimitsHandler)
+	handle("/mine", bcs.requireToken(bcs.Mine))
+	handle("/mine/start", bcs.requireToken(bcs.StartMining))
+	handle("/amount", bcs.GetAmount)
+	handle("GET /supply", bcs.GetSupply)
+	handle("GET /blocks", bcs.GetBlocksHandler)
+	handle("GET /blocks/{height}", bcs.GetBlockByHeightHandler)
+	handle("GET /blocks/hash/{hash}", bcs.GetBlockByHashHandler)
+	handle("GET /transactions/{id}", bcs.GetTransactionByIDHandler)
+	handle("GET /addresses/{address}/transactions", bcs.GetAddressTransactionsHandler)
+	handle("GET /addresses/{address}/history", bcs.GetAddressHistoryHandler)
+	handle("GET /ws", bcs.WebSocketHandler)
+	handle("PUT /consensus", bcs.ConsensusHandler)
+	handle("POST /rpc", bcs.RPCHandler)
+	handle("POST /webhooks", bcs.requireToken(bcs.RegisterWebhookHandler))
+	handle("GET /webhooks", bcs.requireToken(bcs.GetWebhooksHandler))
+	handle("DELETE /webhooks/{id}", bcs.requireToken(bcs.DeleteWebhookHandler))
+	handle("GET /webhooks/deliveries", bcs.requireToken(bcs.GetWebhookDeliveriesHandler))
+	if bcs.cfg.Node.Mining.Pool {
+		handle("GET /pool/work", bcs.GetWorkHandler)
+		handle("POST /pool/shares", bcs.SubmitShareHandler)
+		handle("GET /pool/stats", bcs.PoolStatsHandler)
+	}
+	handle("GET /metrics", registry.Handler())
+	handle("GET /healthz", bcs.HealthzHandler)
+	handle("GET /readyz", bcs.ReadyzHandler)
+	handle("GET /info", bcs.InfoHandler)
+
+	for _, route := range limits.Unused() {
+		apiLog.Warn("limits set for an unknown route", "route", route)
+	}
+
+	return logging.RequestIDs(logging.Recover(apiLog, router))
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+const (
+	simAlice = "1SimAlice"
+	simBob   = "1SimBob"
+)
+
+func TestPropagation(t *testing.T) {
+	sim := newSimNetwork(t, 3)
+
+	if code := sim.send(0, simAlice, 5); code != http.StatusCreated {
+		t.Fatalf("transaction answered %d", code)
+	}
+	for i, node := range sim.nodes {
+		if n := len(node.bc.TransactionPool()); n != 1 {
+			t.Fatalf("node %d has %d pending transactions, want the relayed one", i, n)
+		}
+	}
+
+	sim.mine(0)
+	sim.requireConverged(sim.all(), simAlice)
+	for i, node := range sim.nodes {
+		if got := node.bc.CalculateBalance(simAlice).Amount; got != 5 {
+			t.Errorf("node %d gives alice %v", i, got)
+		}
+		if n := len(node.bc.TransactionPool()); n != 0 {
+			t.Errorf("node %d still has %d pending transactions", i, n)
+		}
+	}
+}
+
+func TestPartitionHeal(t *testing.T) {
+	sim := newSimNetwork(t, 4)
+	sim.partition([]int{0, 1}, []int{2, 3})
+
+	sim.send(0, simAlice, 1)
+	sim.mine(0)
+	sim.send(2, simBob, 2)
+	sim.mine(2)
+	sim.send(2, simBob, 3)
+	sim.mine(3)
+
+	sim.requireConverged([]int{0, 1}, simAlice)
+	sim.requireConverged([]int{2, 3}, simBob)
+	if h := sim.nodes[0].bc.Height(); h != 1 {
+		t.Fatalf("the minority side is at height %d", h)
+	}
+
+	sim.heal()
+	sim.sync()
+	sim.requireConverged(sim.all(), simAlice, simBob)
+	bc := sim.nodes[1].bc
+	if bc.Height() != 2 || bc.CalculateBalance(simAlice).Amount != 0 || bc.CalculateBalance(simBob).Amount != 5 {
+		t.Fatalf("height %d, alice %v, bob %v: the longest side did not win",
+			bc.Height(), bc.CalculateBalance(simAlice).Amount, bc.CalculateBalance(simBob).Amount)
+	}
+}
+
+func TestDropAndDelay(t *testing.T) {
+	sim := newSimNetwork(t, 3)
+
+	sim.setLink(0, 1, simLink{Drop: 1})
+	sim.send(0, simAlice, 1)
+	if n := len(sim.nodes[1].bc.TransactionPool()); n != 0 {
+		t.Fatalf("the dropped relay reached node 1")
+	}
+	sim.mine(0)
+	if h := sim.nodes[1].bc.Height(); h != 0 {
+		t.Fatalf("node 1 heard of the block through a dropped link")
+	}
+	sim.requireConverged([]int{0, 2}, simAlice)
+	sim.sync()
+	sim.requireConverged(sim.all(), simAlice)
+
+	sim.setLink(0, 1, simLink{})
+	sim.setLink(0, 2, simLink{Delay: time.Second})
+	sent := make(chan int)
+	go func() { sent <- sim.send(0, simBob, 1) }()
+	sim.clock.BlockUntil(1)
+	if n := len(sim.nodes[2].bc.TransactionPool()); n != 0 {
+		t.Fatalf("the delayed relay arrived before its time")
+	}
+	sim.clock.Advance(time.Second)
+	if code := <-sent; code != http.StatusCreated {
+		t.Fatalf("transaction answered %d", code)
+	}
+	if n := len(sim.nodes[2].bc.TransactionPool()); n != 1 {
+		t.Fatalf("node 2 has %d pending transactions after the delay", n)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Nico2220/blockchain/block"
+	"github.com/Nico2220/blockchain/events"
+	"github.com/Nico2220/blockchain/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const GRPC_EVENT_BUFFER = 256
+
+// grpcNode serves the Node gRPC service on top of the same NodeService as
+// the REST handlers and the JSON-RPC endpoint.
+type grpcNode struct {
+	pb.UnimplementedNodeServer
+	bcs *BlockchainServer
+}
+
+func (bcs *BlockchainServer) GRPCServer() *grpc.Server {
+	s := grpc.NewServer()
+	pb.RegisterNodeServer(s, &grpcNode{bcs: bcs})
+	return s
+}
+
+func (g *grpcNode) GetBlockByHeight(ctx context.Context, req *pb.GetBlockByHeightRequest) (*pb.Block, error) {
+	b, err := g.bcs.Service().BlockByHeight(int(req.Height))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return pbBlock(b), nil
+}
+
+func (g *grpcNode) GetBlockByHash(ctx context.Context, req *pb.GetBlockByHashRequest) (*pb.Block, error) {
+	b, err := g.bcs.Service().BlockByHash(req.Hash)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return pbBlock(b), nil
+}
+
+func (g *grpcNode) GetBalance(ctx context.Context, req *pb.GetBalanceRequest) (*pb.Balance, error) {
+	balance, err := g.bcs.Service().Balance(req.BlockchainAddress)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.Balance{Amount: balance.Amount, Spendable: balance.Spendable, Immature: balance.Immature}, nil
+}
+
+func (g *grpcNode) SendTransaction(ctx context.Context, req *pb.TransactionRequest) (*pb.SendTransactionResponse, error) {
+	t := &block.TransactionRequest{
+		SenderBlockchainAddress:  &req.SenderBlockchainAddress,
+		RecipientBlochainAddress: &req.RecipientBlockchainAddress,
+		SenderPublicKey:          &req.SenderPublicKey,
+		Value:                    &req.Value,
+		Signature:                &req.Signature,
+	}
+	if req.Timestamp != 0 {
+		t.Timestamp = &req.Timestamp
+	}
+
+	sent, err := g.bcs.Service().SendTransaction(t)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &pb.SendTransactionResponse{Id: sent.ID}, nil
+}
+
+func (g *grpcNode) GetMempool(ctx context.Context, req *pb.GetMempoolRequest) (*pb.GetMempoolResponse, error) {
+	mempool := g.bcs.Service().Mempool()
+	resp := &pb.GetMempoolResponse{Transactions: make([]*pb.Transaction, 0, mempool.Length)}
+	for _, t := range mempool.Transactions {
+		resp.Transactions = append(resp.Transactions, pbTransaction(t))
+	}
+	return resp, nil
+}
+
+func (g *grpcNode) GetMiningInfo(ctx context.Context, req *pb.GetMiningInfoRequest) (*pb.MiningInfo, error) {
+	info := g.bcs.Service().MiningInfo()
+	return &pb.MiningInfo{
+		Network:             info.Network,
+		Height:              int64(info.Height),
+		Difficulty:          int32(info.Difficulty),
+		Subsidy:             info.Subsidy,
+		NextHalvingHeight:   int64(info.NextHalvingHeight),
+		Mining:              info.Mining,
+		Pool:                info.Pool,
+		MinerAddress:        info.MinerAddress,
+		PendingTransactions: int32(info.PendingTxs),
+	}, nil
+}
+
+func (g *grpcNode) SubscribeBlocks(req *pb.SubscribeBlocksRequest, stream grpc.ServerStreamingServer[pb.Block]) error {
+	return g.stream(stream.Context(), func(e *events.Event) error {
+		if e.Type != events.NEW_BLOCK {
+			return nil
+		}
+		return stream.Send(pbBlock(e.Data.(*block.BlockInfo)))
+	})
+}
+
+func (g *grpcNode) SubscribePendingTransactions(req *pb.SubscribePendingTransactionsRequest, stream grpc.ServerStreamingServer[pb.Transaction]) error {
+	return g.stream(stream.Context(), func(e *events.Event) error {
+		if e.Type != events.NEW_PENDING_TX {
+			return nil
+		}
+		if req.BlockchainAddress != "" && !containsAddress(e.Addresses, req.BlockchainAddress) {
+			return nil
+		}
+		return stream.Send(pbTransaction(e.Data.(*block.Transaction)))
+	})
+}
+
+// stream hands the events of the bus to send until the client goes away.
+func (g *grpcNode) stream(ctx context.Context, send func(e *events.Event) error) error {
+	sub := g.bcs.GetBlockchain().Events().Subscribe(GRPC_EVENT_BUFFER)
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-sub.C():
+			if !ok {
+				return status.Error(codes.Unavailable, "node is shutting down")
+			}
+			if err := send(&e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func containsAddress(addresses []string, address string) bool {
+	for _, a := range addresses {
+		if a == address {
+			return true
+		}
+	}
+	return false
+}
+
+// grpcError maps a ServiceError to its gRPC status.
+func grpcError(err error) error {
+	var se *ServiceError
+	if !errors.As(err, &se) {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	switch {
+	case errors.Is(se, ErrInvalidParams) && se.Data != nil:
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("%s: %v", se.Message, se.Data))
+	case errors.Is(se, ErrInvalidParams):
+		return status.Error(codes.InvalidArgument, se.Message)
+	case errors.Is(se, ErrNotFound), errors.Is(se, ErrPruned):
+		return status.Error(codes.NotFound, se.Message)
+	case errors.Is(se, ErrRejected):
+		return status.Error(codes.FailedPrecondition, se.Message)
+	}
+	return status.Error(codes.Internal, se.Message)
+}
+
+func pbBlock(bi *block.BlockInfo) *pb.Block {
+	b := bi.Block
+	pbb := &pb.Block{
+		Height:        int64(bi.Height),
+		Hash:          fmt.Sprintf("%x", bi.Hash),
+		PreviousHash:  fmt.Sprintf("%x", b.PreviousHash()),
+		Nonce:         int64(b.Nonce()),
+		Timestamp:     b.Timestamp(),
+		Confirmations: int64(bi.Confirmations),
+		Transactions:  make([]*pb.Transaction, 0, len(b.Transactions())),
+	}
+	for _, t := range b.Transactions() {
+		pbb.Transactions = append(pbb.Transactions, pbTransaction(t))
+	}
+	return pbb
+}
+
+func pbTransaction(t *block.Transaction) *pb.Transaction {
+	return &pb.Transaction{
+		Id:                         fmt.Sprintf("%x", t.Hash()),
+		SenderBlockchainAddress:    t.SenderBlockchainAddress(),
+		RecipientBlockchainAddress: t.RecipientBlockchainAddress(),
+		Value:                      t.Value(),
+		Timestamp:                  t.Timestamp(),
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Nico2220/blockchain/block"
+	"github.com/Nico2220/blockchain/events"
+	"github.com/Nico2220/blockchain/pb"
+	"github.com/Nico2220/blockchain/wallet"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// dialNode serves the Node service of the first node over an in-memory
+// connection and returns a client of it.
+func dialNode(t *testing.T, sim *simNetwork) pb.NodeClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := sim.nodes[0].bcs.GRPCServer()
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewNodeClient(conn)
+}
+
+func wantCode(t *testing.T, name string, err error, code codes.Code) {
+	t.Helper()
+	if status.Code(err) != code {
+		t.Errorf("%s: %v, want %s", name, err, code)
+	}
+}
+
+func TestGRPCBlocks(t *testing.T) {
+	sim := newSimNetwork(t, 1)
+	client := dialNode(t, sim)
+	ctx := context.Background()
+	genesis := fmt.Sprintf("%x", sim.nodes[0].bc.LasBlock().Hash())
+
+	b, err := client.GetBlockByHeight(ctx, &pb.GetBlockByHeightRequest{Height: 0})
+	if err != nil || b.Hash != genesis || b.Height != 0 || len(b.Transactions) != 1 {
+		t.Fatalf("block 0: %v %v", b, err)
+	}
+	b, err = client.GetBlockByHash(ctx, &pb.GetBlockByHashRequest{Hash: genesis})
+	if err != nil || b.Height != 0 || b.Transactions[0].RecipientBlockchainAddress != sim.wallet.BlockchainAddress() {
+		t.Fatalf("block %s: %v %v", genesis, b, err)
+	}
+
+	_, err = client.GetBlockByHeight(ctx, &pb.GetBlockByHeightRequest{Height: 99})
+	wantCode(t, "unknown height", err, codes.NotFound)
+	_, err = client.GetBlockByHash(ctx, &pb.GetBlockByHashRequest{Hash: "zz"})
+	wantCode(t, "malformed hash", err, codes.InvalidArgument)
+	_, err = client.GetBlockByHash(ctx, &pb.GetBlockByHashRequest{Hash: fmt.Sprintf("%064x", 1)})
+	wantCode(t, "unknown hash", err, codes.NotFound)
+}
+
+func TestGRPCTransactions(t *testing.T) {
+	sim := newSimNetwork(t, 1)
+	client := dialNode(t, sim)
+	ctx := context.Background()
+	w := sim.wallet
+
+	balance, err := client.GetBalance(ctx, &pb.GetBalanceRequest{BlockchainAddress: w.BlockchainAddress()})
+	if err != nil || balance.Amount != 100 || balance.Spendable != 100 {
+		t.Fatalf("balance %v %v", balance, err)
+	}
+	_, err = client.GetBalance(ctx, &pb.GetBalanceRequest{})
+	wantCode(t, "no address", err, codes.InvalidArgument)
+
+	tx := wallet.NewTransactionAt(w.PrivateKey(), w.PublicKey(), w.BlockchainAddress(), testBob, 1, sim.clock.Now().UnixNano())
+	req := &pb.TransactionRequest{
+		SenderBlockchainAddress:    w.BlockchainAddress(),
+		RecipientBlockchainAddress: testBob,
+		SenderPublicKey:            w.PublicKeyStr(),
+		Value:                      1,
+		Signature:                  tx.GenerateSignature().String(),
+		Timestamp:                  tx.Timestamp(),
+	}
+	sent, err := client.SendTransaction(ctx, req)
+	want := fmt.Sprintf("%x", block.NewTransactionAt(w.BlockchainAddress(), testBob, 1, tx.Timestamp()).Hash())
+	if err != nil || sent.Id != want {
+		t.Fatalf("sent %v %v, want the ID %s", sent, err, want)
+	}
+
+	_, err = client.SendTransaction(ctx, req)
+	wantCode(t, "sent twice", err, codes.FailedPrecondition)
+	forged := proto.Clone(req).(*pb.TransactionRequest)
+	forged.Value = 2
+	_, err = client.SendTransaction(ctx, forged)
+	wantCode(t, "forged", err, codes.FailedPrecondition)
+	coinbase := proto.Clone(req).(*pb.TransactionRequest)
+	coinbase.SenderBlockchainAddress = sim.nodes[0].bcs.params.MiningSender
+	_, err = client.SendTransaction(ctx, coinbase)
+	wantCode(t, "mining sender", err, codes.InvalidArgument)
+	_, err = client.SendTransaction(ctx, &pb.TransactionRequest{})
+	wantCode(t, "empty", err, codes.InvalidArgument)
+
+	mempool, err := client.GetMempool(ctx, &pb.GetMempoolRequest{})
+	if err != nil || len(mempool.Transactions) != 1 || mempool.Transactions[0].Id != want {
+		t.Fatalf("mempool %v %v", mempool, err)
+	}
+	info, err := client.GetMiningInfo(ctx, &pb.GetMiningInfoRequest{})
+	if err != nil || info.Network != "regtest" || info.Height != 0 || info.PendingTransactions != 1 {
+		t.Fatalf("mining info %v %v", info, err)
+	}
+}
+
+// publishUntil publishes the events on the bus of the first node until the
+// test ends, so that a stream gets them whenever its subscription starts.
+func publishUntil(t *testing.T, sim *simNetwork, es ...events.Event) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	t.Cleanup(func() {
+		close(done)
+		<-stopped
+	})
+	go func() {
+		defer close(stopped)
+		for {
+			for _, e := range es {
+				sim.nodes[0].bc.Events().Publish(e)
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+}
+
+func TestGRPCSubscriptions(t *testing.T) {
+	sim := newSimNetwork(t, 1)
+	client := dialNode(t, sim)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	info, err := sim.nodes[0].bcs.Service().BlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := block.NewTransactionAt(testBob, "1Other", 1, 1)
+	mine := block.NewTransactionAt(testBob, sim.wallet.BlockchainAddress(), 1, 2)
+	publishUntil(t, sim,
+		events.Event{Type: events.NEW_PENDING_TX, Addresses: other.Addresses(), Data: other},
+		events.Event{Type: events.NEW_BLOCK, Height: 0, Addresses: info.Block.Addresses(), Data: info},
+		events.Event{Type: events.NEW_PENDING_TX, Addresses: mine.Addresses(), Data: mine},
+	)
+
+	blocks, err := client.SubscribeBlocks(ctx, &pb.SubscribeBlocksRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := blocks.Recv()
+	if err != nil || b.Hash != fmt.Sprintf("%x", info.Hash) {
+		t.Fatalf("streamed block %v %v", b, err)
+	}
+
+	pending, err := client.SubscribePendingTransactions(ctx, &pb.SubscribePendingTransactionsRequest{BlockchainAddress: sim.wallet.BlockchainAddress()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		tx, err := pending.Recv()
+		if err != nil || tx.Id != fmt.Sprintf("%x", mine.Hash()) {
+			t.Fatalf("streamed transaction %v %v, want only %x", tx, err, mine.Hash())
+		}
+	}
+}
+
+func TestGRPCErrorCodes(t *testing.T) {
+	tests := []struct {
+		err  error
+		code codes.Code
+	}{
+		{serviceError(ErrInvalidParams, nil, "bad"), codes.InvalidArgument},
+		{serviceError(ErrInvalidParams, map[string]string{"value": "missing value"}, "invalid"), codes.InvalidArgument},
+		{serviceError(ErrNotFound, nil, "missing"), codes.NotFound},
+		{serviceError(ErrPruned, nil, "gone"), codes.NotFound},
+		{serviceError(ErrRejected, nil, "no"), codes.FailedPrecondition},
+		{serviceError(errors.New("other"), nil, "other"), codes.Internal},
+		{errors.New("plain"), codes.Internal},
+	}
+	for _, test := range tests {
+		if got := status.Code(grpcError(test.err)); got != test.code {
+			t.Errorf("%v: %s, want %s", test.err, got, test.code)
+		}
+	}
+	err := grpcError(serviceError(ErrInvalidParams, map[string]string{"value": "missing value"}, "invalid"))
+	if msg := status.Convert(err).Message(); msg != "invalid: map[value:missing value]" {
+		t.Errorf("message %q lost the invalid fields", msg)
+	}
+}
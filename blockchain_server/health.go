@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Nico2220/blockchain/utils"
+	"github.com/Nico2220/blockchain/version"
+)
+
+// Readiness is the outcome of the readiness checks. Checks maps each check
+// to "ok" or to the reason it failed.
+type Readiness struct {
+	Ready   bool              `json:"ready"`
+	Checks  map[string]string `json:"checks"`
+	Height  int               `json:"height"`
+	SyncLag int               `json:"sync_lag"`
+}
+
+// Readiness checks that the node isn't shutting down, that the store is
+// open, that the node has a peer that answered the last neighbor sync and
+// that it is at most node.max_sync_lag blocks behind the best chain it has
+// seen.
+func (s *NodeService) Readiness() *Readiness {
+	bc := s.bcs.GetBlockchain()
+	r := &Readiness{Ready: true, Checks: make(map[string]string), Height: bc.Height(), SyncLag: bc.SyncLag()}
+	check := func(name string, err error) {
+		if err != nil {
+			r.Ready = false
+			r.Checks[name] = err.Error()
+			return
+		}
+		r.Checks[name] = "ok"
+	}
+
+	if s.bcs.stopping.Load() {
+		check("shutdown", errors.New("the node is shutting down"))
+	}
+	check("store", bc.CheckStore())
+	switch {
+	case len(bc.Neighbors()) == 0:
+		check("peers", errors.New("no peers"))
+	case !bc.NeighborsAnswered():
+		check("peers", errors.New("no peer answered the last sync"))
+	default:
+		check("peers", nil)
+	}
+	if maxLag := s.bcs.cfg.Node.MaxSyncLag; r.SyncLag > maxLag {
+		check("sync", fmt.Errorf("%d blocks behind the best peer, at most %d allowed", r.SyncLag, maxLag))
+	} else {
+		check("sync", nil)
+	}
+	return r
+}
+
+type NodeInfo struct {
+	Network      string `json:"network"`
+	Version      string `json:"version"`
+	GenesisHash  string `json:"genesis_hash"`
+	Height       int    `json:"height"`
+	TipHash      string `json:"tip_hash"`
+	MinerAddress string `json:"miner_address"`
+	Peers        int    `json:"peers"`
+	Mempool      int    `json:"mempool"`
+	Uptime       string `json:"uptime"`
+	UptimeSec    int64  `json:"uptime_seconds"`
+}
+
+func (s *NodeService) Info() *NodeInfo {
+	bc := s.bcs.GetBlockchain()
+	uptime := time.Since(s.bcs.started).Truncate(time.Second)
+	tip := bc.LasBlock()
+	return &NodeInfo{
+		Network:      s.bcs.params.Name,
+		Version:      version.String(),
+		GenesisHash:  fmt.Sprintf("%x", s.bcs.params.GenesisBlock().Hash()),
+		Height:       bc.Height(),
+		TipHash:      fmt.Sprintf("%x", tip.Hash()),
+		MinerAddress: bc.BlockchainAddress(),
+		Peers:        len(bc.Neighbors()),
+		Mempool:      len(bc.TransactionPool()),
+		Uptime:       uptime.String(),
+		UptimeSec:    int64(uptime.Seconds()),
+	}
+}
+
+// HealthzHandler tells the orchestrator the process is alive.
+func (bcs *BlockchainServer) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, Wrapper{"status": "ok"})
+}
+
+// ReadyzHandler answers 503 until the node can serve up to date data.
+func (bcs *BlockchainServer) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	readiness := bcs.Service().Readiness()
+	status := http.StatusOK
+	if !readiness.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	utils.WriteJSON(w, status, readiness)
+}
+
+func (bcs *BlockchainServer) InfoHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, bcs.Service().Info())
+}
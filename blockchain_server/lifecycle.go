@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Nico2220/blockchain/block"
+	"github.com/Nico2220/blockchain/utils"
+	"github.com/Nico2220/blockchain/webhook"
+)
+
+// SHUTDOWN_TIMEOUT bounds how long Run waits for the requests in flight
+// once it is asked to stop.
+const SHUTDOWN_TIMEOUT = 15 * time.Second
+
+// Run starts the node and serves until ctx is done or a server fails, then
+// stops it.
+func (bcs *BlockchainServer) Run(ctx context.Context) error {
+	if err := bcs.Start(ctx); err != nil {
+		return err
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		apiLog.Info("shutting down")
+	case err = <-bcs.serveErr:
+	}
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	return errors.Join(err, bcs.Stop(stopCtx))
+}
+
+// Start opens the store, syncs with the neighbors and starts serving the
+// HTTP and gRPC APIs in the background. The listen addresses are bound first
+// so that a busy port fails before anything else is done. The background
+// loops of the node run until ctx is done or Stop is called.
+func (bcs *BlockchainServer) Start(ctx context.Context) (err error) {
+	if err := bcs.listen(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			bcs.closeListeners()
+		}
+	}()
+
+	bc := bcs.GetBlockchain()
+	bc.SetPruning(bcs.cfg.Node.Prune)
+	store, err := block.OpenStore(bcs.cfg.DataDir)
+	if err != nil {
+		return err
+	}
+	if err := bc.LoadStore(store); err != nil {
+		store.Close()
+		return err
+	}
+	if bcs.cfg.Node.Sync.FastSync {
+		trusted, _ := utils.HashFromString(bcs.cfg.Node.Sync.TrustedSnapshot)
+		if err := bc.FastSync(trusted); err != nil {
+			apiLog.Warn("syncing every block instead", "err", err)
+		}
+	}
+
+	webhooks, err := webhook.NewManager(bcs.cfg.DataDir, bc.Events())
+	if err != nil {
+		bc.Close()
+		return err
+	}
+	bcs.webhooks = webhooks
+	bcs.webhooks.SetClock(bc.Clock())
+	bcs.webhooks.Start()
+
+	bc.Start(ctx)
+	if bcs.cfg.Node.Mining.Enabled {
+		bc.StartMining()
+	}
+
+	bcs.serveErr = make(chan error, len(bcs.listeners))
+	bcs.httpServer = &http.Server{Handler: bcs.routes()}
+	go bcs.serve(func() error { return bcs.httpServer.Serve(bcs.listeners[0]) })
+	apiLog.Info("blockchain_server running", "listen", bcs.listeners[0].Addr().String(), "network", bcs.params.Name)
+	if len(bcs.listeners) > 1 {
+		bcs.grpcServer = bcs.GRPCServer()
+		go bcs.serve(func() error { return bcs.grpcServer.Serve(bcs.listeners[1]) })
+		apiLog.Info("blockchain_server gRPC running", "listen", bcs.listeners[1].Addr().String())
+	}
+	return nil
+}
+
+func (bcs *BlockchainServer) listen() error {
+	addresses := []string{bcs.cfg.Node.Listen}
+	if bcs.cfg.Node.GRPCListen != "" {
+		addresses = append(addresses, bcs.cfg.Node.GRPCListen)
+	}
+	for _, address := range addresses {
+		lis, err := net.Listen("tcp", address)
+		if err != nil {
+			bcs.closeListeners()
+			return err
+		}
+		bcs.listeners = append(bcs.listeners, lis)
+	}
+	return nil
+}
+
+func (bcs *BlockchainServer) closeListeners() {
+	for _, lis := range bcs.listeners {
+		lis.Close()
+	}
+	bcs.listeners = nil
+}
+
+// serve reports the errors of a server other than being stopped.
+func (bcs *BlockchainServer) serve(serve func() error) {
+	if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		apiLog.Error("server stopped", "err", err)
+		bcs.serveErr <- err
+	}
+}
+
+// Addr returns the address the HTTP API listens on, useful when it was
+// started on port 0.
+func (bcs *BlockchainServer) Addr() string {
+	return bcs.listeners[0].Addr().String()
+}
+
+// Stop shuts the node down: the miner and neighbor sync loops stop, the
+// HTTP requests in flight are drained, the webhook deliveries and event
+// streams end, then the transaction pool and the block store are flushed.
+// When ctx expires first the remaining connections are closed.
+func (bcs *BlockchainServer) Stop(ctx context.Context) error {
+	bcs.stopping.Store(true)
+	bc := bcs.GetBlockchain()
+	bc.Stop()
+
+	var errs []error
+	if err := bcs.httpServer.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+		bcs.httpServer.Close()
+	}
+	bcs.webhooks.Stop()
+
+	// Closing the bus ends the websocket and gRPC event streams, which the
+	// graceful stop of the gRPC server waits for.
+	bc.Events().Close()
+	if bcs.grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			bcs.grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			bcs.grpcServer.Stop()
+			<-stopped
+		}
+	}
+
+	if err := bc.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	apiLog.Info("blockchain_server stopped")
+	return errors.Join(errs...)
+}
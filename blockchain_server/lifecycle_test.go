@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Nico2220/blockchain/block"
+	"github.com/Nico2220/blockchain/config"
+	"github.com/Nico2220/blockchain/entropy"
+	"github.com/Nico2220/blockchain/wallet"
+)
+
+const testBob = "1nV5Np6exVRaZRoWHnJavQsVbDQwYZVPp"
+
+// sendFrom signs a transfer with the wallet and adds it to the pool.
+func sendFrom(bc *block.Blockchain, w *wallet.Wallet, recipient string, value float32) bool {
+	t := wallet.NewTransactionAt(w.PrivateKey(), w.PublicKey(), w.BlockchainAddress(), recipient, value, bc.Clock().Now().UnixNano())
+	return bc.AddTransactionAt(w.BlockchainAddress(), recipient, value, t.Timestamp(), w.PublicKey(), t.GenerateSignature())
+}
+
+// startNode starts a regtest node on free ports keeping its data in dir. Its
+// genesis block gives 100 to alice.
+func startNode(t *testing.T, dir string, alice *wallet.Wallet) *BlockchainServer {
+	t.Helper()
+	genesis := filepath.Join(t.TempDir(), "genesis.json")
+	data, _ := json.Marshal(&block.Genesis{
+		Network:     "regtest",
+		Timestamp:   1735689600000000002,
+		Allocations: []*block.Allocation{{Address: alice.BlockchainAddress(), Amount: 100}},
+	})
+	if err := os.WriteFile(genesis, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(config.Node, []string{
+		"-network", "regtest", "-genesis", genesis, "-data-dir", dir,
+		"-listen", "127.0.0.1:0", "-grpc-listen", "127.0.0.1:0", "-peers", "127.0.0.1:1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := cfg.Params()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcs := NewBlockchainServer(cfg, params)
+	if err := bcs.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return bcs
+}
+
+func TestStartStop(t *testing.T) {
+	dir := t.TempDir()
+	alice, _ := wallet.NewWalletFrom(entropy.NewFake(1))
+	bcs := startNode(t, dir, alice)
+	addr := bcs.Addr()
+
+	resp, err := http.Get("http://" + addr + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("healthz answered %d", resp.StatusCode)
+	}
+
+	bc := bcs.GetBlockchain()
+	if !sendFrom(bc, alice, testBob, 1) || !bc.Mining() {
+		t.Fatal("block not mined")
+	}
+	if !sendFrom(bc, alice, testBob, 2) {
+		t.Fatal("transaction rejected")
+	}
+	height := bc.Height()
+
+	if err := bcs.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Fatal("still listening after Stop")
+	}
+
+	bcs = startNode(t, dir, alice)
+	defer bcs.Stop(context.Background())
+	bc = bcs.GetBlockchain()
+	if bc.Height() != height {
+		t.Errorf("height %d after restart, want %d", bc.Height(), height)
+	}
+	if pool := bc.TransactionPool(); len(pool) != 1 || pool[0].Value() != 2 {
+		t.Errorf("mempool not restored: %v", pool)
+	}
+}
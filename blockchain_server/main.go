@@ -1,23 +1,48 @@
 package main
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
+
+	"github.com/Nico2220/blockchain/config"
+	"github.com/Nico2220/blockchain/logging"
 )
 
-func init() {
+func main() {
+	cfg, err := config.Load(config.Node, os.Args[1:])
+	if config.IsHelp(err) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-}
+	logging.Setup(cfg.Logging())
 
-func main() {
-	port, _ := strconv.Atoi(os.Getenv("port"))
+	params, err := cfg.Params()
+	if err != nil {
+		slog.Error("cannot load the network params", "err", err)
+		os.Exit(1)
+	}
+
+	if err := os.MkdirAll(cfg.DataDir, 0o755); err != nil {
+		slog.Error("cannot create the data dir", "err", err)
+		os.Exit(1)
+	}
 
-	app := NewBlockchainServer(port)
+	app := NewBlockchainServer(cfg, params)
 
-	err := app.Run()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = app.Run(ctx)
 	if err != nil {
-		log.Fatal("error starting server", err)
+		slog.Error("server stopped", "err", err)
+		os.Exit(1)
 	}
 
 }
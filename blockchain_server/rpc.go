@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Nico2220/blockchain/block"
+	"github.com/Nico2220/blockchain/utils"
+)
+
+const (
+	RPC_VERSION   = "2.0"
+	RPC_MAX_BATCH = 100
+
+	// Standard JSON-RPC 2.0 error codes.
+	RPC_PARSE_ERROR      = -32700
+	RPC_INVALID_REQUEST  = -32600
+	RPC_METHOD_NOT_FOUND = -32601
+	RPC_INVALID_PARAMS   = -32602
+	RPC_INTERNAL_ERROR   = -32603
+
+	// Server errors, in the range the specification reserves for them.
+	RPC_NOT_FOUND = -32001
+	RPC_REJECTED  = -32002
+	RPC_PRUNED    = -32003
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+// rpcResponse has either a result or an error, never both. The result is
+// kept raw so that a null result is still written.
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+var rpcNull = json.RawMessage("null")
+
+// rpcMethod describes a method: the names of its parameters, in the order
+// they are expected when passed by position, and the call itself, which
+// decodes its parameters with decode.
+type rpcMethod struct {
+	params []string
+	call   func(s *NodeService, decode func(any) error) (any, error)
+}
+
+var rpcMethods = map[string]rpcMethod{
+	"getBlockByHash": {
+		params: []string{"hash"},
+		call: func(s *NodeService, decode func(any) error) (any, error) {
+			var p struct {
+				Hash *string `json:"hash"`
+			}
+			if err := decode(&p); err != nil {
+				return nil, err
+			}
+			if p.Hash == nil {
+				return nil, serviceError(ErrInvalidParams, nil, "missing hash")
+			}
+			return s.BlockByHash(*p.Hash)
+		},
+	},
+	"getBlockByHeight": {
+		params: []string{"height"},
+		call: func(s *NodeService, decode func(any) error) (any, error) {
+			var p struct {
+				Height *int `json:"height"`
+			}
+			if err := decode(&p); err != nil {
+				return nil, err
+			}
+			if p.Height == nil {
+				return nil, serviceError(ErrInvalidParams, nil, "missing height")
+			}
+			return s.BlockByHeight(*p.Height)
+		},
+	},
+	"getBalance": {
+		params: []string{"address"},
+		call: func(s *NodeService, decode func(any) error) (any, error) {
+			var p struct {
+				Address string `json:"address"`
+			}
+			if err := decode(&p); err != nil {
+				return nil, err
+			}
+			return s.Balance(p.Address)
+		},
+	},
+	"sendRawTransaction": {
+		params: []string{"transaction"},
+		call: func(s *NodeService, decode func(any) error) (any, error) {
+			var p struct {
+				Transaction *block.TransactionRequest `json:"transaction"`
+			}
+			if err := decode(&p); err != nil {
+				return nil, err
+			}
+			if p.Transaction == nil {
+				return nil, serviceError(ErrInvalidParams, nil, "missing transaction")
+			}
+			return s.SendTransaction(p.Transaction)
+		},
+	},
+	"getMempool": {
+		call: func(s *NodeService, decode func(any) error) (any, error) {
+			if err := decode(&struct{}{}); err != nil {
+				return nil, err
+			}
+			return s.Mempool(), nil
+		},
+	},
+	"getPeerInfo": {
+		call: func(s *NodeService, decode func(any) error) (any, error) {
+			if err := decode(&struct{}{}); err != nil {
+				return nil, err
+			}
+			return s.PeerInfo(), nil
+		},
+	},
+	"getMiningInfo": {
+		call: func(s *NodeService, decode func(any) error) (any, error) {
+			if err := decode(&struct{}{}); err != nil {
+				return nil, err
+			}
+			return s.MiningInfo(), nil
+		},
+	},
+}
+
+// rpcParams decodes params, passed by name or by position, into dst. Unknown
+// names and extra positional params are errors.
+func rpcParams(params json.RawMessage, names []string, dst any) error {
+	params = bytes.TrimSpace(params)
+	if len(params) == 0 || bytes.Equal(params, rpcNull) {
+		return nil
+	}
+
+	if params[0] == '[' {
+		var list []json.RawMessage
+		if err := json.Unmarshal(params, &list); err != nil {
+			return serviceError(ErrInvalidParams, nil, "%v", err)
+		}
+		if len(list) > len(names) {
+			return serviceError(ErrInvalidParams, nil, "expected at most %d params, got %d", len(names), len(list))
+		}
+		byName := make(map[string]json.RawMessage, len(list))
+		for i, v := range list {
+			byName[names[i]] = v
+		}
+		params, _ = json.Marshal(byName)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(params))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(dst); err != nil {
+		return serviceError(ErrInvalidParams, nil, "%v", err)
+	}
+	return nil
+}
+
+// RPCHandler serves JSON-RPC 2.0 requests, one at a time or in batches.
+// Notifications, requests without an id, are run but get no response.
+func (bcs *BlockchainServer) RPCHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusOK, rpcFailure(rpcNull, RPC_PARSE_ERROR, err.Error(), nil))
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || !json.Valid(body) {
+		utils.WriteJSON(w, http.StatusOK, rpcFailure(rpcNull, RPC_PARSE_ERROR, "parse error", nil))
+		return
+	}
+
+	s := bcs.Service()
+	if body[0] != '[' {
+		if resp := bcs.rpcCall(s, body); resp != nil {
+			utils.WriteJSON(w, http.StatusOK, resp)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	var batch []json.RawMessage
+	json.Unmarshal(body, &batch)
+	if len(batch) == 0 {
+		utils.WriteJSON(w, http.StatusOK, rpcFailure(rpcNull, RPC_INVALID_REQUEST, "empty batch", nil))
+		return
+	}
+	if len(batch) > RPC_MAX_BATCH {
+		utils.WriteJSON(w, http.StatusOK, rpcFailure(rpcNull, RPC_INVALID_REQUEST,
+			fmt.Sprintf("batch of more than %d requests", RPC_MAX_BATCH), nil))
+		return
+	}
+
+	responses := make([]*rpcResponse, 0, len(batch))
+	for _, raw := range batch {
+		if resp := bcs.rpcCall(s, raw); resp != nil {
+			responses = append(responses, resp)
+		}
+	}
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, responses)
+}
+
+// rpcCall runs one request and returns its response, nil for a notification.
+func (bcs *BlockchainServer) rpcCall(s *NodeService, raw json.RawMessage) *rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return rpcFailure(rpcNull, RPC_INVALID_REQUEST, "request must be an object", nil)
+	}
+
+	id := req.ID
+	if id != nil && !validRPCID(id) {
+		return rpcFailure(rpcNull, RPC_INVALID_REQUEST, "id must be a string, a number or null", nil)
+	}
+	if id == nil {
+		id = rpcNull
+	}
+	if req.JSONRPC != RPC_VERSION {
+		return rpcFailure(id, RPC_INVALID_REQUEST, `jsonrpc must be "2.0"`, nil)
+	}
+	if req.Method == "" {
+		return rpcFailure(id, RPC_INVALID_REQUEST, "missing method", nil)
+	}
+
+	resp := rpcInvoke(s, &req, id)
+	if req.ID == nil {
+		return nil
+	}
+	return resp
+}
+
+func rpcInvoke(s *NodeService, req *rpcRequest, id json.RawMessage) *rpcResponse {
+	method, ok := rpcMethods[req.Method]
+	if !ok {
+		return rpcFailure(id, RPC_METHOD_NOT_FOUND, fmt.Sprintf("method %q not found", req.Method), nil)
+	}
+
+	result, err := method.call(s, func(dst any) error {
+		return rpcParams(req.Params, method.params, dst)
+	})
+	if err != nil {
+		return rpcErrorResponse(id, err)
+	}
+
+	js, err := json.Marshal(result)
+	if err != nil {
+		return rpcFailure(id, RPC_INTERNAL_ERROR, err.Error(), nil)
+	}
+	return &rpcResponse{JSONRPC: RPC_VERSION, Result: js, ID: id}
+}
+
+func validRPCID(id json.RawMessage) bool {
+	var v any
+	if err := json.Unmarshal(id, &v); err != nil {
+		return false
+	}
+	switch v.(type) {
+	case nil, string, float64:
+		return true
+	}
+	return false
+}
+
+// rpcErrorResponse maps a ServiceError to its JSON-RPC error code.
+func rpcErrorResponse(id json.RawMessage, err error) *rpcResponse {
+	var se *ServiceError
+	if !errors.As(err, &se) {
+		return rpcFailure(id, RPC_INTERNAL_ERROR, err.Error(), nil)
+	}
+
+	switch {
+	case errors.Is(se, ErrInvalidParams):
+		return rpcFailure(id, RPC_INVALID_PARAMS, se.Message, se.Data)
+	case errors.Is(se, ErrNotFound):
+		return rpcFailure(id, RPC_NOT_FOUND, se.Message, se.Data)
+	case errors.Is(se, ErrRejected):
+		return rpcFailure(id, RPC_REJECTED, se.Message, se.Data)
+	case errors.Is(se, ErrPruned):
+		return rpcFailure(id, RPC_PRUNED, se.Message, se.Data)
+	}
+	return rpcFailure(id, RPC_INTERNAL_ERROR, se.Message, se.Data)
+}
+
+func rpcFailure(id json.RawMessage, code int, message string, data any) *rpcResponse {
+	return &rpcResponse{
+		JSONRPC: RPC_VERSION,
+		Error:   &rpcError{Code: code, Message: message, Data: data},
+		ID:      id,
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postRPC posts body to the JSON-RPC endpoint of the first node.
+func postRPC(sim *simNetwork, body string) *httptest.ResponseRecorder {
+	// Refill the rate limit buckets.
+	sim.clock.Advance(time.Minute)
+	req := httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	sim.nodes[0].handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func decodeRPC(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("answered %d: %s", rec.Code, rec.Body)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("%v: %s", err, rec.Body)
+	}
+}
+
+func TestRPCErrors(t *testing.T) {
+	sim := newSimNetwork(t, 1)
+	tests := map[string]struct {
+		body string
+		code int
+		id   string
+	}{
+		"parse error":        {`{"jsonrpc":"2.0",`, RPC_PARSE_ERROR, "null"},
+		"empty body":         {``, RPC_PARSE_ERROR, "null"},
+		"not an object":      {`1`, RPC_INVALID_REQUEST, "null"},
+		"wrong version":      {`{"jsonrpc":"1.0","method":"getMempool","id":1}`, RPC_INVALID_REQUEST, "1"},
+		"missing method":     {`{"jsonrpc":"2.0","id":"a"}`, RPC_INVALID_REQUEST, `"a"`},
+		"object id":          {`{"jsonrpc":"2.0","method":"getMempool","id":{}}`, RPC_INVALID_REQUEST, "null"},
+		"empty batch":        {`[]`, RPC_INVALID_REQUEST, "null"},
+		"unknown method":     {`{"jsonrpc":"2.0","method":"getEverything","id":2}`, RPC_METHOD_NOT_FOUND, "2"},
+		"too many params":    {`{"jsonrpc":"2.0","method":"getBlockByHeight","params":[0,1],"id":3}`, RPC_INVALID_PARAMS, "3"},
+		"unknown param":      {`{"jsonrpc":"2.0","method":"getBlockByHeight","params":{"hash":"00"},"id":4}`, RPC_INVALID_PARAMS, "4"},
+		"missing param":      {`{"jsonrpc":"2.0","method":"getBlockByHeight","id":5}`, RPC_INVALID_PARAMS, "5"},
+		"malformed hash":     {`{"jsonrpc":"2.0","method":"getBlockByHash","params":["zz"],"id":6}`, RPC_INVALID_PARAMS, "6"},
+		"unknown block":      {`{"jsonrpc":"2.0","method":"getBlockByHeight","params":[99],"id":7}`, RPC_NOT_FOUND, "7"},
+		"invalid params":     {`{"jsonrpc":"2.0","method":"sendRawTransaction","params":{"transaction":{}},"id":8}`, RPC_INVALID_PARAMS, "8"},
+		"params on no param": {`{"jsonrpc":"2.0","method":"getMempool","params":[1],"id":9}`, RPC_INVALID_PARAMS, "9"},
+	}
+	for name, test := range tests {
+		var resp rpcResponse
+		decodeRPC(t, postRPC(sim, test.body), &resp)
+		if resp.JSONRPC != RPC_VERSION || resp.Error == nil || resp.Result != nil {
+			t.Errorf("%s: response %+v", name, resp)
+			continue
+		}
+		if resp.Error.Code != test.code || string(resp.ID) != test.id {
+			t.Errorf("%s: error %d with id %s, want %d with id %s", name, resp.Error.Code, resp.ID, test.code, test.id)
+		}
+	}
+
+	var resp rpcResponse
+	decodeRPC(t, postRPC(sim, `{"jsonrpc":"2.0","method":"sendRawTransaction","params":[{}],"id":1}`), &resp)
+	if data, ok := resp.Error.Data.(map[string]any); !ok || data["signature"] == nil {
+		t.Errorf("invalid transaction without the invalid fields: %+v", resp.Error)
+	}
+}
+
+func TestRPCParams(t *testing.T) {
+	sim := newSimNetwork(t, 1)
+	for _, body := range []string{
+		`{"jsonrpc":"2.0","method":"getBlockByHeight","params":[0],"id":1}`,
+		`{"jsonrpc":"2.0","method":"getBlockByHeight","params":{"height":0},"id":1}`,
+	} {
+		var resp struct {
+			Result struct {
+				Height int    `json:"height"`
+				Hash   string `json:"hash"`
+			} `json:"result"`
+			Error *rpcError `json:"error"`
+		}
+		decodeRPC(t, postRPC(sim, body), &resp)
+		if resp.Error != nil || resp.Result.Hash != fmt.Sprintf("%x", sim.nodes[0].bc.LasBlock().Hash()) {
+			t.Errorf("%s: %+v", body, resp)
+		}
+	}
+}
+
+func TestRPCNotifications(t *testing.T) {
+	sim := newSimNetwork(t, 1)
+
+	// Without an id the request is a notification: it runs, even when it
+	// fails, and gets no response.
+	for _, body := range []string{
+		`{"jsonrpc":"2.0","method":"getMempool"}`,
+		`{"jsonrpc":"2.0","method":"getEverything"}`,
+		`[{"jsonrpc":"2.0","method":"getMempool"},{"jsonrpc":"2.0","method":"getPeerInfo"}]`,
+	} {
+		if rec := postRPC(sim, body); rec.Code != http.StatusNoContent || rec.Body.Len() != 0 {
+			t.Errorf("%s: answered %d: %s", body, rec.Code, rec.Body)
+		}
+	}
+
+	// A null id is still a request.
+	var resp rpcResponse
+	decodeRPC(t, postRPC(sim, `{"jsonrpc":"2.0","method":"getMempool","id":null}`), &resp)
+	if resp.Error != nil || resp.Result == nil || string(resp.ID) != "null" {
+		t.Errorf("request with a null id: %+v", resp)
+	}
+}
+
+func TestRPCBatch(t *testing.T) {
+	sim := newSimNetwork(t, 1)
+	body := `[
+		{"jsonrpc":"2.0","method":"getMiningInfo","id":"mining"},
+		{"jsonrpc":"2.0","method":"getMempool"},
+		{"jsonrpc":"2.0","method":"getEverything","id":2},
+		1,
+		{"jsonrpc":"2.0","method":"getBalance","params":{"address":"` + sim.wallet.BlockchainAddress() + `"},"id":3}
+	]`
+	var responses []rpcResponse
+	decodeRPC(t, postRPC(sim, body), &responses)
+
+	want := []struct {
+		id   string
+		code int
+	}{{`"mining"`, 0}, {"2", RPC_METHOD_NOT_FOUND}, {"null", RPC_INVALID_REQUEST}, {"3", 0}}
+	if len(responses) != len(want) {
+		t.Fatalf("%d responses, want %d: %+v", len(responses), len(want), responses)
+	}
+	for i, w := range want {
+		resp := responses[i]
+		code := 0
+		if resp.Error != nil {
+			code = resp.Error.Code
+		}
+		if string(resp.ID) != w.id || code != w.code || (code == 0) == (resp.Result == nil) {
+			t.Errorf("response %d: id %s, error %d, result %s; want id %s, error %d", i, resp.ID, code, resp.Result, w.id, w.code)
+		}
+	}
+
+	var balance struct {
+		Amount float32 `json:"amount"`
+	}
+	json.Unmarshal(responses[3].Result, &balance)
+	if balance.Amount != 100 {
+		t.Errorf("balance %v in a batch, want 100", balance.Amount)
+	}
+
+	calls := make([]string, RPC_MAX_BATCH+1)
+	for i := range calls {
+		calls[i] = fmt.Sprintf(`{"jsonrpc":"2.0","method":"getMempool","id":%d}`, i)
+	}
+	var resp rpcResponse
+	decodeRPC(t, postRPC(sim, "["+strings.Join(calls, ",")+"]"), &resp)
+	if resp.Error == nil || resp.Error.Code != RPC_INVALID_REQUEST {
+		t.Errorf("batch over the limit: %+v", resp)
+	}
+}
+
+func TestRPCErrorCodes(t *testing.T) {
+	id := json.RawMessage("1")
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{serviceError(ErrInvalidParams, nil, "bad"), RPC_INVALID_PARAMS},
+		{serviceError(ErrNotFound, nil, "missing"), RPC_NOT_FOUND},
+		{serviceError(ErrRejected, nil, "no"), RPC_REJECTED},
+		{serviceError(ErrPruned, nil, "gone"), RPC_PRUNED},
+		{serviceError(errors.New("other"), nil, "other"), RPC_INTERNAL_ERROR},
+		{fmt.Errorf("wrapped: %w", serviceError(ErrNotFound, nil, "missing")), RPC_NOT_FOUND},
+		{errors.New("plain"), RPC_INTERNAL_ERROR},
+	}
+	for _, test := range tests {
+		resp := rpcErrorResponse(id, test.err)
+		if resp.Error == nil || resp.Error.Code != test.code || string(resp.ID) != "1" {
+			t.Errorf("%v: %+v, want code %d", test.err, resp.Error, test.code)
+		}
+	}
+
+	data := map[string]string{"value": "missing value"}
+	if resp := rpcErrorResponse(id, serviceError(ErrInvalidParams, data, "invalid")); resp.Error.Message != "invalid" || resp.Error.Data == nil {
+		t.Errorf("message and data lost: %+v", resp.Error)
+	}
+}
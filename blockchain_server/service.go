@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Nico2220/blockchain/block"
+	"github.com/Nico2220/blockchain/utils"
+)
+
+var (
+	ErrInvalidParams = errors.New("invalid params")
+	ErrNotFound      = errors.New("not found")
+	ErrRejected      = errors.New("rejected")
+	ErrPruned        = errors.New("pruned")
+)
+
+// ServiceError is what the service returns. Kind is one of the errors above
+// and tells the REST handlers which status code to answer and the JSON-RPC
+// endpoint which error code. Data carries the details, like the fields that
+// failed validation.
+type ServiceError struct {
+	Kind    error
+	Message string
+	Data    any
+}
+
+func (e *ServiceError) Error() string {
+	return e.Message
+}
+
+func (e *ServiceError) Unwrap() error {
+	return e.Kind
+}
+
+func serviceError(kind error, data any, format string, args ...any) *ServiceError {
+	return &ServiceError{Kind: kind, Message: fmt.Sprintf(format, args...), Data: data}
+}
+
+// NodeService holds what the node can be asked, whatever the transport. The
+// REST handlers and the JSON-RPC methods both go through it.
+type NodeService struct {
+	bcs *BlockchainServer
+}
+
+func (bcs *BlockchainServer) Service() *NodeService {
+	return &NodeService{bcs: bcs}
+}
+
+func (s *NodeService) BlockByHeight(height int) (*block.BlockInfo, error) {
+	b, ok := s.bcs.GetBlockchain().BlockByHeight(height)
+	if !ok {
+		return nil, serviceError(ErrNotFound, nil, "block not found")
+	}
+	return s.withBody(b)
+}
+
+func (s *NodeService) BlockByHash(hash string) (*block.BlockInfo, error) {
+	h, err := utils.HashFromString(hash)
+	if err != nil {
+		return nil, serviceError(ErrInvalidParams, nil, "%v", err)
+	}
+
+	b, ok := s.bcs.GetBlockchain().BlockByHash(h)
+	if !ok {
+		return nil, serviceError(ErrNotFound, nil, "block not found")
+	}
+	return s.withBody(b)
+}
+
+// withBody refuses the blocks the node only knows by their header. The
+// error data is the first height the node has every body from.
+func (s *NodeService) withBody(b *block.BlockInfo) (*block.BlockInfo, error) {
+	if !b.Block.HasBody() {
+		first := s.bcs.GetBlockchain().FirstBody()
+		return nil, serviceError(ErrPruned, first, "block %d was pruned, this node only has the blocks from height %d", b.Height, first)
+	}
+	return b, nil
+}
+
+func (s *NodeService) Balance(address string) (*block.Balance, error) {
+	if address == "" {
+		return nil, serviceError(ErrInvalidParams, nil, "missing blockchain address")
+	}
+	return s.bcs.GetBlockchain().CalculateBalance(address), nil
+}
+
+type SentTransaction struct {
+	ID          string                    `json:"id"`
+	Transaction *block.TransactionRequest `json:"transaction"`
+}
+
+// SendTransaction adds a signed transaction to the pool and relays it.
+func (s *NodeService) SendTransaction(t *block.TransactionRequest) (*SentTransaction, error) {
+	if mapError := t.Validate(); len(mapError) > 0 {
+		return nil, serviceError(ErrInvalidParams, mapError, "invalid transaction")
+	}
+
+	if *t.SenderBlockchainAddress == s.bcs.params.MiningSender {
+		return nil, serviceError(ErrInvalidParams, nil, "only miners can send from the mining sender")
+	}
+
+	// Validate checked that both decode.
+	publicKey, _ := utils.PublickKeyFromString(*t.SenderPublicKey)
+	signature, _ := utils.SignatureFromString(*t.Signature)
+
+	isCreated := s.bcs.GetBlockchain().CreateTransactionAt(*t.SenderBlockchainAddress, *t.RecipientBlochainAddress,
+		*t.Value, *t.Timestamp, publicKey, signature)
+	if !isCreated {
+		return nil, serviceError(ErrRejected, nil, "transaction is not created")
+	}
+
+	id := block.NewTransactionAt(*t.SenderBlockchainAddress, *t.RecipientBlochainAddress, *t.Value, *t.Timestamp).Hash()
+	return &SentTransaction{ID: fmt.Sprintf("%x", id), Transaction: t}, nil
+}
+
+type Mempool struct {
+	Transactions []*block.Transaction `json:"transactions"`
+	Length       int                  `json:"length"`
+}
+
+func (s *NodeService) Mempool() *Mempool {
+	transactions := s.bcs.GetBlockchain().TransactionPool()
+	return &Mempool{Transactions: transactions, Length: len(transactions)}
+}
+
+type PeerInfo struct {
+	Neighbors []string `json:"neighbors"`
+	Static    bool     `json:"static"`
+}
+
+// PeerInfo lists the neighbors. Static is true when they come from the
+// configuration rather than from scanning the port range.
+func (s *NodeService) PeerInfo() *PeerInfo {
+	return &PeerInfo{
+		Neighbors: s.bcs.GetBlockchain().Neighbors(),
+		Static:    len(s.bcs.cfg.Node.Peers) > 0,
+	}
+}
+
+type MiningInfo struct {
+	Network           string  `json:"network"`
+	Height            int     `json:"height"`
+	Difficulty        int     `json:"difficulty"`
+	Subsidy           float32 `json:"subsidy"`
+	NextHalvingHeight int     `json:"next_halving_height"`
+	Mining            bool    `json:"mining"`
+	Pool              bool    `json:"pool"`
+	MinerAddress      string  `json:"miner_address"`
+	PendingTxs        int     `json:"pending_transactions"`
+}
+
+func (s *NodeService) MiningInfo() *MiningInfo {
+	bc := s.bcs.GetBlockchain()
+	height := bc.Height()
+	return &MiningInfo{
+		Network:           s.bcs.params.Name,
+		Height:            height,
+		Difficulty:        s.bcs.params.MiningDifficulty,
+		Subsidy:           s.bcs.params.Subsidy(height + 1),
+		NextHalvingHeight: s.bcs.params.NextHalving(height + 1),
+		Mining:            bc.IsMining(),
+		Pool:              s.bcs.cfg.Node.Mining.Pool,
+		MinerAddress:      bc.BlockchainAddress(),
+		PendingTxs:        len(bc.TransactionPool()),
+	}
+}
+
+// Mine mines one block with the pending transactions.
+func (s *NodeService) Mine() error {
+	if !s.bcs.GetBlockchain().Mining() {
+		return serviceError(ErrRejected, nil, "nothing to mine")
+	}
+	return nil
+}
+
+// StartMining starts the mining loop unless it already runs.
+func (s *NodeService) StartMining() error {
+	bc := s.bcs.GetBlockchain()
+	if bc.IsMining() {
+		return serviceError(ErrRejected, nil, "already mining")
+	}
+	bc.StartMining()
+	return nil
+}
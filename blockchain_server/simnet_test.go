@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Nico2220/blockchain/block"
+	"github.com/Nico2220/blockchain/clock"
+	"github.com/Nico2220/blockchain/config"
+	"github.com/Nico2220/blockchain/entropy"
+	"github.com/Nico2220/blockchain/wallet"
+)
+
+// simNetwork runs nodes in one process. Their requests to each other go
+// through simTransport, which can cut the network in partitions and delay or
+// drop the messages of a link. The nodes share a fake clock the test
+// advances, which also measures the delays, and their keys come from fake
+// entropy, so that a run always mines the same blocks. Nothing runs in the
+// background: the test mines, relays and resolves conflicts step by step.
+type simNetwork struct {
+	t     testing.TB
+	clock *clock.Fake
+	nodes []*simNode
+
+	// wallet holds the coins of the genesis block.
+	wallet *wallet.Wallet
+
+	mu         sync.Mutex
+	partitions map[string]int
+	links      map[[2]string]simLink
+	rand       *rand.Rand
+}
+
+type simNode struct {
+	address string
+	bcs     *BlockchainServer
+	bc      *block.Blockchain
+	handler http.Handler
+}
+
+// simLink tells how the messages sent from one node to another behave.
+type simLink struct {
+	Delay time.Duration
+	// Drop is the probability a message is lost.
+	Drop float64
+}
+
+// newSimNetwork starts n nodes that all know each other.
+func newSimNetwork(t testing.TB, n int) *simNetwork {
+	t.Helper()
+	sim := &simNetwork{
+		t:          t,
+		clock:      clock.NewFake(time.Unix(1735689600, 0)),
+		partitions: make(map[string]int),
+		links:      make(map[[2]string]simLink),
+		rand:       rand.New(rand.NewSource(1)),
+	}
+	w, err := wallet.NewWalletFrom(entropy.NewFake(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.wallet = w
+
+	params := block.RegtestParams()
+	params.Genesis.Allocations = []*block.Allocation{{Address: sim.wallet.BlockchainAddress(), Amount: 100}}
+
+	addresses := make([]string, n)
+	for i := range addresses {
+		addresses[i] = fmt.Sprintf("node%d:5000", i)
+	}
+	for i, address := range addresses {
+		cfg := config.Default()
+		cfg.Node.Listen = ":5000"
+		cfg.Node.Mining.Address = sim.minerAddress(i)
+		for _, peer := range addresses {
+			if peer != address {
+				cfg.Node.Peers = append(cfg.Node.Peers, peer)
+			}
+		}
+
+		bcs := NewBlockchainServer(cfg, params)
+		bcs.clock = sim.clock
+		bcs.entropy = entropy.NewFake(int64(i + 1))
+		bc := bcs.GetBlockchain()
+		bc.SetTransport(&simTransport{sim: sim, from: address})
+		bc.SyncNeighbors()
+		sim.nodes = append(sim.nodes, &simNode{address: address, bcs: bcs, bc: bc, handler: bcs.routes()})
+	}
+	return sim
+}
+
+func (sim *simNetwork) minerAddress(i int) string {
+	return fmt.Sprintf("1SimMiner%d", i)
+}
+
+// setPeers replaces the neighbors of node i.
+func (sim *simNetwork) setPeers(i int, peers ...int) {
+	addresses := make([]string, len(peers))
+	for j, p := range peers {
+		addresses[j] = sim.nodes[p].address
+	}
+	sim.nodes[i].bc.SetPeers(addresses)
+	sim.nodes[i].bc.SyncNeighbors()
+}
+
+// partition splits the network: nodes of different groups can't reach each
+// other. Nodes left out of every group form one more group.
+func (sim *simNetwork) partition(groups ...[]int) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	for _, node := range sim.nodes {
+		sim.partitions[node.address] = len(groups)
+	}
+	for g, group := range groups {
+		for _, i := range group {
+			sim.partitions[sim.nodes[i].address] = g
+		}
+	}
+}
+
+// heal ends the partitions. The links keep their delay and drop rate.
+func (sim *simNetwork) heal() {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.partitions = make(map[string]int)
+}
+
+// setLink sets how the messages from node from to node to behave.
+func (sim *simNetwork) setLink(from, to int, link simLink) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.links[[2]string{sim.nodes[from].address, sim.nodes[to].address}] = link
+}
+
+// route returns the node a message is delivered to and the link it takes,
+// or an error when the message doesn't get there.
+func (sim *simNetwork) route(from, to string) (*simNode, simLink, error) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	var node *simNode
+	for _, n := range sim.nodes {
+		if n.address == to {
+			node = n
+		}
+	}
+	if node == nil {
+		return nil, simLink{}, fmt.Errorf("simnet: no node at %s", to)
+	}
+	if sim.partitions[from] != sim.partitions[to] {
+		return nil, simLink{}, fmt.Errorf("simnet: %s cannot reach %s", from, to)
+	}
+	link := sim.links[[2]string{from, to}]
+	if link.Drop > 0 && sim.rand.Float64() < link.Drop {
+		return nil, simLink{}, fmt.Errorf("simnet: message from %s to %s dropped", from, to)
+	}
+	return node, link, nil
+}
+
+// send signs a transfer from the genesis wallet and posts it to node i, which
+// relays it to its neighbors.
+func (sim *simNetwork) send(i int, recipient string, value float32) int {
+	sim.t.Helper()
+	w := sim.wallet
+	sender := w.BlockchainAddress()
+	publicKey := w.PublicKeyStr()
+	t := wallet.NewTransactionAt(w.PrivateKey(), w.PublicKey(), sender, recipient, value, sim.clock.Now().UnixNano())
+	signature := t.GenerateSignatureFrom(sim.nodes[i].bcs.entropy).String()
+	timeStamp := t.Timestamp()
+	body, _ := json.Marshal(block.TransactionRequest{
+		SenderBlockchainAddress:  &sender,
+		RecipientBlochainAddress: &recipient,
+		SenderPublicKey:          &publicKey,
+		Value:                    &value,
+		Signature:                &signature,
+		Timestamp:                &timeStamp,
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/transactions", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	sim.nodes[i].handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+// mine makes node i mine a block with its transaction pool a second after
+// the previous one, then tell its neighbors.
+func (sim *simNetwork) mine(i int) {
+	sim.t.Helper()
+	sim.clock.Advance(time.Second)
+	if !sim.nodes[i].bc.Mining() {
+		sim.t.Fatalf("node %d mined nothing", i)
+	}
+}
+
+// sync makes every node resolve conflicts with its neighbors.
+func (sim *simNetwork) sync() {
+	for _, node := range sim.nodes {
+		node.bc.ResolveConfilcts()
+	}
+}
+
+// requireConverged checks that the nodes share the same tip and agree on the
+// balances of the genesis wallet, the miners and addresses.
+func (sim *simNetwork) requireConverged(nodes []int, addresses ...string) {
+	sim.t.Helper()
+	addresses = append(addresses, sim.wallet.BlockchainAddress())
+	for i := range sim.nodes {
+		addresses = append(addresses, sim.minerAddress(i))
+	}
+
+	first := sim.nodes[nodes[0]].bc
+	for _, i := range nodes[1:] {
+		bc := sim.nodes[i].bc
+		if bc.LasBlock().Hash() != first.LasBlock().Hash() {
+			sim.t.Fatalf("node %d is at height %d, node %d at height %d with another tip",
+				i, bc.Height(), nodes[0], first.Height())
+		}
+		for _, address := range addresses {
+			if got, want := *bc.CalculateBalance(address), *first.CalculateBalance(address); got != want {
+				sim.t.Fatalf("node %d gives %s the balance %+v, node %d %+v", i, address, got, nodes[0], want)
+			}
+		}
+	}
+}
+
+func (sim *simNetwork) all() []int {
+	nodes := make([]int, len(sim.nodes))
+	for i := range nodes {
+		nodes[i] = i
+	}
+	return nodes
+}
+
+// simTransport delivers the requests of a node straight to the handler of
+// the node they are sent to.
+type simTransport struct {
+	sim  *simNetwork
+	from string
+}
+
+func (tr *simTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+	node, link, err := tr.sim.route(tr.from, req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	if link.Delay > 0 {
+		select {
+		case <-tr.sim.clock.After(link.Delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+
+	in := req.Clone(req.Context())
+	in.RemoteAddr = tr.from
+	in.RequestURI = req.URL.RequestURI()
+	if in.Body == nil {
+		in.Body = http.NoBody
+	}
+	rec := httptest.NewRecorder()
+	node.handler.ServeHTTP(rec, in)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// TestReadinessCutOff checks that a node none of whose neighbors answers the
+// sync fails the peers check, even though its sync lag looks fine. The
+// simulated nodes have no store, so only the peers check is looked at.
+func TestReadinessCutOff(t *testing.T) {
+	sim := newSimNetwork(t, 3)
+	peers := func() *Readiness {
+		sim.sync()
+		return sim.nodes[0].bcs.Service().Readiness()
+	}
+	if r := peers(); r.Checks["peers"] != "ok" {
+		t.Fatalf("connected node: %+v", r)
+	}
+
+	sim.partition([]int{0})
+	if r := peers(); r.Ready || r.Checks["peers"] == "ok" || r.SyncLag != 0 {
+		t.Fatalf("cut off node: %+v", r)
+	}
+
+	sim.heal()
+	if r := peers(); r.Checks["peers"] != "ok" {
+		t.Fatalf("reconnected node: %+v", r)
+	}
+}
+
+// TestBlocksPagePruned checks that a pruned node answers 410 for a page that
+// starts below its first body, and an empty page past the genesis block.
+func TestBlocksPagePruned(t *testing.T) {
+	sim := newSimNetwork(t, 1)
+	bc := sim.nodes[0].bc
+	store, err := block.OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	bc.SetPruning(block.MIN_PRUNE_BLOCKS)
+	if err := bc.LoadStore(store); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 30; i++ {
+		sim.send(0, testBob, 1)
+		sim.mine(0)
+	}
+	if first := bc.FirstBody(); first != 21 {
+		t.Fatalf("first body %d, want 21", first)
+	}
+
+	for offset, want := range map[int]int{
+		0:  http.StatusOK,
+		9:  http.StatusOK,
+		10: http.StatusGone,
+		20: http.StatusGone,
+		31: http.StatusOK,
+		99: http.StatusOK,
+	} {
+		rec := httptest.NewRecorder()
+		sim.nodes[0].handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/blocks?offset=%d", offset), nil))
+		if rec.Code != want {
+			t.Errorf("offset %d: answered %d, want %d: %s", offset, rec.Code, want, rec.Body)
+			continue
+		}
+		var page struct {
+			Blocks []json.RawMessage `json:"blocks"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &page)
+		if offset > 30 && len(page.Blocks) != 0 {
+			t.Errorf("offset %d: %d blocks past the genesis block", offset, len(page.Blocks))
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Nico2220/blockchain/block"
+	"github.com/Nico2220/blockchain/utils"
+)
+
+// GetSnapshotHandler serves the latest state snapshot as JSON, or in the
+// binary encoding its commitment is computed on to the peers that ask for it.
+func (bcs *BlockchainServer) GetSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	s := bcs.GetBlockchain().Snapshot()
+	if s == nil {
+		utils.WriteJSON(w, http.StatusNotFound, Wrapper{"error": "no snapshot taken yet"})
+		return
+	}
+
+	if r.Header.Get("Accept") == block.BINARY_CONTENT_TYPE {
+		data, _ := s.MarshalBinary()
+		w.Header().Set("Content-Type", block.BINARY_CONTENT_TYPE)
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, Wrapper{"snapshot": s})
+}
+
+// GetHeadersHandler serves up to limit block headers from height from on,
+// which fast syncing peers check a snapshot against.
+func (bcs *BlockchainServer) GetHeadersHandler(w http.ResponseWriter, r *http.Request) {
+	from, limit := 0, block.MAX_HEADERS
+	var err error
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = strconv.Atoi(v); err != nil || from < 0 {
+			utils.WriteJSON(w, http.StatusBadRequest, Wrapper{"error": "from must be a non negative height"})
+			return
+		}
+	}
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > block.MAX_HEADERS {
+			utils.WriteJSON(w, http.StatusBadRequest, Wrapper{"error": "limit must be between 1 and " + strconv.Itoa(block.MAX_HEADERS)})
+			return
+		}
+	}
+
+	bc := bcs.GetBlockchain()
+	utils.WriteJSON(w, http.StatusOK, Wrapper{"headers": bc.Headers(from, limit), "height": bc.Height()})
+}
+
+// GetLimitsHandler advertises which blocks the node can serve, so that peers
+// don't ask a pruned node for bodies it no longer has.
+func (bcs *BlockchainServer) GetLimitsHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, bcs.GetBlockchain().Limits())
+}
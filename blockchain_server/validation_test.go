@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// FuzzTransactionHandlers posts client input to the transaction endpoints.
+// Malformed input is the client's fault: it must never cause a 500.
+func FuzzTransactionHandlers(f *testing.F) {
+	sim := newSimNetwork(f, 1)
+	f.Add([]byte(`{"sender_blochain_address":"a","recipient_blockchain_address":"b","sender_public_key":"00","value":1,"signature":"00"}`))
+	f.Add([]byte(`{"sender_public_key":"` + sim.wallet.PublicKeyStr() + `"}`))
+	f.Add([]byte(`null`))
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		// Refill the rate limit buckets.
+		sim.clock.Advance(time.Minute)
+		for _, method := range []string{http.MethodPost, http.MethodPut} {
+			req := httptest.NewRequest(method, "/transactions", bytes.NewReader(body))
+			rec := httptest.NewRecorder()
+			sim.nodes[0].handler.ServeHTTP(rec, req)
+			if rec.Code >= http.StatusInternalServerError {
+				t.Fatalf("%s %s answered %d: %s", method, body, rec.Code, rec.Body)
+			}
+		}
+	})
+}
+
+func TestMalformedKeys(t *testing.T) {
+	sim := newSimNetwork(t, 1)
+	publicKey := sim.wallet.PublicKeyStr()
+	tests := map[string]string{
+		"short key":       `{"sender_blochain_address":"a","recipient_blockchain_address":"b","sender_public_key":"00","value":1,"signature":"` + publicKey + `"}`,
+		"short signature": `{"sender_blochain_address":"a","recipient_blockchain_address":"b","sender_public_key":"` + publicKey + `","value":1,"signature":"00"}`,
+	}
+	for name, body := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/transactions", bytes.NewReader([]byte(body)))
+		rec := httptest.NewRecorder()
+		sim.nodes[0].handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: answered %d, want 422: %s", name, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	sim := newSimNetwork(t, 1)
+	post := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/transactions", bytes.NewReader([]byte(`{}`)))
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		sim.nodes[0].handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	burst := sim.nodes[0].bcs.cfg.Node.Limits["POST /transactions"].Burst
+	for i := 0; i < burst; i++ {
+		if rec := post("203.0.113.1:1000"); rec.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("request %d answered %d", i, rec.Code)
+		}
+	}
+	rec := post("203.0.113.1:1001")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("over the burst: %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := post("203.0.113.2:1000"); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("another client answered %d", rec.Code)
+	}
+	sim.clock.Advance(time.Second)
+	if rec := post("203.0.113.1:1000"); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("after a second: %d", rec.Code)
+	}
+
+	big := httptest.NewRequest(http.MethodPost, "/transactions", bytes.NewReader(make([]byte, 1<<20)))
+	rec = httptest.NewRecorder()
+	sim.nodes[0].handler.ServeHTTP(rec, big)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("a 1 MiB body answered %d", rec.Code)
+	}
+}
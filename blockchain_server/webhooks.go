@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Nico2220/blockchain/utils"
+	"github.com/Nico2220/blockchain/webhook"
+)
+
+// RegisterWebhookHandler registers a webhook. The secret used to sign the
+// deliveries is only returned here: generated when the request has none.
+func (bcs *BlockchainServer) RegisterWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var wr webhook.WebhookRequest
+	err := utils.ReadJSON(r, &wr)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, Wrapper{"error": err.Error()})
+		return
+	}
+
+	if mapError := wr.Validate(); len(mapError) > 0 {
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, Wrapper{"error": mapError})
+		return
+	}
+
+	wh, err := bcs.webhooks.Register(&wr)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, Wrapper{"error": err.Error()})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, Wrapper{"webhook": wh})
+}
+
+func (bcs *BlockchainServer) GetWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, Wrapper{"webhooks": bcs.webhooks.Webhooks()})
+}
+
+func (bcs *BlockchainServer) DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	err := bcs.webhooks.Unregister(r.PathValue("id"))
+	if errors.Is(err, webhook.ErrNotFound) {
+		utils.WriteJSON(w, http.StatusNotFound, Wrapper{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		utils.WriteJSON(w, http.StatusInternalServerError, Wrapper{"error": err.Error()})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, Wrapper{"deleted": r.PathValue("id")})
+}
+
+func (bcs *BlockchainServer) GetWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	pending, dead := bcs.webhooks.Deliveries()
+	utils.WriteJSON(w, http.StatusOK, Wrapper{"pending": pending, "dead": dead})
+}
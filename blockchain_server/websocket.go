@@ -0,0 +1,203 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nico2220/blockchain/events"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	WS_EVENT_BUFFER  = 256
+	WS_WRITE_TIMEOUT = 10 * time.Second
+	WS_PING_PERIOD   = 30 * time.Second
+	WS_PONG_TIMEOUT  = 60 * time.Second
+	WS_MAX_MESSAGE   = 4096
+
+	ADDRESS_TOPIC_PREFIX = "address:"
+)
+
+// checkOrigin accepts the upgrades without an Origin header, which don't
+// come from a browser, the ones from the pages of the node itself and the ones
+// from node.allowed_origins, so that any other web page its users visit
+// can't subscribe in their name.
+func (bcs *BlockchainServer) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range bcs.cfg.Node.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// wsRequest is what clients send: {"action": "subscribe", "topic": "newBlock"}.
+type wsRequest struct {
+	Action string `json:"action"`
+	Topic  string `json:"topic"`
+}
+
+// wsMessage is what the server sends: either an acknowledgement, an error or
+// an event published on one of the subscribed topics.
+type wsMessage struct {
+	Type  string        `json:"type"`
+	Topic string        `json:"topic,omitempty"`
+	Error string        `json:"error,omitempty"`
+	Event *events.Event `json:"event,omitempty"`
+}
+
+type wsClient struct {
+	conn *websocket.Conn
+	send chan *wsMessage
+
+	mu     sync.Mutex
+	topics map[string]bool
+}
+
+func validTopic(topic string) bool {
+	if events.Type(topic).Valid() {
+		return true
+	}
+	return strings.HasPrefix(topic, ADDRESS_TOPIC_PREFIX) && len(topic) > len(ADDRESS_TOPIC_PREFIX)
+}
+
+// matches returns the subscribed topics an event belongs to.
+func (c *wsClient) matches(e *events.Event) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var topics []string
+	if c.topics[string(e.Type)] {
+		topics = append(topics, string(e.Type))
+	}
+	// Address topics follow the activity of an address, not reorgs.
+	if e.Type == events.REORG {
+		return topics
+	}
+	for _, address := range e.Addresses {
+		if topic := ADDRESS_TOPIC_PREFIX + address; c.topics[topic] {
+			topics = append(topics, topic)
+		}
+	}
+	return topics
+}
+
+// WebSocketHandler upgrades the connection and streams the events of the
+// topics the client subscribes to.
+func (bcs *BlockchainServer) WebSocketHandler(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024, CheckOrigin: bcs.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		apiLog.WarnContext(r.Context(), "websocket upgrade failed", "err", err)
+		return
+	}
+
+	c := &wsClient{
+		conn:   conn,
+		send:   make(chan *wsMessage, WS_EVENT_BUFFER),
+		topics: make(map[string]bool),
+	}
+	sub := bcs.GetBlockchain().Events().Subscribe(WS_EVENT_BUFFER)
+	done := make(chan struct{})
+
+	go c.writeLoop(sub, done)
+	c.readLoop()
+
+	close(done)
+	sub.Unsubscribe()
+}
+
+func (c *wsClient) readLoop() {
+	c.conn.SetReadLimit(WS_MAX_MESSAGE)
+	c.conn.SetReadDeadline(time.Now().Add(WS_PONG_TIMEOUT))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(WS_PONG_TIMEOUT))
+	})
+
+	for {
+		var req wsRequest
+		if err := c.conn.ReadJSON(&req); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				apiLog.Warn("websocket read failed", "err", err)
+			}
+			return
+		}
+
+		if !validTopic(req.Topic) {
+			c.reply(&wsMessage{Type: "error", Topic: req.Topic, Error: "unknown topic"})
+			continue
+		}
+
+		c.mu.Lock()
+		switch req.Action {
+		case "subscribe":
+			c.topics[req.Topic] = true
+		case "unsubscribe":
+			delete(c.topics, req.Topic)
+		}
+		c.mu.Unlock()
+
+		switch req.Action {
+		case "subscribe":
+			c.reply(&wsMessage{Type: "subscribed", Topic: req.Topic})
+		case "unsubscribe":
+			c.reply(&wsMessage{Type: "unsubscribed", Topic: req.Topic})
+		default:
+			c.reply(&wsMessage{Type: "error", Error: "action must be subscribe or unsubscribe"})
+		}
+	}
+}
+
+func (c *wsClient) reply(m *wsMessage) {
+	select {
+	case c.send <- m:
+	default:
+	}
+}
+
+// writeLoop is the only goroutine writing to the connection.
+func (c *wsClient) writeLoop(sub *events.Subscription, done chan struct{}) {
+	ticker := time.NewTicker(WS_PING_PERIOD)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	write := func(m *wsMessage) bool {
+		c.conn.SetWriteDeadline(time.Now().Add(WS_WRITE_TIMEOUT))
+		return c.conn.WriteJSON(m) == nil
+	}
+
+	for {
+		select {
+		case <-done:
+			return
+		case m := <-c.send:
+			if !write(m) {
+				return
+			}
+		case e, ok := <-sub.C():
+			if !ok {
+				return
+			}
+			for _, topic := range c.matches(&e) {
+				if !write(&wsMessage{Type: "event", Topic: topic, Event: &e}) {
+					return
+				}
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(WS_WRITE_TIMEOUT))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebSocketOrigin(t *testing.T) {
+	sim := newSimNetwork(t, 1)
+	sim.nodes[0].bcs.cfg.Node.AllowedOrigins = []string{"https://explorer.example.com"}
+	server := httptest.NewServer(sim.nodes[0].handler)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	for origin, want := range map[string]bool{
+		"":                             true,
+		server.URL:                     true,
+		"https://explorer.example.com": true,
+		"https://EXPLORER.example.com": true,
+		"https://evil.example.com":     false,
+		"null":                         false,
+	} {
+		// Refill the rate limit buckets.
+		sim.clock.Advance(time.Minute)
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if conn != nil {
+			conn.Close()
+		}
+		if (err == nil) != want {
+			status := 0
+			if resp != nil {
+				status = resp.StatusCode
+			}
+			t.Errorf("origin %q: %v, answered %d, want accepted %v", origin, err, status, want)
+		}
+	}
+}
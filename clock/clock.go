@@ -0,0 +1,96 @@
+// Package clock lets the code that waits on time run against a clock tests
+// control.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits for it to pass.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// Real is the system clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Fake is a Clock that only moves when Advance is called.
+type Fake struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*waiter
+}
+
+type waiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// After fires once the clock was advanced by d, right away when d isn't
+// positive.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w := &waiter{at: f.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- f.now
+		return w.c
+	}
+	f.waiters = append(f.waiters, w)
+	f.cond.Broadcast()
+	return w.c
+}
+
+// Advance moves the clock forward by d and fires the waits that are due.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+	pending := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.at.After(f.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.c <- f.now
+	}
+	f.waiters = pending
+	f.cond.Broadcast()
+}
+
+// BlockUntil returns once n waits are pending, so that a test advances the
+// clock only after the goroutines it runs started waiting.
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
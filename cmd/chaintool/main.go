@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Nico2220/blockchain/block"
+)
+
+// chaintool exports the chain of a data directory to an archive and imports
+// an archive into a data directory. Import validates every block like a node
+// does, so the node should be stopped while it runs.
+//
+//	chaintool export -data-dir data -from 0 -to 100 -format jsonl -o chain.jsonl
+//	chaintool import -data-dir data -network regtest chain.jsonl
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = export(os.Args[2:])
+	case "import":
+		err = importArchive(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: chaintool export|import [flags]")
+	os.Exit(2)
+}
+
+func export(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	dataDir := fs.String("data-dir", "data", "data directory of the node")
+	from := fs.Int("from", 0, "height of the first exported block")
+	to := fs.Int("to", -1, "height of the last exported block, the tip when negative")
+	format := fs.String("format", block.FORMAT_JSONL, "archive format: jsonl or binary")
+	output := fs.String("o", "-", "file to write, - for the standard output")
+	fs.Parse(args)
+
+	blocks, err := block.ReadStore(*dataDir)
+	if err != nil {
+		return err
+	}
+	last := *to
+	if last < 0 || last >= len(blocks) {
+		last = len(blocks) - 1
+	}
+	if *from < 0 || *from > last {
+		return fmt.Errorf("no blocks between heights %d and %d, the chain ends at %d", *from, *to, len(blocks)-1)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	aw, err := block.NewArchiveWriter(w, *format, *from)
+	if err != nil {
+		return err
+	}
+	for _, b := range blocks[*from : last+1] {
+		if err := aw.Write(b); err != nil {
+			return err
+		}
+	}
+	if err := aw.Flush(); err != nil {
+		return err
+	}
+	log.Printf("exported blocks %d to %d", *from, last)
+	return nil
+}
+
+func importArchive(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dataDir := fs.String("data-dir", "data", "data directory to import into, created when missing")
+	network := fs.String("network", "mainnet", "network preset: mainnet, testnet or regtest")
+	genesis := fs.String("genesis", "", "path to a genesis JSON file")
+	every := fs.Int("progress", 1000, "report progress every this many blocks")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: chaintool import [flags] archive")
+	}
+
+	params, err := block.LoadNetworkParams(*network, *genesis)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ar, err := block.NewArchiveReader(f)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(*dataDir, 0o755); err != nil {
+		return err
+	}
+	store, err := block.OpenStore(*dataDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	bc := block.NewBlockchain("", 0, params)
+	if err := bc.LoadStore(store); err != nil {
+		return err
+	}
+	log.Printf("importing a %s archive on top of height %d", ar.Format(), bc.Height())
+
+	start := time.Now()
+	imported, skipped := 0, 0
+	report := func() {
+		rate := float64(imported) / time.Since(start).Seconds()
+		log.Printf("height=%d imported=%d skipped=%d rate=%.0f blocks/s", bc.Height(), imported, skipped, rate)
+	}
+	for {
+		height, b, err := ar.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		// Blocks the data directory already holds are skipped as long as
+		// the archive agrees with them.
+		if height <= bc.Height() {
+			if height == 0 && bc.Chain()[0].Hash() != b.Hash() {
+				return block.ErrGenesisMismatch
+			}
+			if bc.Chain()[height].Hash() != b.Hash() {
+				return fmt.Errorf("block %d of the archive differs from the one in %s", height, *dataDir)
+			}
+			skipped++
+			continue
+		}
+		if height > bc.Height()+1 {
+			return fmt.Errorf("the archive continues at block %d but the chain ends at %d", height, bc.Height())
+		}
+		if err := bc.AddBlock(b); err != nil {
+			return err
+		}
+		imported++
+		if *every > 0 && imported%*every == 0 {
+			report()
+		}
+	}
+	if *every <= 0 || imported%*every != 0 || imported == 0 {
+		report()
+	}
+	return nil
+}
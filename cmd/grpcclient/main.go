@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/Nico2220/blockchain/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+)
+
+// grpcclient calls the Node gRPC service with the generated client:
+//
+//	grpcclient [-node :15001] info
+//	grpcclient block <height>
+//	grpcclient balance <address>
+//	grpcclient mempool
+//	grpcclient watch-blocks
+//	grpcclient watch-txs [address]
+func main() {
+	node := flag.String("node", "localhost:15001", "address of the node gRPC API")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	conn, err := grpc.NewClient(*node, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	client := pb.NewNodeClient(conn)
+	ctx := context.Background()
+	args := flag.Args()
+
+	switch args[0] {
+	case "info":
+		print(client.GetMiningInfo(ctx, &pb.GetMiningInfoRequest{}))
+	case "block":
+		height, err := strconv.ParseInt(arg(args, 1), 10, 64)
+		if err != nil {
+			log.Fatal("height must be an integer")
+		}
+		print(client.GetBlockByHeight(ctx, &pb.GetBlockByHeightRequest{Height: height}))
+	case "balance":
+		print(client.GetBalance(ctx, &pb.GetBalanceRequest{BlockchainAddress: arg(args, 1)}))
+	case "mempool":
+		print(client.GetMempool(ctx, &pb.GetMempoolRequest{}))
+	case "watch-blocks":
+		stream, err := client.SubscribeBlocks(ctx, &pb.SubscribeBlocksRequest{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		for {
+			print(stream.Recv())
+		}
+	case "watch-txs":
+		req := &pb.SubscribePendingTransactionsRequest{}
+		if len(args) > 1 {
+			req.BlockchainAddress = args[1]
+		}
+		stream, err := client.SubscribePendingTransactions(ctx, req)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for {
+			print(stream.Recv())
+		}
+	default:
+		log.Fatalf("unknown command %q", args[0])
+	}
+}
+
+func arg(args []string, i int) string {
+	if len(args) <= i {
+		log.Fatalf("%s: missing argument", args[0])
+	}
+	return args[i]
+}
+
+func print(m proto.Message, err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+	js, _ := json.MarshalIndent(m, "", "\t")
+	fmt.Println(string(js))
+}
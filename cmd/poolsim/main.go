@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Nico2220/blockchain/block"
+	"github.com/Nico2220/blockchain/pool"
+	"github.com/Nico2220/blockchain/wallet"
+)
+
+// poolsim runs a pool and a few simulated miners in one process so that the
+// share accounting can be checked without starting any server.
+func main() {
+	workers := flag.Int("workers", 3, "number of simulated miners")
+	duration := flag.Duration("duration", 10*time.Second, "how long the miners run")
+	network := flag.String("network", "regtest", "network preset")
+	flag.Parse()
+
+	params, err := block.NetworkParamsByName(*network)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	minerWallet := wallet.NewWallet()
+	bc := block.NewBlockchain(minerWallet.BlockchainAddress(), 0, params)
+	p := pool.NewPool(bc, wallet.NewWallet(), pool.SHARE_DIFFICULTY)
+
+	wallets := make([]*wallet.Wallet, *workers)
+	for i := range wallets {
+		wallets[i] = wallet.NewWallet()
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, w := range wallets {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			mine(bc, p, address, stop)
+		}(w.BlockchainAddress())
+	}
+
+	time.Sleep(*duration)
+	close(stop)
+	wg.Wait()
+
+	// Confirm the pending payouts.
+	bc.Mining()
+
+	stats := p.Stats()
+	fmt.Printf("blocks found: %d\n", stats.BlocksFound)
+	var paid float32
+	for _, w := range wallets {
+		address := w.BlockchainAddress()
+		ws, ok := stats.Workers[address]
+		if !ok {
+			ws = &pool.WorkerStats{}
+		}
+		paid += ws.Paid
+		fmt.Printf("%s shares=%d paid=%.4f balance=%.4f\n",
+			address, ws.TotalShares, ws.Paid, bc.CalculateTotalAmount(address))
+	}
+	fmt.Printf("total paid: %.4f, immature: %.4f, expected: %.4f\n", paid,
+		stats.ImmatureRewards*(1-pool.POOL_FEE), stats.TotalRewards*(1-pool.POOL_FEE))
+}
+
+func mine(bc *block.Blockchain, p *pool.Pool, address string, stop chan struct{}) {
+	for {
+		job := p.GetWork(address)
+		for nonce := job.NonceStart; nonce < job.NonceEnd; nonce++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			if !bc.ValidProof(nonce, job.PreviousHash, job.Transactions, job.ShareDifficulty) {
+				continue
+			}
+			result, err := p.SubmitShare(address, job.ID, nonce)
+			if errors.Is(err, pool.ErrStaleShare) || errors.Is(err, pool.ErrUnknownJob) {
+				break
+			}
+			if err != nil {
+				log.Printf("share rejected: %v", err)
+				continue
+			}
+			if result.BlockFound {
+				break
+			}
+		}
+	}
+}
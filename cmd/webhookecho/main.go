@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/Nico2220/blockchain/webhook"
+)
+
+// webhookecho is a local webhook endpoint: it checks the signature of every
+// delivery and prints it. With -fail-rate it answers 500 to a share of the
+// deliveries so that the retries of the node can be watched.
+func main() {
+	listen := flag.String("listen", ":9000", "address to listen on")
+	secret := flag.String("secret", "", "secret of the webhook, signatures are not checked when empty")
+	failRate := flag.Float64("fail-rate", 0, "share of the deliveries answered with 500")
+	flag.Parse()
+
+	http.HandleFunc("POST /", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if *secret != "" && !webhook.Verify(*secret, body, r.Header.Get(webhook.TIMESTAMP_HEADER),
+			r.Header.Get(webhook.SIGNATURE_HEADER), time.Now()) {
+			log.Printf("delivery %s: invalid signature", r.Header.Get("X-Webhook-Delivery"))
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		if rand.Float64() < *failRate {
+			log.Printf("delivery %s: failing on purpose", r.Header.Get("X-Webhook-Delivery"))
+			http.Error(w, "failing on purpose", http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("delivery %s %s: %s", r.Header.Get("X-Webhook-Delivery"), r.Header.Get("X-Webhook-Event"), body)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	fmt.Println("webhookecho listening on:", *listen)
+	log.Fatal(http.ListenAndServe(*listen, nil))
+}
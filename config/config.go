@@ -0,0 +1,589 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nico2220/blockchain/block"
+	"github.com/Nico2220/blockchain/logging"
+	"github.com/Nico2220/blockchain/ratelimit"
+	"github.com/Nico2220/blockchain/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// Component selects which server a configuration is loaded for. Both servers
+// read the same file but only register the flags they use.
+type Component int
+
+const (
+	Node Component = iota
+	Wallet
+)
+
+const (
+	DEFAULT_DATA_DIR      = "data"
+	DEFAULT_LOG_LEVEL     = "info"
+	DEFAULT_LOG_FORMAT    = logging.FORMAT_TEXT
+	DEFAULT_WALLET_LISTEN = ":8080"
+	DEFAULT_MAX_SYNC_LAG  = 2
+	MIN_API_TOKEN_LENGTH  = 16
+
+	// GRPC_PORT_OFFSET separates the default gRPC port of a server from the
+	// port of its HTTP API.
+	GRPC_PORT_OFFSET = 10000
+)
+
+type Config struct {
+	Network  string `yaml:"network"`
+	Genesis  string `yaml:"genesis"`
+	DataDir  string `yaml:"data_dir"`
+	LogLevel string `yaml:"log_level"`
+	// LogLevels overrides LogLevel for some subsystems, e.g. p2p: debug.
+	LogLevels map[string]string `yaml:"log_levels"`
+	// LogFormat is text or json.
+	LogFormat string       `yaml:"log_format"`
+	Node      NodeConfig   `yaml:"node"`
+	Wallet    WalletConfig `yaml:"wallet"`
+}
+
+type NodeConfig struct {
+	Listen     string       `yaml:"listen"`
+	GRPCListen string       `yaml:"grpc_listen"`
+	Peers      []string     `yaml:"peers"`
+	Mining     MiningConfig `yaml:"mining"`
+	Auth       AuthConfig   `yaml:"auth"`
+	Sync       SyncConfig   `yaml:"sync"`
+	// Prune is the number of recent block bodies kept, at least the
+	// SnapshotInterval of the network, 0 keeps them all.
+	Prune int `yaml:"prune"`
+	// MaxSyncLag is how many blocks the node may be behind its best peer
+	// and still report itself ready.
+	MaxSyncLag int `yaml:"max_sync_lag"`
+	// AllowedOrigins are the origins, e.g. https://explorer.example.com, of
+	// the web pages that may open a websocket besides the pages of the node
+	// itself. "*" allows any page.
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// Limits holds the rate, body size, timeout and concurrency limits of
+	// the HTTP routes, keyed by route, e.g. "POST /transactions", or
+	// "default". A route set in the file replaces its whole default rule.
+	Limits map[string]ratelimit.Rule `yaml:"limits"`
+}
+
+// MiningConfig configures the miner. An empty Address pays the rewards to a
+// new wallet and a zero ShareDifficulty uses the pool default.
+type MiningConfig struct {
+	Enabled         bool   `yaml:"enabled"`
+	Address         string `yaml:"address"`
+	Pool            bool   `yaml:"pool"`
+	ShareDifficulty int    `yaml:"share_difficulty"`
+}
+
+// AuthConfig protects the operator endpoints of the node. Requests must send
+// "Authorization: Bearer <token>". An empty token disables the check.
+type AuthConfig struct {
+	Token string `yaml:"token"`
+}
+
+// SyncConfig selects how a new node catches up. With FastSync it starts from
+// the state snapshot of its peers whose hex commitment is TrustedSnapshot,
+// which FastSync requires.
+type SyncConfig struct {
+	FastSync        bool   `yaml:"fast_sync"`
+	TrustedSnapshot string `yaml:"trusted_snapshot"`
+}
+
+type WalletConfig struct {
+	Listen     string `yaml:"listen"`
+	GRPCListen string `yaml:"grpc_listen"`
+	Gateway    string `yaml:"gateway"`
+	// Limits works like the node ones.
+	Limits map[string]ratelimit.Rule `yaml:"limits"`
+}
+
+// ValidationError lists every problem found in a configuration so they can
+// all be fixed at once.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+func Default() *Config {
+	return &Config{
+		DataDir:   DEFAULT_DATA_DIR,
+		LogLevel:  DEFAULT_LOG_LEVEL,
+		LogFormat: DEFAULT_LOG_FORMAT,
+		Node: NodeConfig{
+			MaxSyncLag: DEFAULT_MAX_SYNC_LAG,
+			Limits:     DefaultNodeLimits(),
+		},
+		Wallet: WalletConfig{
+			Listen: DEFAULT_WALLET_LISTEN,
+			Limits: DefaultWalletLimits(),
+		},
+	}
+}
+
+// DefaultNodeLimits protects the routes anyone can call. Peers relay
+// transactions with PUT and announce blocks on /consensus, which makes the
+// node download their chains, so it only runs one at a time.
+func DefaultNodeLimits() map[string]ratelimit.Rule {
+	return map[string]ratelimit.Rule{
+		ratelimit.DEFAULT_ROUTE: {Rate: 20, Burst: 40, MaxBody: 64 << 10, Timeout: 30 * time.Second},
+		"POST /transactions":    {Rate: 5, Burst: 10, MaxBody: 16 << 10, Timeout: 10 * time.Second},
+		"PUT /transactions":     {Rate: 50, Burst: 100, MaxBody: 16 << 10, Timeout: 10 * time.Second},
+		"POST /rpc":             {Rate: 10, Burst: 20, MaxBody: 64 << 10, Timeout: 30 * time.Second},
+		"/mine":                 {Rate: 1, Burst: 2, Timeout: 2 * time.Minute, Concurrency: 1},
+		"/mine/start":           {Rate: 1, Burst: 2, Timeout: 2 * time.Minute, Concurrency: 1},
+		"PUT /consensus":        {Rate: 2, Burst: 5, Timeout: 2 * time.Minute, Concurrency: 1},
+		"GET /ws":               {Rate: 1, Burst: 5},
+	}
+}
+
+// DefaultWalletLimits protects the wallet server. Creating a wallet
+// generates a key, sending a transaction posts it to the node.
+func DefaultWalletLimits() map[string]ratelimit.Rule {
+	return map[string]ratelimit.Rule{
+		ratelimit.DEFAULT_ROUTE: {Rate: 20, Burst: 40, MaxBody: 64 << 10, Timeout: 30 * time.Second},
+		"POST /wallet":          {Rate: 1, Burst: 5, Timeout: 10 * time.Second},
+		"POST /transactions":    {Rate: 5, Burst: 10, MaxBody: 16 << 10, Timeout: 30 * time.Second},
+	}
+}
+
+// Load builds the configuration of component from, in increasing order of
+// precedence, the defaults, the YAML file given by -config or
+// BLOCKCHAIN_CONFIG, the environment and the command line flags.
+func Load(component Component, args []string) (*Config, error) {
+	cfg := Default()
+	fs := flag.NewFlagSet(componentName(component), flag.ContinueOnError)
+	flags := cfg.register(fs, component)
+	path := fs.String("config", os.Getenv("BLOCKCHAIN_CONFIG"), "path to a YAML configuration file")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *path != "" {
+		if err := cfg.loadFile(*path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(component); err != nil {
+		return nil, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		if apply, ok := flags[f.Name]; ok {
+			apply()
+		}
+	})
+
+	if err := cfg.Validate(component); err != nil {
+		return nil, err
+	}
+	cfg.setDefaults()
+	return cfg, nil
+}
+
+func (cfg *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// register declares the flags of component. Flags are bound to scratch
+// variables and only copied into cfg when they are set explicitly, so that a
+// flag default never overrides the file or the environment.
+func (cfg *Config) register(fs *flag.FlagSet, component Component) map[string]func() {
+	flags := map[string]func(){}
+	str := func(name, usage string, dst *string) {
+		v := fs.String(name, "", usage)
+		flags[name] = func() { *dst = *v }
+	}
+	boolean := func(name, usage string, dst *bool) {
+		v := fs.Bool(name, false, usage)
+		flags[name] = func() { *dst = *v }
+	}
+
+	str("network", "network preset: mainnet (default), testnet or regtest", &cfg.Network)
+	str("genesis", "path to a genesis JSON file", &cfg.Genesis)
+	str("data-dir", "directory where the node keeps its data", &cfg.DataDir)
+	str("log-level", "debug, info, warn or error", &cfg.LogLevel)
+	logLevels := fs.String("log-levels", "", "levels of some subsystems, e.g. p2p=debug,miner=warn")
+	flags["log-levels"] = func() { cfg.LogLevels = splitLevels(*logLevels) }
+	str("log-format", "text or json", &cfg.LogFormat)
+
+	switch component {
+	case Node:
+		str("listen", "address the node listens on, e.g. :5001", &cfg.Node.Listen)
+		str("grpc-listen", "address of the gRPC API, e.g. :15001", &cfg.Node.GRPCListen)
+		peers := fs.String("peers", "", "comma separated list of peers, e.g. 127.0.0.1:5002")
+		flags["peers"] = func() { cfg.Node.Peers = splitList(*peers) }
+		boolean("mine", "start mining at startup", &cfg.Node.Mining.Enabled)
+		str("mining-address", "address that receives the block rewards", &cfg.Node.Mining.Address)
+		boolean("pool", "enable the mining pool endpoints", &cfg.Node.Mining.Pool)
+		shareDifficulty := fs.Int("share-difficulty", 0, "difficulty of the pool shares")
+		flags["share-difficulty"] = func() { cfg.Node.Mining.ShareDifficulty = *shareDifficulty }
+		str("api-token", "bearer token required by the operator endpoints", &cfg.Node.Auth.Token)
+		boolean("fast-sync", "start a new node from the trusted state snapshot of its peers", &cfg.Node.Sync.FastSync)
+		str("trusted-snapshot", "commitment of the snapshot to fast sync from, required with -fast-sync", &cfg.Node.Sync.TrustedSnapshot)
+		prune := fs.Int("prune", 0, "number of recent block bodies to keep, at least the snapshot interval, 0 keeps them all")
+		flags["prune"] = func() { cfg.Node.Prune = *prune }
+		maxSyncLag := fs.Int("max-sync-lag", 0, "blocks the node may be behind its best peer and be ready")
+		flags["max-sync-lag"] = func() { cfg.Node.MaxSyncLag = *maxSyncLag }
+		origins := fs.String("allowed-origins", "", "comma separated origins of the web pages that may open a websocket, e.g. https://explorer.example.com")
+		flags["allowed-origins"] = func() { cfg.Node.AllowedOrigins = splitList(*origins) }
+	case Wallet:
+		str("listen", "address the wallet server listens on, e.g. :8080", &cfg.Wallet.Listen)
+		str("grpc-listen", "address of the gRPC API, e.g. :18080", &cfg.Wallet.GRPCListen)
+		str("gateway", "URL of the blockchain node, e.g. http://localhost:5001", &cfg.Wallet.Gateway)
+	}
+	return flags
+}
+
+func (cfg *Config) loadEnv(component Component) error {
+	str := func(name string, dst *string) {
+		if v, ok := os.LookupEnv(name); ok {
+			*dst = v
+		}
+	}
+	var problems []string
+	boolean := func(name string, dst *bool) {
+		if v, ok := os.LookupEnv(name); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not a boolean", name, v))
+				return
+			}
+			*dst = b
+		}
+	}
+
+	str("BLOCKCHAIN_NETWORK", &cfg.Network)
+	str("BLOCKCHAIN_GENESIS", &cfg.Genesis)
+	str("BLOCKCHAIN_DATA_DIR", &cfg.DataDir)
+	str("BLOCKCHAIN_LOG_LEVEL", &cfg.LogLevel)
+	if v, ok := os.LookupEnv("BLOCKCHAIN_LOG_LEVELS"); ok {
+		cfg.LogLevels = splitLevels(v)
+	}
+	str("BLOCKCHAIN_LOG_FORMAT", &cfg.LogFormat)
+
+	listen := &cfg.Node.Listen
+	if component == Wallet {
+		listen = &cfg.Wallet.Listen
+	}
+	// port is what the servers used to read, keep honouring it.
+	if port, ok := os.LookupEnv("port"); ok {
+		*listen = ":" + port
+	}
+
+	switch component {
+	case Node:
+		str("BLOCKCHAIN_LISTEN", &cfg.Node.Listen)
+		str("BLOCKCHAIN_GRPC_LISTEN", &cfg.Node.GRPCListen)
+		if v, ok := os.LookupEnv("BLOCKCHAIN_PEERS"); ok {
+			cfg.Node.Peers = splitList(v)
+		}
+		boolean("BLOCKCHAIN_MINING", &cfg.Node.Mining.Enabled)
+		str("BLOCKCHAIN_MINING_ADDRESS", &cfg.Node.Mining.Address)
+		boolean("BLOCKCHAIN_POOL", &cfg.Node.Mining.Pool)
+		if v, ok := os.LookupEnv("BLOCKCHAIN_SHARE_DIFFICULTY"); ok {
+			d, err := strconv.Atoi(v)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("BLOCKCHAIN_SHARE_DIFFICULTY: %q is not a number", v))
+			} else {
+				cfg.Node.Mining.ShareDifficulty = d
+			}
+		}
+		str("BLOCKCHAIN_API_TOKEN", &cfg.Node.Auth.Token)
+		boolean("BLOCKCHAIN_FAST_SYNC", &cfg.Node.Sync.FastSync)
+		str("BLOCKCHAIN_TRUSTED_SNAPSHOT", &cfg.Node.Sync.TrustedSnapshot)
+		if v, ok := os.LookupEnv("BLOCKCHAIN_PRUNE"); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("BLOCKCHAIN_PRUNE: %q is not a number", v))
+			} else {
+				cfg.Node.Prune = n
+			}
+		}
+		if v, ok := os.LookupEnv("BLOCKCHAIN_MAX_SYNC_LAG"); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("BLOCKCHAIN_MAX_SYNC_LAG: %q is not a number", v))
+			} else {
+				cfg.Node.MaxSyncLag = n
+			}
+		}
+		if v, ok := os.LookupEnv("BLOCKCHAIN_ALLOWED_ORIGINS"); ok {
+			cfg.Node.AllowedOrigins = splitList(v)
+		}
+	case Wallet:
+		str("WALLET_LISTEN", &cfg.Wallet.Listen)
+		str("WALLET_GRPC_LISTEN", &cfg.Wallet.GRPCListen)
+		str("WALLET_GATEWAY", &cfg.Wallet.Gateway)
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// Validate checks the settings used by component and reports all the
+// problems it finds.
+func (cfg *Config) Validate(component Component) error {
+	var problems []string
+	add := func(format string, a ...any) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	params, err := cfg.Params()
+	if err != nil {
+		add("network: %v, expected mainnet, testnet or regtest with an optional genesis file", err)
+	}
+	if cfg.DataDir == "" {
+		add("data_dir: must not be empty")
+	} else if info, err := os.Stat(cfg.DataDir); err == nil && !info.IsDir() {
+		add("data_dir: %s is not a directory", cfg.DataDir)
+	}
+	if _, err := ParseLogLevel(cfg.LogLevel); err != nil {
+		add("log_level: %v", err)
+	}
+	for subsystem, level := range cfg.LogLevels {
+		if !slices.Contains(logging.SUBSYSTEMS, subsystem) {
+			add("log_levels: unknown subsystem %q, expected one of %s", subsystem, strings.Join(logging.SUBSYSTEMS, ", "))
+		} else if _, err := ParseLogLevel(level); err != nil {
+			add("log_levels.%s: %v", subsystem, err)
+		}
+	}
+	if f := cfg.LogFormat; f != logging.FORMAT_TEXT && f != logging.FORMAT_JSON {
+		add("log_format: %q is not text or json", f)
+	}
+
+	switch component {
+	case Node:
+		if cfg.Node.Listen != "" {
+			if err := validateListenAddress(cfg.Node.Listen); err != nil {
+				add("node.listen: %v", err)
+			}
+		}
+		if cfg.Node.GRPCListen != "" {
+			if err := validateListenAddress(cfg.Node.GRPCListen); err != nil {
+				add("node.grpc_listen: %v", err)
+			}
+		}
+		for _, peer := range cfg.Node.Peers {
+			if err := validateAddress(peer); err != nil {
+				add("node.peers: %v", err)
+			}
+		}
+		if d := cfg.Node.Mining.ShareDifficulty; d < 0 {
+			add("node.mining.share_difficulty: must not be negative, got %d", d)
+		} else if params != nil && d > params.MiningDifficulty {
+			add("node.mining.share_difficulty: must not exceed the %s mining difficulty %d, got %d",
+				params.Name, params.MiningDifficulty, d)
+		}
+		if t := cfg.Node.Auth.Token; t != "" && len(t) < MIN_API_TOKEN_LENGTH {
+			add("node.auth.token: must be at least %d characters long", MIN_API_TOKEN_LENGTH)
+		}
+		if n := cfg.Node.Prune; n != 0 && n < block.MIN_PRUNE_BLOCKS {
+			add("node.prune: must be 0 or at least %d blocks, got %d", block.MIN_PRUNE_BLOCKS, n)
+		} else if params != nil && n != 0 && n < params.SnapshotInterval {
+			// Bodies are dropped a snapshot interval at a time: with fewer,
+			// the node would hold up to twice the interval anyway.
+			add("node.prune: must be 0 or at least the %s snapshot interval %d, got %d",
+				params.Name, params.SnapshotInterval, n)
+		}
+		if n := cfg.Node.MaxSyncLag; n < 0 {
+			add("node.max_sync_lag: must not be negative, got %d", n)
+		}
+		for _, origin := range cfg.Node.AllowedOrigins {
+			if origin == "*" {
+				continue
+			}
+			u, err := url.Parse(origin)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+				add("node.allowed_origins: %q is not * or an http(s) origin like https://example.com", origin)
+			}
+		}
+		if t := cfg.Node.Sync.TrustedSnapshot; t != "" {
+			if _, err := utils.HashFromString(t); err != nil {
+				add("node.sync.trusted_snapshot: must be a 64 character hex commitment")
+			}
+		} else if cfg.Node.Sync.FastSync {
+			add("node.sync.trusted_snapshot: required with node.sync.fast_sync")
+		}
+		validateLimits("node.limits", cfg.Node.Limits, add)
+	case Wallet:
+		if err := validateListenAddress(cfg.Wallet.Listen); err != nil {
+			add("wallet.listen: %v", err)
+		}
+		if cfg.Wallet.GRPCListen != "" {
+			if err := validateListenAddress(cfg.Wallet.GRPCListen); err != nil {
+				add("wallet.grpc_listen: %v", err)
+			}
+		}
+		if cfg.Wallet.Gateway != "" {
+			u, err := url.Parse(cfg.Wallet.Gateway)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				add("wallet.gateway: %q is not an http(s) URL", cfg.Wallet.Gateway)
+			}
+		}
+		validateLimits("wallet.limits", cfg.Wallet.Limits, add)
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// Params returns the network parameters selected by Network and Genesis.
+// When a genesis file is given its network is used.
+func (cfg *Config) Params() (*block.NetworkParams, error) {
+	return block.LoadNetworkParams(cfg.Network, cfg.Genesis)
+}
+
+// setDefaults fills the settings whose default depends on the network.
+func (cfg *Config) setDefaults() {
+	params, err := cfg.Params()
+	if err != nil {
+		return
+	}
+	if cfg.Node.Listen == "" {
+		cfg.Node.Listen = fmt.Sprintf(":%d", params.PortRangeStart)
+	}
+	if cfg.Wallet.Gateway == "" {
+		cfg.Wallet.Gateway = fmt.Sprintf("http://localhost:%d", params.PortRangeStart)
+	}
+	if cfg.Node.GRPCListen == "" {
+		cfg.Node.GRPCListen = grpcAddress(cfg.Node.Listen)
+	}
+	if cfg.Wallet.GRPCListen == "" {
+		cfg.Wallet.GRPCListen = grpcAddress(cfg.Wallet.Listen)
+	}
+}
+
+// grpcAddress derives the default gRPC address from an HTTP listen address.
+// Port 0 stays 0, so that servers on ephemeral ports don't share one.
+func grpcAddress(listen string) string {
+	host, _, _ := net.SplitHostPort(listen)
+	port := Port(listen)
+	if port != 0 {
+		port += GRPC_PORT_OFFSET
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// Port returns the port of a listen address that went through Validate.
+func Port(address string) int {
+	_, p, _ := net.SplitHostPort(address)
+	port, _ := strconv.Atoi(p)
+	return port
+}
+
+func ParseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("%q is not one of debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+// Logging returns the options of the loggers for a configuration that went
+// through Validate.
+func (cfg *Config) Logging() logging.Options {
+	opts := logging.Options{Format: cfg.LogFormat, Levels: make(map[string]slog.Level)}
+	opts.Level, _ = ParseLogLevel(cfg.LogLevel)
+	for subsystem, level := range cfg.LogLevels {
+		opts.Levels[subsystem], _ = ParseLogLevel(level)
+	}
+	return opts
+}
+
+func validateLimits(key string, limits map[string]ratelimit.Rule, add func(format string, a ...any)) {
+	for route, rule := range limits {
+		if err := rule.Validate(); err != nil {
+			for _, problem := range strings.Split(err.Error(), "\n") {
+				add("%s.%s.%s", key, route, problem)
+			}
+		}
+	}
+}
+
+func validateAddress(address string) error {
+	return checkAddress(address, 1)
+}
+
+// validateListenAddress also accepts port 0, which lets the system pick a
+// free port.
+func validateListenAddress(address string) error {
+	return checkAddress(address, 0)
+}
+
+func checkAddress(address string, minPort int) error {
+	_, p, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%q is not a host:port address", address)
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil || port < minPort || port > 65535 {
+		return fmt.Errorf("%q has an invalid port", address)
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// splitLevels parses subsystem=level pairs separated by commas. The levels
+// are checked by Validate.
+func splitLevels(s string) map[string]string {
+	levels := make(map[string]string)
+	for _, item := range splitList(s) {
+		subsystem, level, _ := strings.Cut(item, "=")
+		levels[strings.TrimSpace(subsystem)] = strings.TrimSpace(level)
+	}
+	return levels
+}
+
+func componentName(component Component) string {
+	if component == Wallet {
+		return "wallet_server"
+	}
+	return "blockchain_server"
+}
+
+// IsHelp reports whether err comes from -h or -help.
+func IsHelp(err error) bool {
+	return errors.Is(err, flag.ErrHelp)
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// unsetEnv clears variables of the test environment that Load reads. They
+// are restored when the test ends.
+func unsetEnv(t *testing.T, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		component Component
+		change    func(cfg *Config)
+		problems  []string
+	}{
+		"default":   {Node, func(cfg *Config) {}, nil},
+		"ephemeral": {Node, func(cfg *Config) { cfg.Node.Listen = ":0" }, nil},
+		"network":   {Node, func(cfg *Config) { cfg.Network = "moonnet" }, []string{"network:"}},
+		"data dir":  {Node, func(cfg *Config) { cfg.DataDir = file }, []string{"data_dir:"}},
+		"node": {Node, func(cfg *Config) {
+			cfg.LogLevel = "loud"
+			cfg.Node.Listen = "5001"
+			cfg.Node.Peers = []string{"127.0.0.1:0"}
+			cfg.Node.Prune = 5
+			cfg.Node.MaxSyncLag = -1
+			cfg.Node.AllowedOrigins = []string{"*", "https://example.com/", "example.com", "https://example.com/page"}
+			cfg.Node.Sync.FastSync = true
+		}, []string{
+			"log_level:",
+			"node.listen:",
+			"node.peers:",
+			"node.prune:",
+			"node.max_sync_lag:",
+			`node.allowed_origins: "example.com"`,
+			`node.allowed_origins: "https://example.com/page"`,
+			"node.sync.trusted_snapshot:",
+		}},
+		"prune below the snapshot interval": {Node, func(cfg *Config) {
+			cfg.Network = "testnet"
+			cfg.Node.Prune = 50
+		}, []string{"node.prune: must be 0 or at least the testnet snapshot interval"}},
+		"wallet": {Wallet, func(cfg *Config) {
+			cfg.Wallet.Listen = ":99999"
+			cfg.Wallet.Gateway = "localhost:5001"
+		}, []string{"wallet.listen:", "wallet.gateway:"}},
+	}
+	for name, test := range tests {
+		cfg := Default()
+		cfg.DataDir = t.TempDir()
+		test.change(cfg)
+		err := cfg.Validate(test.component)
+		if test.problems == nil {
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+			continue
+		}
+		var invalid *ValidationError
+		if !errors.As(err, &invalid) {
+			t.Errorf("%s: got %v, want a ValidationError", name, err)
+			continue
+		}
+		if len(invalid.Problems) != len(test.problems) {
+			t.Errorf("%s: %d problems, want %d: %v", name, len(invalid.Problems), len(test.problems), err)
+			continue
+		}
+		for i, problem := range test.problems {
+			if !strings.HasPrefix(invalid.Problems[i], problem) {
+				t.Errorf("%s: problem %q, want %s...", name, invalid.Problems[i], problem)
+			}
+		}
+	}
+}
+
+// TestLoadPrecedence checks that the environment overrides the file and the
+// flags override both.
+func TestLoadPrecedence(t *testing.T) {
+	unsetEnv(t, "BLOCKCHAIN_CONFIG", "BLOCKCHAIN_LOG_LEVEL", "port")
+	dataDir := t.TempDir()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	file := "network: regtest\n" +
+		"data_dir: " + dataDir + "\n" +
+		"log_level: debug\n" +
+		"node:\n" +
+		"  listen: \":7100\"\n" +
+		"  max_sync_lag: 5\n" +
+		"  peers: [\"127.0.0.1:7101\"]\n"
+	if err := os.WriteFile(path, []byte(file), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BLOCKCHAIN_LISTEN", ":7200")
+	t.Setenv("BLOCKCHAIN_MAX_SYNC_LAG", "6")
+	t.Setenv("BLOCKCHAIN_PEERS", "")
+
+	cfg, err := Load(Node, []string{"-config", path, "-listen", ":7300"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Network != "regtest" || cfg.DataDir != dataDir || cfg.LogLevel != "debug" {
+		t.Errorf("file settings: network %q, data dir %q, log level %q", cfg.Network, cfg.DataDir, cfg.LogLevel)
+	}
+	if cfg.Node.MaxSyncLag != 6 || len(cfg.Node.Peers) != 0 {
+		t.Errorf("environment settings: max sync lag %d, peers %v, want 6 and none", cfg.Node.MaxSyncLag, cfg.Node.Peers)
+	}
+	if cfg.Node.Listen != ":7300" || cfg.Node.GRPCListen != ":17300" {
+		t.Errorf("listen %q and gRPC %q, want :7300 and :17300", cfg.Node.Listen, cfg.Node.GRPCListen)
+	}
+
+	// A flag left at its default doesn't override the environment.
+	cfg, err = Load(Node, []string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Node.Listen != ":7200" {
+		t.Errorf("listen %q, want :7200 from the environment", cfg.Node.Listen)
+	}
+}
+
+func TestGRPCAddress(t *testing.T) {
+	tests := map[string]string{
+		":5001":          ":15001",
+		"127.0.0.1:8080": "127.0.0.1:18080",
+		":0":             ":0",
+		"localhost:0":    "localhost:0",
+	}
+	for listen, want := range tests {
+		if got := grpcAddress(listen); got != want {
+			t.Errorf("grpcAddress(%q) = %q, want %q", listen, got, want)
+		}
+	}
+}
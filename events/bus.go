@@ -0,0 +1,227 @@
+package events
+
+import (
+	"sync"
+
+	"github.com/Nico2220/blockchain/clock"
+)
+
+type Type string
+
+// NEW_BLOCK is published when a block is connected to the tip and
+// NEW_PENDING_TX when a transaction is accepted in the transaction pool.
+const (
+	NEW_BLOCK          Type = "newBlock"
+	BLOCK_DISCONNECTED Type = "blockDisconnected"
+	NEW_PENDING_TX     Type = "newPendingTx"
+	TX_EVICTED         Type = "txEvicted"
+	PAYMENT_RECEIVED   Type = "paymentReceived"
+	REORG              Type = "reorg"
+)
+
+var Types = []Type{NEW_BLOCK, BLOCK_DISCONNECTED, NEW_PENDING_TX, TX_EVICTED, PAYMENT_RECEIVED, REORG}
+
+func (t Type) Valid() bool {
+	for _, v := range Types {
+		if t == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is what the node publishes on the bus. Addresses lists every address
+// the event concerns so that subscribers can filter on them, Data holds the
+// block, transaction or reorg the event is about.
+type Event struct {
+	Type      Type     `json:"type"`
+	Height    int      `json:"height"`
+	Hash      string   `json:"hash,omitempty"`
+	Addresses []string `json:"addresses,omitempty"`
+	Data      any      `json:"data,omitempty"`
+	Time      int64    `json:"time"`
+}
+
+// Payment is the Data of a PAYMENT_RECEIVED event.
+type Payment struct {
+	TransactionID string  `json:"transaction_id"`
+	From          string  `json:"from"`
+	To            string  `json:"to"`
+	Amount        float32 `json:"amount"`
+	Height        int     `json:"height"`
+	BlockHash     string  `json:"block_hash"`
+}
+
+// Reorg is the Data of a REORG event.
+type Reorg struct {
+	ForkHeight int    `json:"fork_height"`
+	OldTip     string `json:"old_tip"`
+	NewTip     string `json:"new_tip"`
+	Depth      int    `json:"depth"`
+}
+
+// Subscription receives every event published after it was created.
+type Subscription struct {
+	id  int
+	bus *Bus
+	c   chan Event
+
+	// The events of a subscription made by SubscribeAll wait in backlog
+	// until pump moves them to c, so that Publish never waits for the
+	// subscriber.
+	all     bool
+	backlog []Event
+	closed  bool
+	ready   *sync.Cond
+
+	mu      sync.Mutex
+	dropped int
+}
+
+func (s *Subscription) C() <-chan Event {
+	return s.c
+}
+
+// Dropped returns how many events were lost because the subscriber was too
+// slow to read them.
+func (s *Subscription) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+func (s *Subscription) Unsubscribe() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	if _, ok := s.bus.subscriptions[s.id]; ok {
+		delete(s.bus.subscriptions, s.id)
+		s.close()
+	}
+}
+
+// close closes c, once the backlog is delivered for a subscription made by
+// SubscribeAll.
+func (s *Subscription) close() {
+	if !s.all {
+		close(s.c)
+		return
+	}
+	s.mu.Lock()
+	s.closed = true
+	s.ready.Signal()
+	s.mu.Unlock()
+}
+
+// pump sends the backlog to c in order and closes c once the subscription
+// is closed and the backlog is empty.
+func (s *Subscription) pump() {
+	for {
+		s.mu.Lock()
+		for len(s.backlog) == 0 && !s.closed {
+			s.ready.Wait()
+		}
+		if len(s.backlog) == 0 {
+			s.mu.Unlock()
+			close(s.c)
+			return
+		}
+		e := s.backlog[0]
+		s.backlog[0] = Event{}
+		s.backlog = s.backlog[1:]
+		s.mu.Unlock()
+
+		s.c <- e
+	}
+}
+
+// Bus fans events out to subscribers. Publishing never blocks: the
+// subscriptions made by SubscribeAll keep the events their subscriber has not
+// read yet, any other subscriber whose buffer is full misses the event.
+type Bus struct {
+	mu            sync.Mutex
+	clock         clock.Clock
+	subscriptions map[int]*Subscription
+	nextID        int
+	closed        bool
+}
+
+func NewBus() *Bus {
+	return &Bus{clock: clock.Real, subscriptions: make(map[int]*Subscription)}
+}
+
+// SetClock makes the bus date the events published without a time from c.
+func (b *Bus) SetClock(c clock.Clock) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clock = c
+}
+
+func (b *Bus) Subscribe(buffer int) *Subscription {
+	return b.subscribe(buffer, false)
+}
+
+// SubscribeAll returns a subscription that misses no event: when its buffer
+// is full, the events wait in memory for the subscriber. The subscriber must
+// keep reading until the channel is closed, which happens after the events
+// published before Unsubscribe or Close are read.
+func (b *Bus) SubscribeAll(buffer int) *Subscription {
+	return b.subscribe(buffer, true)
+}
+
+func (b *Bus) subscribe(buffer int, all bool) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	s := &Subscription{id: b.nextID, bus: b, c: make(chan Event, buffer), all: all}
+	if all {
+		s.ready = sync.NewCond(&s.mu)
+		go s.pump()
+	}
+	if b.closed {
+		s.close()
+		return s
+	}
+	b.subscriptions[s.id] = s
+	return s
+}
+
+// Close closes the channel of every subscription. Events published later
+// are dropped and new subscriptions start closed.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for id, s := range b.subscriptions {
+		delete(b.subscriptions, id)
+		s.close()
+	}
+}
+
+func (b *Bus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if e.Time == 0 {
+		e.Time = b.clock.Now().UnixNano()
+	}
+
+	for _, s := range b.subscriptions {
+		if s.all {
+			s.mu.Lock()
+			s.backlog = append(s.backlog, e)
+			s.ready.Signal()
+			s.mu.Unlock()
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			s.mu.Lock()
+			s.dropped++
+			s.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Nico2220/blockchain/clock"
+)
+
+func TestSubscribeAllNeverBlocks(t *testing.T) {
+	bus := NewBus()
+	all := bus.SubscribeAll(1)
+	some := bus.Subscribe(1)
+
+	// Nobody reads: Publish must still return.
+	for i := 0; i < 100; i++ {
+		bus.Publish(Event{Type: NEW_BLOCK, Height: i, Time: 1})
+	}
+	if n := some.Dropped(); n != 99 {
+		t.Errorf("the bounded subscription dropped %d events, want 99", n)
+	}
+
+	all.Unsubscribe()
+	height := 0
+	for e := range all.C() {
+		if e.Height != height {
+			t.Fatalf("got height %d, want %d", e.Height, height)
+		}
+		height++
+	}
+	if height != 100 {
+		t.Fatalf("got %d events before the channel closed, want 100", height)
+	}
+}
+
+func TestPublishDatesWithClock(t *testing.T) {
+	bus := NewBus()
+	now := time.Unix(1735689600, 0)
+	bus.SetClock(clock.NewFake(now))
+	s := bus.Subscribe(2)
+
+	bus.Publish(Event{Type: NEW_BLOCK})
+	bus.Publish(Event{Type: NEW_BLOCK, Time: 1})
+	if e := <-s.C(); e.Time != now.UnixNano() {
+		t.Errorf("event dated %d, want the clock's %d", e.Time, now.UnixNano())
+	}
+	if e := <-s.C(); e.Time != 1 {
+		t.Errorf("event time %d replaced", e.Time)
+	}
+}
@@ -3,6 +3,21 @@ module github.com/Nico2220/blockchain
 go 1.22.2
 
 require (
-	github.com/btcsuite/btcutil v1.0.2 // indirect
-	golang.org/x/crypto v0.32.0 // indirect
+	github.com/btcsuite/btcutil v1.0.2
+	golang.org/x/crypto v0.32.0
+)
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require (
+	github.com/gorilla/websocket v1.5.3
+	google.golang.org/grpc v1.66.2
+	google.golang.org/protobuf v1.34.2
+)
+
+require (
+	golang.org/x/net v0.26.0 // indirect
+	golang.org/x/sys v0.29.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240604185151-ef581f913117 // indirect
 )
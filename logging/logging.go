@@ -0,0 +1,154 @@
+// Package logging provides the structured loggers of the node and wallet
+// servers. Every subsystem logs through its own slog.Logger, whose level can
+// be set apart from the others, and records carry the ID of the HTTP request
+// they were logged for.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// Subsystems.
+const (
+	CONSENSUS = "consensus"
+	P2P       = "p2p"
+	MEMPOOL   = "mempool"
+	MINER     = "miner"
+	API       = "api"
+	STORE     = "store"
+	POOL      = "pool"
+	WEBHOOK   = "webhook"
+)
+
+var SUBSYSTEMS = []string{CONSENSUS, P2P, MEMPOOL, MINER, API, STORE, POOL, WEBHOOK}
+
+// Output formats.
+const (
+	FORMAT_TEXT = "text"
+	FORMAT_JSON = "json"
+)
+
+// Options configures the loggers. Levels overrides Level for the subsystems
+// it lists.
+type Options struct {
+	Level  slog.Level
+	Levels map[string]slog.Level
+	Format string
+	Output io.Writer
+}
+
+// state is what Setup installs. Loggers are created at package
+// initialization, before the configuration is read, so they look it up at
+// every record rather than when they are created.
+type state struct {
+	root   slog.Handler
+	level  slog.Level
+	levels map[string]slog.Level
+}
+
+var current atomic.Pointer[state]
+
+func init() {
+	Setup(Options{Level: slog.LevelInfo})
+}
+
+// Setup replaces the configuration of every logger. The standard log
+// package and slog.Default are redirected to the same output.
+func Setup(opts Options) error {
+	if opts.Output == nil {
+		opts.Output = os.Stderr
+	}
+	for subsystem := range opts.Levels {
+		if !slices.Contains(SUBSYSTEMS, subsystem) {
+			return fmt.Errorf("unknown subsystem %q, expected one of %s", subsystem, strings.Join(SUBSYSTEMS, ", "))
+		}
+	}
+
+	// The root handler lets everything through, the levels are checked by
+	// the subsystem handlers.
+	handlerOpts := &slog.HandlerOptions{Level: slog.Level(-1 << 10)}
+	var root slog.Handler
+	switch opts.Format {
+	case "", FORMAT_TEXT:
+		root = slog.NewTextHandler(opts.Output, handlerOpts)
+	case FORMAT_JSON:
+		root = slog.NewJSONHandler(opts.Output, handlerOpts)
+	default:
+		return fmt.Errorf("unknown log format %q, expected text or json", opts.Format)
+	}
+
+	current.Store(&state{root: root, level: opts.Level, levels: opts.Levels})
+	slog.SetDefault(slog.New(&handler{}))
+	return nil
+}
+
+// New returns the logger of subsystem.
+func New(subsystem string) *slog.Logger {
+	return slog.New(&handler{subsystem: subsystem})
+}
+
+// handler adds the subsystem and the request ID to the records and passes
+// them to the root handler installed by Setup. The attributes and groups
+// added by With are replayed on the root handler the first time it's used.
+type handler struct {
+	subsystem string
+	with      []func(slog.Handler) slog.Handler
+	cache     atomic.Pointer[derived]
+}
+
+type derived struct {
+	state   *state
+	handler slog.Handler
+}
+
+func (h *handler) level(s *state) slog.Level {
+	if level, ok := s.levels[h.subsystem]; ok {
+		return level
+	}
+	return s.level
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level(current.Load())
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	s := current.Load()
+	d := h.cache.Load()
+	if d == nil || d.state != s {
+		root := s.root
+		if h.subsystem != "" {
+			root = root.WithAttrs([]slog.Attr{slog.String("subsystem", h.subsystem)})
+		}
+		for _, with := range h.with {
+			root = with(root)
+		}
+		d = &derived{state: s, handler: root}
+		h.cache.Store(d)
+	}
+
+	if id := RequestID(ctx); id != "" {
+		r = r.Clone()
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return d.handler.Handle(ctx, r)
+}
+
+func (h *handler) extend(with func(slog.Handler) slog.Handler) *handler {
+	return &handler{subsystem: h.subsystem, with: append(slices.Clip(h.with), with)}
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.extend(func(root slog.Handler) slog.Handler { return root.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.extend(func(root slog.Handler) slog.Handler { return root.WithGroup(name) })
+}
@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSubsystemLevels(t *testing.T) {
+	var out bytes.Buffer
+	logger := New(P2P).With("neighbor", "127.0.0.1:5002")
+	if err := Setup(Options{Level: slog.LevelWarn, Levels: map[string]slog.Level{P2P: slog.LevelDebug}, Format: FORMAT_JSON, Output: &out}); err != nil {
+		t.Fatal(err)
+	}
+	defer Setup(Options{Level: slog.LevelInfo})
+
+	New(MINER).Info("hidden")
+	logger.DebugContext(WithRequestID(context.Background(), "abc"), "shown")
+
+	var record map[string]any
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("%v: %s", err, out.String())
+	}
+	for key, want := range map[string]string{"msg": "shown", "subsystem": P2P, "neighbor": "127.0.0.1:5002", "request_id": "abc"} {
+		if record[key] != want {
+			t.Errorf("%s = %v, want %s", key, record[key], want)
+		}
+	}
+
+	if Setup(Options{Levels: map[string]slog.Level{"disk": slog.LevelDebug}}) == nil {
+		t.Error("unknown subsystem accepted")
+	}
+}
+
+func TestRecover(t *testing.T) {
+	var out bytes.Buffer
+	Setup(Options{Format: FORMAT_JSON, Output: &out})
+	defer Setup(Options{Level: slog.LevelInfo})
+
+	handler := RequestIDs(Recover(New(API), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+	req := httptest.NewRequest(http.MethodGet, "/chain", nil)
+	req.Header.Set(REQUEST_ID_HEADER, "req-1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get(REQUEST_ID_HEADER) != "req-1" {
+		t.Fatalf("status %d, request ID %q", rec.Code, rec.Header().Get(REQUEST_ID_HEADER))
+	}
+	if !bytes.Contains(out.Bytes(), []byte(`"request_id":"req-1"`)) {
+		t.Fatalf("panic not logged with the request ID: %s", out.String())
+	}
+}
@@ -0,0 +1,69 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// REQUEST_ID_HEADER carries the ID of a request. An ID sent by the client is
+// kept, so that a request can be followed from the wallet server to the node.
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+const MAX_REQUEST_ID_LENGTH = 64
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, if any.
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a random 16 character hex ID.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RequestIDs gives every request an ID, echoed in the response headers and
+// added to the records logged with its context.
+func RequestIDs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(REQUEST_ID_HEADER)
+		if !validRequestID(id) {
+			id = NewRequestID()
+		}
+		w.Header().Set(REQUEST_ID_HEADER, id)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
+
+// SetRequestID forwards the request ID of ctx on an outgoing request.
+func SetRequestID(req *http.Request) {
+	if id := RequestID(req.Context()); id != "" {
+		req.Header.Set(REQUEST_ID_HEADER, id)
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > MAX_REQUEST_ID_LENGTH {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServeMux is an http.ServeMux that times every handler registered on it.
+// Requests are labelled with the route they were registered under rather
+// than their path, so that /blocks/{height} stays a single series.
+type ServeMux struct {
+	*http.ServeMux
+	duration *Histogram
+}
+
+func NewServeMux(r *Registry) *ServeMux {
+	return &ServeMux{
+		ServeMux: http.NewServeMux(),
+		duration: r.NewHistogram("http_request_duration_seconds", "Time spent handling HTTP requests.",
+			DEFAULT_BUCKETS, "route", "method", "code"),
+	}
+}
+
+func (mux *ServeMux) Handle(pattern string, handler http.Handler) {
+	route := pattern
+	if i := strings.IndexByte(pattern, ' '); i >= 0 {
+		route = strings.TrimSpace(pattern[i+1:])
+	}
+	mux.ServeMux.Handle(pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(rec, r)
+		mux.duration.Since(start, route, r.Method, strconv.Itoa(rec.status))
+	}))
+}
+
+func (mux *ServeMux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	mux.Handle(pattern, http.HandlerFunc(handler))
+}
+
+// statusRecorder remembers the status code written by a handler. It passes
+// Hijack and Flush through so that websockets keep working behind it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status, rec.wroteHeader = status, true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("metrics: response writer can't be hijacked")
+	}
+	rec.status, rec.wroteHeader = http.StatusSwitchingProtocols, true
+	return h.Hijack()
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,286 @@
+// Package metrics exposes counters, gauges and histograms in the Prometheus
+// text format. Metrics are created on a Registry, optionally with label
+// names, and updated with the label values in the same order.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CONTENT_TYPE is the media type of the text exposition format.
+const CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// DEFAULT_BUCKETS suit durations in seconds, from 5ms to 10s.
+var DEFAULT_BUCKETS = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+// Registry holds metrics and the registries included in it.
+type Registry struct {
+	mu       sync.Mutex
+	metrics  []metric
+	included []*Registry
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) add(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// Include exposes the metrics of other along with those of r.
+func (r *Registry) Include(other *Registry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.included = append(r.included, other)
+}
+
+// WriteText writes every metric in the text format.
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.Lock()
+	metrics := append([]metric{}, r.metrics...)
+	included := append([]*Registry{}, r.included...)
+	r.mu.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+	for _, other := range included {
+		other.WriteText(w)
+	}
+}
+
+// Handler serves the metrics of r, for GET /metrics.
+func (r *Registry) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", CONTENT_TYPE)
+		r.WriteText(w)
+	}
+}
+
+// desc is what every metric has: a name, a help text and label names.
+// Series are keyed by their label values joined with a zero byte.
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d *desc) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.kind)
+}
+
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\x00")
+}
+
+// series formats name{labels} from a key, with extra appended to the labels.
+func (d *desc) series(name, key string, extra ...string) string {
+	var pairs []string
+	if len(d.labels) > 0 {
+		for i, v := range strings.Split(key, "\x00") {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", d.labels[i], v))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", extra[i], extra[i+1]))
+	}
+	if len(pairs) == 0 {
+		return name
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter only goes up. A nil Counter ignores updates, so that code can be
+// instrumented before a registry exists.
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{name, help, "counter", labels}, values: make(map[string]float64)}
+	r.add(c)
+	return c
+}
+
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+func (c *Counter) Add(v float64, labels ...string) {
+	if c == nil || v < 0 {
+		return
+	}
+	key := c.key(labels)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.header(w)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+	}
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s %s\n", c.series(c.name, key), formatFloat(c.values[key]))
+	}
+}
+
+// Gauge goes up and down. A nil Gauge ignores updates.
+type Gauge struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{desc: desc{name, help, "gauge", labels}, values: make(map[string]float64)}
+	r.add(g)
+	return g
+}
+
+func (g *Gauge) Set(v float64, labels ...string) {
+	if g == nil {
+		return
+	}
+	key := g.key(labels)
+	g.mu.Lock()
+	g.values[key] = v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.header(w)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.labels) == 0 && len(g.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", g.name)
+	}
+	for _, key := range sortedKeys(g.values) {
+		fmt.Fprintf(w, "%s %s\n", g.series(g.name, key), formatFloat(g.values[key]))
+	}
+}
+
+type gaugeFunc struct {
+	desc
+	f func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is read from f at every scrape.
+func (r *Registry) NewGaugeFunc(name, help string, f func() float64) {
+	r.add(&gaugeFunc{desc: desc{name, help, "gauge", nil}, f: f})
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	g.header(w)
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.f()))
+}
+
+// Histogram counts observations in cumulative buckets. A nil Histogram
+// ignores observations.
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	data    map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{name, help, "histogram", labels},
+		buckets: append([]float64{}, buckets...),
+		data:    make(map[string]*histogramSeries),
+	}
+	sort.Float64s(h.buckets)
+	r.add(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, labels ...string) {
+	if h == nil {
+		return
+	}
+	key := h.key(labels)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.data[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.data[key] = s
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// Since observes the seconds elapsed since start.
+func (h *Histogram) Since(start time.Time, labels ...string) {
+	h.Observe(time.Since(start).Seconds(), labels...)
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.header(w)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.data) {
+		s := h.data[key]
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s %d\n", h.series(h.name+"_bucket", key, "le", formatFloat(upper)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s %d\n", h.series(h.name+"_bucket", key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s %s\n", h.series(h.name+"_sum", key), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s %d\n", h.series(h.name+"_count", key), s.count)
+	}
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTextFormat(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("blocks_total", "Blocks.", "source")
+	c.Inc("pool")
+	c.Add(2, "node")
+	h := r.NewHistogram("depth", "Depth.", []float64{1, 5})
+	h.Observe(3)
+	h.Observe(7)
+	other := NewRegistry()
+	other.NewGaugeFunc("height", "Height.", func() float64 { return 42 })
+	r.Include(other)
+
+	var b strings.Builder
+	r.WriteText(&b)
+	want := `# HELP blocks_total Blocks.
+# TYPE blocks_total counter
+blocks_total{source="node"} 2
+blocks_total{source="pool"} 1
+# HELP depth Depth.
+# TYPE depth histogram
+depth_bucket{le="1"} 0
+depth_bucket{le="5"} 1
+depth_bucket{le="+Inf"} 2
+depth_sum 10
+depth_count 2
+# HELP height Height.
+# TYPE height gauge
+height 42
+`
+	if b.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestServeMuxRoutes(t *testing.T) {
+	r := NewRegistry()
+	mux := NewServeMux(r)
+	mux.HandleFunc("GET /blocks/{height}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	for _, path := range []string{"/blocks/1", "/blocks/2"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	var b strings.Builder
+	r.WriteText(&b)
+	if !strings.Contains(b.String(), `http_request_duration_seconds_count{route="/blocks/{height}",method="GET",code="404"} 2`) {
+		t.Fatalf("requests not labelled with their route:\n%s", b.String())
+	}
+}
@@ -0,0 +1,460 @@
+package pool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/Nico2220/blockchain/block"
+	"github.com/Nico2220/blockchain/entropy"
+	"github.com/Nico2220/blockchain/logging"
+	"github.com/Nico2220/blockchain/wallet"
+)
+
+var poolLog = logging.New(logging.POOL)
+
+const (
+	SHARE_DIFFICULTY = 2
+	NONCE_RANGE      = 1 << 20
+	POOL_FEE         = 0.0
+	// MAX_JOBS is how many jobs on the current tip the pool remembers. The
+	// oldest ones are forgotten first.
+	MAX_JOBS = 1024
+)
+
+var (
+	ErrUnknownJob      = errors.New("unknown job")
+	ErrStaleShare      = errors.New("stale share: the chain tip has moved")
+	ErrDuplicateShare  = errors.New("duplicate share")
+	ErrNonceOutOfRange = errors.New("nonce is outside the range assigned to the job")
+	ErrLowDifficulty   = errors.New("share does not meet the share difficulty")
+)
+
+// Job is a unit of work handed to a single worker. Every job gets its own
+// nonce range so that workers mining the same template never submit the
+// same share.
+type Job struct {
+	ID                string
+	Worker            string
+	PreviousHash      [32]byte
+	Transactions      []*block.Transaction
+	ShareDifficulty   int
+	NetworkDifficulty int
+	NonceStart        int
+	NonceEnd          int
+
+	seen map[int]bool
+}
+
+// MarshalJSON also gives the hex encoded proof template of the job: a nonce
+// is a share when the sha256 of work_prefix, the nonce as a zigzag varint
+// and work_suffix meets the share difficulty.
+func (j *Job) MarshalJSON() ([]byte, error) {
+	prefix, suffix := block.ProofTemplate(j.PreviousHash, j.Transactions)
+	return json.Marshal(struct {
+		ID                string               `json:"job_id"`
+		Worker            string               `json:"worker_address"`
+		PreviousHash      string               `json:"previous_hash"`
+		Transactions      []*block.Transaction `json:"transactions"`
+		ShareDifficulty   int                  `json:"share_difficulty"`
+		NetworkDifficulty int                  `json:"network_difficulty"`
+		NonceStart        int                  `json:"nonce_start"`
+		NonceEnd          int                  `json:"nonce_end"`
+		WorkPrefix        string               `json:"work_prefix"`
+		WorkSuffix        string               `json:"work_suffix"`
+	}{
+		ID:                j.ID,
+		Worker:            j.Worker,
+		PreviousHash:      fmt.Sprintf("%x", j.PreviousHash),
+		Transactions:      j.Transactions,
+		ShareDifficulty:   j.ShareDifficulty,
+		NetworkDifficulty: j.NetworkDifficulty,
+		NonceStart:        j.NonceStart,
+		NonceEnd:          j.NonceEnd,
+		WorkPrefix:        fmt.Sprintf("%x", prefix),
+		WorkSuffix:        fmt.Sprintf("%x", suffix),
+	})
+}
+
+// ShareResult tells a worker what happened to a share it submitted.
+type ShareResult struct {
+	Accepted   bool   `json:"accepted"`
+	BlockFound bool   `json:"block_found"`
+	BlockHash  string `json:"block_hash,omitempty"`
+}
+
+type Payout struct {
+	Worker    string  `json:"worker_address"`
+	Amount    float32 `json:"amount"`
+	BlockHash string  `json:"block_hash"`
+}
+
+type WorkerStats struct {
+	RoundShares int     `json:"round_shares"`
+	TotalShares int     `json:"total_shares"`
+	Paid        float32 `json:"paid"`
+}
+
+type Stats struct {
+	PoolAddress     string                  `json:"pool_address"`
+	ShareDifficulty int                     `json:"share_difficulty"`
+	BlocksFound     int                     `json:"blocks_found"`
+	TotalRewards    float32                 `json:"total_rewards"`
+	ImmatureRewards float32                 `json:"immature_rewards"`
+	UnpaidRewards   float32                 `json:"unpaid_rewards"`
+	RoundShares     int                     `json:"round_shares"`
+	Workers         map[string]*WorkerStats `json:"workers"`
+	Payouts         []*Payout               `json:"payouts"`
+}
+
+// round is a block found by the pool together with the shares that found it.
+// It is paid once the block reward has matured. From then on unpaid holds
+// what is still owed to each worker.
+type round struct {
+	height    int
+	blockHash [32]byte
+	reward    float32
+	shares    map[string]int
+	unpaid    map[string]float32
+}
+
+// Pool hands out work at SHARE_DIFFICULTY, counts the shares of every worker
+// and, when a share also meets the network difficulty, submits the block and
+// pays the reward out proportionally to the shares of the round once the
+// block reward has matured. mu is never held while a block or a payout is
+// relayed to the neighbors, so that a slow neighbor doesn't hold up the
+// workers.
+type Pool struct {
+	blockchain      *block.Blockchain
+	wallet          *wallet.Wallet
+	shareDifficulty int
+	// entropy is what the signatures of the payouts are drawn from.
+	entropy io.Reader
+	// paying is held by the pass of payMatured that is running, so that a
+	// payout is never sent twice.
+	paying sync.Mutex
+
+	mu           sync.Mutex
+	jobs         map[string]*Job
+	nextJobID    int
+	oldestJobID  int
+	nextNonce    int
+	roundShares  map[string]int
+	workers      map[string]*WorkerStats
+	blocksFound  int
+	totalRewards float32
+	rounds       []*round
+	payouts      []*Payout
+}
+
+func NewPool(bc *block.Blockchain, w *wallet.Wallet, shareDifficulty int) *Pool {
+	// A share can never be harder than a block.
+	if shareDifficulty > bc.Params().MiningDifficulty {
+		shareDifficulty = bc.Params().MiningDifficulty
+	}
+
+	return &Pool{
+		blockchain:      bc,
+		wallet:          w,
+		shareDifficulty: shareDifficulty,
+		entropy:         entropy.Real,
+		jobs:            make(map[string]*Job),
+		roundShares:     make(map[string]int),
+		workers:         make(map[string]*WorkerStats),
+	}
+}
+
+// SetEntropy makes the pool sign its payouts with the random bytes of r.
+// Call it before the first round is paid.
+func (p *Pool) SetEntropy(r io.Reader) {
+	p.entropy = r
+}
+
+func (p *Pool) Address() string {
+	return p.wallet.BlockchainAddress()
+}
+
+// GetWork returns a new job for worker. The block reward of the job is paid
+// to the pool wallet. Past MAX_JOBS jobs on the tip, the oldest job is
+// forgotten and its shares are refused as ErrUnknownJob.
+func (p *Pool) GetWork(worker string) *Job {
+	previousHash, transactions := p.blockchain.MiningWork(p.Address())
+
+	p.mu.Lock()
+	p.dropStaleJobs(previousHash)
+
+	// Job IDs are given in order, so the oldest job left has the lowest.
+	for len(p.jobs) >= MAX_JOBS {
+		delete(p.jobs, strconv.Itoa(p.oldestJobID))
+		p.oldestJobID++
+	}
+
+	p.nextJobID++
+	job := &Job{
+		ID:                strconv.Itoa(p.nextJobID),
+		Worker:            worker,
+		PreviousHash:      previousHash,
+		Transactions:      transactions,
+		ShareDifficulty:   p.shareDifficulty,
+		NetworkDifficulty: p.blockchain.Params().MiningDifficulty,
+		NonceStart:        p.nextNonce,
+		NonceEnd:          p.nextNonce + NONCE_RANGE,
+		seen:              make(map[int]bool),
+	}
+	p.nextNonce += NONCE_RANGE
+	p.jobs[job.ID] = job
+	p.mu.Unlock()
+
+	p.payMatured()
+	return job
+}
+
+// SubmitShare records a share for worker. Shares that also meet the network
+// difficulty are submitted to the blockchain as a block.
+func (p *Pool) SubmitShare(worker, jobID string, nonce int) (*ShareResult, error) {
+	result, job, err := p.recordShare(worker, jobID, nonce)
+	if err != nil || !p.blockchain.ValidProof(nonce, job.PreviousHash, job.Transactions, job.NetworkDifficulty) {
+		return result, err
+	}
+
+	info, err := p.blockchain.SubmitWork(nonce, job.PreviousHash, job.Transactions)
+	if err != nil {
+		// The share itself was valid, only the block was refused.
+		poolLog.Warn("block refused", "err", err)
+		return result, nil
+	}
+
+	result.BlockFound = true
+	result.BlockHash = fmt.Sprintf("%x", info.Hash)
+	p.mu.Lock()
+	p.blocksFound++
+	r := &round{
+		height:    info.Height,
+		blockHash: info.Hash,
+		reward:    p.reward(job),
+		shares:    p.roundShares,
+	}
+	p.totalRewards += r.reward
+	p.rounds = append(p.rounds, r)
+	p.roundShares = make(map[string]int)
+	p.dropStaleJobs(info.Hash)
+	p.mu.Unlock()
+
+	p.payMatured()
+	return result, nil
+}
+
+// recordShare checks a share of worker and counts it in the current round.
+func (p *Pool) recordShare(worker, jobID string, nonce int) (*ShareResult, *Job, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	job, ok := p.jobs[jobID]
+	if !ok || job.Worker != worker {
+		return nil, nil, ErrUnknownJob
+	}
+	if job.PreviousHash != p.blockchain.LasBlock().Hash() {
+		return nil, nil, ErrStaleShare
+	}
+	if nonce < job.NonceStart || nonce >= job.NonceEnd {
+		return nil, nil, ErrNonceOutOfRange
+	}
+	if job.seen[nonce] {
+		return nil, nil, ErrDuplicateShare
+	}
+	if !p.blockchain.ValidProof(nonce, job.PreviousHash, job.Transactions, p.shareDifficulty) {
+		return nil, nil, ErrLowDifficulty
+	}
+
+	job.seen[nonce] = true
+	p.roundShares[worker]++
+	p.workerStats(worker).TotalShares++
+	return &ShareResult{Accepted: true}, job, nil
+}
+
+// reward returns what the coinbase of job pays to the pool.
+func (p *Pool) reward(job *Job) float32 {
+	var reward float32
+	for _, t := range job.Transactions {
+		if t.SenderBlockchainAddress() == p.blockchain.Params().MiningSender &&
+			t.RecipientBlockchainAddress() == p.Address() {
+			reward += t.Value()
+		}
+	}
+	return reward
+}
+
+// payMatured pays the rounds whose block reward has matured and retries the
+// payouts that failed before. The shares of a round whose block is no longer
+// in the chain go back to the current round. A pass that starts while
+// another one runs does nothing: the running one or the next pays.
+func (p *Pool) payMatured() {
+	if !p.paying.TryLock() {
+		return
+	}
+	defer p.paying.Unlock()
+
+	chain := p.blockchain.Chain()
+	p.mu.Lock()
+	var due []*round
+	pending := p.rounds[:0]
+	for _, r := range p.rounds {
+		switch {
+		case r.unpaid == nil && (r.height >= len(chain) || chain[r.height].Hash() != r.blockHash):
+			poolLog.Info("block orphaned", "height", r.height, "hash", fmt.Sprintf("%x", r.blockHash))
+			p.totalRewards -= r.reward
+			for worker, shares := range r.shares {
+				p.roundShares[worker] += shares
+			}
+		case r.unpaid != nil || p.blockchain.IsMature(r.height):
+			if r.unpaid == nil {
+				r.split()
+			}
+			due = append(due, r)
+			pending = append(pending, r)
+		default:
+			pending = append(pending, r)
+		}
+	}
+	p.rounds = pending
+	p.mu.Unlock()
+
+	for _, r := range due {
+		p.payRound(r)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pending = p.rounds[:0]
+	for _, r := range p.rounds {
+		if r.unpaid == nil || len(r.unpaid) > 0 {
+			pending = append(pending, r)
+		}
+	}
+	p.rounds = pending
+}
+
+// split divides the block reward of r between the workers proportionally to
+// their shares in the round.
+func (r *round) split() {
+	total := 0
+	for _, shares := range r.shares {
+		total += shares
+	}
+	reward := r.reward * (1 - POOL_FEE)
+	r.unpaid = make(map[string]float32, len(r.shares))
+	for worker, shares := range r.shares {
+		if amount := reward * float32(shares) / float32(total); amount > 0 {
+			r.unpaid[worker] = amount
+		}
+	}
+}
+
+// payRound sends each unpaid part of r from the pool wallet, taking mu only
+// to read and record the parts. The parts that could not be sent stay in
+// r.unpaid for the next pass.
+func (p *Pool) payRound(r *round) {
+	p.mu.Lock()
+	unpaid := make(map[string]float32, len(r.unpaid))
+	workers := make([]string, 0, len(r.unpaid))
+	for worker, amount := range r.unpaid {
+		unpaid[worker] = amount
+		workers = append(workers, worker)
+	}
+	p.mu.Unlock()
+	sort.Strings(workers)
+
+	blockHash := fmt.Sprintf("%x", r.blockHash)
+	for _, worker := range workers {
+		amount := unpaid[worker]
+		t := wallet.NewTransactionAt(p.wallet.PrivateKey(), p.wallet.PublicKey(), p.Address(), worker, amount,
+			p.blockchain.Clock().Now().UnixNano())
+		signature := t.GenerateSignatureFrom(p.entropy)
+		if !p.blockchain.CreateTransactionAt(p.Address(), worker, amount, t.Timestamp(), p.wallet.PublicKey(), signature) {
+			poolLog.Warn("payout failed, retrying on the next pass", "worker", worker, "amount", amount, "block", blockHash)
+			continue
+		}
+
+		p.mu.Lock()
+		delete(r.unpaid, worker)
+		p.workerStats(worker).Paid += amount
+		p.payouts = append(p.payouts, &Payout{Worker: worker, Amount: amount, BlockHash: blockHash})
+		p.mu.Unlock()
+		poolLog.Info("payout sent", "worker", worker, "amount", amount, "block", blockHash)
+	}
+}
+
+// dropStaleJobs forgets every job that does not build on previousHash.
+func (p *Pool) dropStaleJobs(previousHash [32]byte) {
+	for id, job := range p.jobs {
+		if job.PreviousHash != previousHash {
+			delete(p.jobs, id)
+		}
+	}
+}
+
+func (p *Pool) workerStats(worker string) *WorkerStats {
+	ws, ok := p.workers[worker]
+	if !ok {
+		ws = &WorkerStats{}
+		p.workers[worker] = ws
+	}
+	return ws
+}
+
+func (p *Pool) Stats() *Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := &Stats{
+		PoolAddress:     p.Address(),
+		ShareDifficulty: p.shareDifficulty,
+		BlocksFound:     p.blocksFound,
+		TotalRewards:    p.totalRewards,
+		Workers:         make(map[string]*WorkerStats, len(p.workers)),
+		Payouts:         append([]*Payout{}, p.payouts...),
+	}
+	for _, r := range p.rounds {
+		if r.unpaid == nil {
+			s.ImmatureRewards += r.reward
+			continue
+		}
+		for _, amount := range r.unpaid {
+			s.UnpaidRewards += amount
+		}
+	}
+	for worker, ws := range p.workers {
+		c := *ws
+		c.RoundShares = p.roundShares[worker]
+		s.RoundShares += c.RoundShares
+		s.Workers[worker] = &c
+	}
+	return s
+}
+
+type ShareRequest struct {
+	WorkerAddress *string `json:"worker_address"`
+	JobID         *string `json:"job_id"`
+	Nonce         *int    `json:"nonce"`
+}
+
+func (sr *ShareRequest) Validate() map[string]string {
+	errorText := "missing value"
+	mapError := map[string]string{}
+	if sr.WorkerAddress == nil {
+		mapError["worker_address"] = errorText
+	}
+
+	if sr.JobID == nil {
+		mapError["job_id"] = errorText
+	}
+
+	if sr.Nonce == nil {
+		mapError["nonce"] = errorText
+	}
+	return mapError
+}
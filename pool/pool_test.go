@@ -0,0 +1,309 @@
+package pool
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Nico2220/blockchain/block"
+	"github.com/Nico2220/blockchain/entropy"
+	"github.com/Nico2220/blockchain/wallet"
+)
+
+const (
+	workerA = "1WorkerA"
+	workerB = "1WorkerB"
+)
+
+// testPool returns a pool whose shares are easier than blocks. The pool
+// wallet gets 5 in the genesis block.
+func testPool(t *testing.T) (*Pool, *block.Blockchain) {
+	t.Helper()
+	w, err := wallet.NewWalletFrom(entropy.NewFake(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := block.RegtestParams()
+	params.MiningDifficulty = 2
+	params.Genesis.Allocations = []*block.Allocation{{Address: w.BlockchainAddress(), Amount: 5}}
+	bc := block.NewBlockchain("1Node", 0, params)
+	return NewPool(bc, w, 1), bc
+}
+
+// share finds a nonce of job that is a share, and a block when found is
+// true.
+func share(t *testing.T, p *Pool, job *Job, found bool) int {
+	t.Helper()
+	for nonce := job.NonceStart; nonce < job.NonceEnd; nonce++ {
+		if p.blockchain.ValidProof(nonce, job.PreviousHash, job.Transactions, job.ShareDifficulty) &&
+			p.blockchain.ValidProof(nonce, job.PreviousHash, job.Transactions, job.NetworkDifficulty) == found &&
+			!job.seen[nonce] {
+			return nonce
+		}
+	}
+	t.Fatal("no share in the nonce range")
+	return 0
+}
+
+// submit finds and submits shares of worker until one is a block when found
+// is true, or a single share otherwise.
+func submit(t *testing.T, p *Pool, worker string, found bool) *ShareResult {
+	t.Helper()
+	job := p.GetWork(worker)
+	result, err := p.SubmitShare(worker, job.ID, share(t, p, job, found))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Accepted || result.BlockFound != found {
+		t.Fatalf("share result %+v, want a block: %v", result, found)
+	}
+	return result
+}
+
+// extend mines n blocks that only pay their subsidy to another miner.
+func extend(t *testing.T, bc *block.Blockchain, n int) {
+	t.Helper()
+	params := bc.Params()
+	for i := 0; i < n; i++ {
+		height := bc.Height() + 1
+		transactions := []*block.Transaction{
+			block.NewTransactionAt(params.MiningSender, "1Other", params.Subsidy(height), int64(height)),
+		}
+		previousHash := bc.LasBlock().Hash()
+		nonce := 0
+		for !bc.ValidProof(nonce, previousHash, transactions, params.MiningDifficulty) {
+			nonce++
+		}
+		if _, err := bc.SubmitWork(nonce, previousHash, transactions); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestShareAccounting(t *testing.T) {
+	p, _ := testPool(t)
+	for i := 0; i < 3; i++ {
+		submit(t, p, workerA, false)
+	}
+	submit(t, p, workerB, false)
+
+	job := p.GetWork(workerA)
+	nonce := share(t, p, job, false)
+	if _, err := p.SubmitShare(workerA, job.ID, nonce); err != nil {
+		t.Fatal(err)
+	}
+	low := job.NonceStart
+	for p.blockchain.ValidProof(low, job.PreviousHash, job.Transactions, job.ShareDifficulty) {
+		low++
+	}
+
+	tests := map[string]struct {
+		worker, job string
+		nonce       int
+		err         error
+	}{
+		"duplicate":      {workerA, job.ID, nonce, ErrDuplicateShare},
+		"other worker":   {workerB, job.ID, nonce + 1, ErrUnknownJob},
+		"unknown job":    {workerA, "unknown", nonce, ErrUnknownJob},
+		"out of range":   {workerA, job.ID, job.NonceEnd, ErrNonceOutOfRange},
+		"low difficulty": {workerA, job.ID, low, ErrLowDifficulty},
+	}
+	for name, test := range tests {
+		if _, err := p.SubmitShare(test.worker, test.job, test.nonce); !errors.Is(err, test.err) {
+			t.Errorf("%s: got %v, want %v", name, err, test.err)
+		}
+	}
+
+	stats := p.Stats()
+	if stats.RoundShares != 5 || stats.Workers[workerA].RoundShares != 4 || stats.Workers[workerB].RoundShares != 1 {
+		t.Fatalf("round shares %d, a %d, b %d; want 5, 4 and 1",
+			stats.RoundShares, stats.Workers[workerA].RoundShares, stats.Workers[workerB].RoundShares)
+	}
+}
+
+// TestMaturePayout checks that a round is paid in proportion to the shares,
+// and only once its block reward has matured.
+func TestMaturePayout(t *testing.T) {
+	p, bc := testPool(t)
+	for i := 0; i < 3; i++ {
+		submit(t, p, workerA, false)
+	}
+	result := submit(t, p, workerB, true)
+
+	stats := p.Stats()
+	if stats.BlocksFound != 1 || stats.ImmatureRewards != 1 || stats.RoundShares != 0 {
+		t.Fatalf("after the block: %+v", stats)
+	}
+	if len(p.rounds) != 1 || p.rounds[0].height != 1 || p.rounds[0].blockHash != bc.LasBlock().Hash() {
+		t.Fatalf("round recorded at the wrong block")
+	}
+
+	extend(t, bc, bc.Params().CoinbaseMaturity-1)
+	p.GetWork(workerA)
+	if len(p.Stats().Payouts) != 0 {
+		t.Fatal("paid before the reward matured")
+	}
+
+	extend(t, bc, 1)
+	p.GetWork(workerA)
+	stats = p.Stats()
+	if len(stats.Payouts) != 2 || stats.ImmatureRewards != 0 || len(p.rounds) != 0 {
+		t.Fatalf("after maturity: %+v", stats)
+	}
+	if stats.Workers[workerA].Paid != 0.75 || stats.Workers[workerB].Paid != 0.25 {
+		t.Fatalf("paid a %v and b %v, want 0.75 and 0.25", stats.Workers[workerA].Paid, stats.Workers[workerB].Paid)
+	}
+	for _, payout := range stats.Payouts {
+		if payout.BlockHash != result.BlockHash {
+			t.Errorf("payout for block %s, want %s", payout.BlockHash, result.BlockHash)
+		}
+	}
+	if pending := bc.TransactionPool(); len(pending) != 2 {
+		t.Fatalf("%d payouts in the mempool, want 2", len(pending))
+	}
+}
+
+// TestOrphanedRound checks that the shares of a block that lost a reorg are
+// counted again in the current round.
+func TestOrphanedRound(t *testing.T) {
+	p, bc := testPool(t)
+	submit(t, p, workerA, false)
+	submit(t, p, workerB, true)
+
+	other := block.NewBlockchain("1Node", 0, bc.Params())
+	extend(t, other, 2)
+	if err := bc.ImportChain(other.Chain()); err != nil {
+		t.Fatal(err)
+	}
+
+	p.GetWork(workerA)
+	stats := p.Stats()
+	if len(p.rounds) != 0 || stats.TotalRewards != 0 || stats.ImmatureRewards != 0 {
+		t.Fatalf("orphaned round kept: %+v", stats)
+	}
+	if stats.Workers[workerA].RoundShares != 1 || stats.Workers[workerB].RoundShares != 1 {
+		t.Fatalf("shares a %d, b %d back in the round, want 1 and 1",
+			stats.Workers[workerA].RoundShares, stats.Workers[workerB].RoundShares)
+	}
+}
+
+// TestPayoutRetry checks that a payout the pool wallet can't afford yet is
+// kept and sent on a later pass.
+func TestPayoutRetry(t *testing.T) {
+	p, bc := testPool(t)
+	submit(t, p, workerA, true)
+	extend(t, bc, bc.Params().CoinbaseMaturity)
+
+	// The pool wallet spends everything it has before the payout.
+	spendable := bc.CalculateBalance(p.Address()).Spendable
+	tx := wallet.NewTransactionAt(p.wallet.PrivateKey(), p.wallet.PublicKey(), p.Address(), "1Elsewhere", spendable,
+		bc.Clock().Now().UnixNano())
+	if !bc.AddTransactionAt(p.Address(), "1Elsewhere", spendable, tx.Timestamp(), p.wallet.PublicKey(), tx.GenerateSignature()) {
+		t.Fatal("spending transaction rejected")
+	}
+
+	p.GetWork(workerA)
+	if stats := p.Stats(); len(stats.Payouts) != 0 || stats.UnpaidRewards != 1 || len(p.rounds) != 1 {
+		t.Fatalf("after a failed payout: %+v", stats)
+	}
+
+	bc.ClearTransactionPool()
+	p.GetWork(workerA)
+	if stats := p.Stats(); len(stats.Payouts) != 1 || stats.UnpaidRewards != 0 || stats.Workers[workerA].Paid != 1 {
+		t.Fatalf("after the retry: %+v", stats)
+	}
+	if len(p.rounds) != 0 {
+		t.Fatal("the paid round was kept")
+	}
+}
+
+func TestJobCap(t *testing.T) {
+	p, _ := testPool(t)
+	first := p.GetWork(workerA)
+	for i := 0; i < MAX_JOBS; i++ {
+		p.GetWork(workerB)
+	}
+	if len(p.jobs) != MAX_JOBS {
+		t.Fatalf("%d jobs kept, want %d", len(p.jobs), MAX_JOBS)
+	}
+	if _, err := p.SubmitShare(workerA, first.ID, share(t, p, first, false)); !errors.Is(err, ErrUnknownJob) {
+		t.Fatalf("share of the oldest job: %v", err)
+	}
+}
+
+// hang holds the requests to the neighbors until release is closed.
+type hang struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *hang) RoundTrip(*http.Request) (*http.Response, error) {
+	select {
+	case h.started <- struct{}{}:
+	default:
+	}
+	<-h.release
+	return nil, errors.New("neighbor gone")
+}
+
+// served fails the test unless f returns while a neighbor hangs.
+func served(t *testing.T, name string, f func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s waited for a neighbor", name)
+	}
+}
+
+// TestSlowNeighbor checks that the workers are served while a block or a
+// payout is relayed to a neighbor that doesn't answer.
+func TestSlowNeighbor(t *testing.T) {
+	p, bc := testPool(t)
+	submit(t, p, workerA, true)
+	extend(t, bc, bc.Params().CoinbaseMaturity)
+
+	h := &hang{started: make(chan struct{}, 1), release: make(chan struct{})}
+	bc.SetTransport(h)
+	bc.SetPeers([]string{"127.0.0.1:5001"})
+	bc.SetNeighbors()
+	var relays sync.WaitGroup
+	defer relays.Wait()
+	defer close(h.release)
+
+	// The payout of the round is relayed.
+	relays.Add(1)
+	go func() {
+		defer relays.Done()
+		p.GetWork(workerA)
+	}()
+	<-h.started
+	served(t, "GetWork during a payout", func() { p.GetWork(workerB) })
+	served(t, "Stats during a payout", func() { p.Stats() })
+
+	// A block is relayed. It only holds its coinbase.
+	bc.ClearTransactionPool()
+	job := p.GetWork(workerB)
+	nonce := share(t, p, job, true)
+	relays.Add(1)
+	go func() {
+		defer relays.Done()
+		if result, err := p.SubmitShare(workerB, job.ID, nonce); err != nil || !result.BlockFound {
+			t.Errorf("block share %+v: %v", result, err)
+		}
+	}()
+	<-h.started
+	served(t, "SubmitShare during a block relay", func() {
+		job := p.GetWork(workerA)
+		if _, err := p.SubmitShare(workerA, job.ID, share(t, p, job, false)); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,262 @@
+// Package ratelimit protects the HTTP routes of the servers from clients that
+// send too much: a token bucket per client IP, a maximum body size, a
+// timeout and a cap on the requests of a route served at once. Each route has
+// its own rule, routes without one share the DEFAULT_ROUTE rule.
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Nico2220/blockchain/clock"
+	"github.com/Nico2220/blockchain/logging"
+	"github.com/Nico2220/blockchain/metrics"
+	"github.com/Nico2220/blockchain/utils"
+)
+
+var apiLog = logging.New(logging.API)
+
+// DEFAULT_ROUTE is the key of the rule used by the routes that have none.
+const DEFAULT_ROUTE = "default"
+
+// MAX_CLIENTS is the number of client buckets a route keeps at most. Past
+// it, the clients whose bucket is full again are forgotten, or the least
+// recently seen one when none is.
+const MAX_CLIENTS = 10000
+
+// Reasons a request is limited, the label of the limited requests counter.
+const (
+	REASON_RATE        = "rate"
+	REASON_CONCURRENCY = "concurrency"
+	REASON_BODY        = "body"
+)
+
+// Rule limits the requests of one route. A zero field disables its limit.
+type Rule struct {
+	// Rate is how many requests per second a client IP may send on
+	// average, Burst how many it may send at once. Burst defaults to Rate,
+	// and at least 1.
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+	// MaxBody is the largest request body in bytes.
+	MaxBody int64 `yaml:"max_body"`
+	// Timeout is how long the handler may take before the client gets a
+	// 503. Don't set it on websocket routes.
+	Timeout time.Duration `yaml:"timeout"`
+	// Concurrency is how many requests of the route are served at once,
+	// whatever their client.
+	Concurrency int `yaml:"concurrency"`
+}
+
+// Validate reports the settings of the rule that are out of range.
+func (r Rule) Validate() error {
+	var problems []error
+	if r.Rate < 0 || math.IsNaN(r.Rate) || math.IsInf(r.Rate, 0) {
+		problems = append(problems, fmt.Errorf("rate: must be a positive number or 0, got %v", r.Rate))
+	}
+	if r.Burst < 0 {
+		problems = append(problems, fmt.Errorf("burst: must not be negative, got %d", r.Burst))
+	}
+	if r.MaxBody < 0 {
+		problems = append(problems, fmt.Errorf("max_body: must not be negative, got %d", r.MaxBody))
+	}
+	if r.Timeout < 0 {
+		problems = append(problems, fmt.Errorf("timeout: must not be negative, got %s", r.Timeout))
+	}
+	if r.Concurrency < 0 {
+		problems = append(problems, fmt.Errorf("concurrency: must not be negative, got %d", r.Concurrency))
+	}
+	return errors.Join(problems...)
+}
+
+func (r Rule) burst() float64 {
+	if r.Burst > 0 {
+		return float64(r.Burst)
+	}
+	return math.Max(1, math.Ceil(r.Rate))
+}
+
+// Limits applies the rules of a server to its routes.
+type Limits struct {
+	rules   map[string]Rule
+	clock   clock.Clock
+	limited *metrics.Counter
+
+	mu   sync.Mutex
+	used map[string]bool
+}
+
+// New returns the limits of rules, keyed by route pattern as the routes are
+// registered, e.g. "POST /transactions", or DEFAULT_ROUTE. Buckets fill on
+// clock and limited requests are counted in r.
+func New(rules map[string]Rule, c clock.Clock, r *metrics.Registry) *Limits {
+	return &Limits{
+		rules:   rules,
+		clock:   c,
+		limited: r.NewCounter("http_requests_limited_total", "HTTP requests refused or cut by a rate, concurrency or body limit.", "route", "reason"),
+		used:    make(map[string]bool),
+	}
+}
+
+// Rule returns the rule of the route registered as pattern.
+func (l *Limits) Rule(pattern string) Rule {
+	if rule, ok := l.rules[pattern]; ok {
+		return rule
+	}
+	return l.rules[DEFAULT_ROUTE]
+}
+
+// Unused returns the routes that have a rule but were never wrapped, most
+// likely misspelled in the configuration.
+func (l *Limits) Unused() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var unused []string
+	for pattern := range l.rules {
+		if pattern != DEFAULT_ROUTE && !l.used[pattern] {
+			unused = append(unused, pattern)
+		}
+	}
+	return unused
+}
+
+// Wrap limits the requests next serves for the route registered as pattern.
+// Requests over the rate or concurrency limit get a 429 with a Retry-After
+// header, bodies announced larger than the limit a 413.
+func (l *Limits) Wrap(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	l.mu.Lock()
+	l.used[pattern] = true
+	l.mu.Unlock()
+
+	rule := l.Rule(pattern)
+	rl := &routeLimiter{rule: rule, clock: l.clock, buckets: make(map[string]*bucket)}
+	if rule.Concurrency > 0 {
+		rl.slots = make(chan struct{}, rule.Concurrency)
+	}
+	// The slot is given back when next returns, not when the timeout
+	// answers the client, so that the handlers still running count.
+	var handler http.Handler = next
+	if rl.slots != nil {
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() { <-rl.slots }()
+			next(w, r)
+		})
+	}
+	if rule.Timeout > 0 {
+		handler = http.TimeoutHandler(handler, rule.Timeout, `{"error": "request timed out"}`)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rule.Rate > 0 {
+			if wait, ok := rl.take(clientIP(r)); !ok {
+				l.refuse(w, r, pattern, REASON_RATE, wait)
+				return
+			}
+		}
+		if rule.MaxBody > 0 {
+			if r.ContentLength > rule.MaxBody {
+				l.limited.Inc(pattern, REASON_BODY)
+				utils.WriteJSON(w, http.StatusRequestEntityTooLarge,
+					map[string]any{"error": fmt.Sprintf("request body is larger than %d bytes", rule.MaxBody)})
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, rule.MaxBody)
+		}
+		if rl.slots != nil {
+			select {
+			case rl.slots <- struct{}{}:
+			default:
+				l.refuse(w, r, pattern, REASON_CONCURRENCY, time.Second)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	}
+}
+
+func (l *Limits) refuse(w http.ResponseWriter, r *http.Request, pattern, reason string, wait time.Duration) {
+	l.limited.Inc(pattern, reason)
+	apiLog.DebugContext(r.Context(), "request limited", "route", pattern, "reason", reason, "client", clientIP(r))
+
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	utils.WriteJSON(w, http.StatusTooManyRequests, map[string]any{"error": "too many requests"})
+}
+
+// routeLimiter holds the state of the limits of one route.
+type routeLimiter struct {
+	rule  Rule
+	clock clock.Clock
+	slots chan struct{}
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take spends a token of the bucket of client. When it is empty, it returns
+// how long until the next token.
+func (rl *routeLimiter) take(client string) (time.Duration, bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.clock.Now()
+	burst := rl.rule.burst()
+	b, ok := rl.buckets[client]
+	if !ok {
+		if len(rl.buckets) >= MAX_CLIENTS {
+			rl.forget(now, burst)
+		}
+		b = &bucket{tokens: burst, last: now}
+		rl.buckets[client] = b
+	}
+
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rl.rule.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / rl.rule.Rate * float64(time.Second)), false
+	}
+	b.tokens--
+	return 0, true
+}
+
+// forget drops the buckets that are full again: their clients are back to
+// where they started. When none is, it drops the least recently used bucket,
+// so that clients that keep their bucket drained from rotating addresses can't
+// grow the table.
+func (rl *routeLimiter) forget(now time.Time, burst float64) {
+	var oldest string
+	for client, b := range rl.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rl.rule.Rate >= burst {
+			delete(rl.buckets, client)
+			continue
+		}
+		if oldest == "" || b.last.Before(rl.buckets[oldest].last) {
+			oldest = client
+		}
+	}
+	if len(rl.buckets) >= MAX_CLIENTS {
+		delete(rl.buckets, oldest)
+	}
+}
+
+// clientIP returns the IP the request comes from. Forwarding headers are
+// ignored, since any client can set them.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,157 @@
+package ratelimit
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Nico2220/blockchain/clock"
+	"github.com/Nico2220/blockchain/metrics"
+)
+
+func serve(h http.HandlerFunc, method, remoteAddr, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/", strings.NewReader(body))
+	req.RemoteAddr = remoteAddr
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func ok(w http.ResponseWriter, r *http.Request) {
+	if _, err := io.ReadAll(r.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func TestRate(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1735689600, 0))
+	limits := New(map[string]Rule{"POST /transactions": {Rate: 0.5, Burst: 2}}, fake, metrics.NewRegistry())
+	h := limits.Wrap("POST /transactions", ok)
+
+	for i := 0; i < 2; i++ {
+		if rec := serve(h, http.MethodPost, "10.0.0.1:1234", ""); rec.Code != http.StatusOK {
+			t.Fatalf("request %d of the burst answered %d", i, rec.Code)
+		}
+	}
+	rec := serve(h, http.MethodPost, "10.0.0.1:4321", "")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+		t.Fatalf("over the burst: %d, Retry-After %q, want 429 after 2s", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := serve(h, http.MethodPost, "10.0.0.2:1234", ""); rec.Code != http.StatusOK {
+		t.Fatalf("another client was limited: %d", rec.Code)
+	}
+
+	fake.Advance(2 * time.Second)
+	if rec := serve(h, http.MethodPost, "10.0.0.1:1234", ""); rec.Code != http.StatusOK {
+		t.Fatalf("after the refill: %d", rec.Code)
+	}
+	if rec := serve(h, http.MethodPost, "10.0.0.1:1234", ""); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("a single token refilled, got %d", rec.Code)
+	}
+}
+
+func TestMaxClients(t *testing.T) {
+	fake := clock.NewFake(time.Unix(1735689600, 0))
+	rl := &routeLimiter{rule: Rule{Rate: 0.001, Burst: 1}, clock: fake, buckets: make(map[string]*bucket)}
+
+	// Every client drains its bucket, so none is forgotten for being full.
+	for i := 0; i < MAX_CLIENTS+10; i++ {
+		if _, ok := rl.take(fmt.Sprintf("client%d", i)); !ok {
+			t.Fatalf("client %d limited on its first request", i)
+		}
+		fake.Advance(time.Millisecond)
+	}
+	if n := len(rl.buckets); n != MAX_CLIENTS {
+		t.Fatalf("%d buckets, want at most %d", n, MAX_CLIENTS)
+	}
+	if _, ok := rl.buckets["client0"]; ok {
+		t.Fatal("the least recently used bucket was kept")
+	}
+	if _, ok := rl.take(fmt.Sprintf("client%d", MAX_CLIENTS+9)); ok {
+		t.Fatal("the most recent client was forgotten")
+	}
+}
+
+func TestDefaultRule(t *testing.T) {
+	limits := New(map[string]Rule{DEFAULT_ROUTE: {Rate: 1}, "GET /free": {}}, clock.Real, metrics.NewRegistry())
+	limited, free := limits.Wrap("GET /chain", ok), limits.Wrap("GET /free", ok)
+
+	serve(limited, http.MethodGet, "10.0.0.1:1", "")
+	if rec := serve(limited, http.MethodGet, "10.0.0.1:1", ""); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("a route without a rule got %d, want the default limit", rec.Code)
+	}
+	for i := 0; i < 5; i++ {
+		if rec := serve(free, http.MethodGet, "10.0.0.1:1", ""); rec.Code != http.StatusOK {
+			t.Fatalf("a route with an empty rule got %d", rec.Code)
+		}
+	}
+	if unused := limits.Unused(); len(unused) != 0 {
+		t.Fatalf("unused %v", unused)
+	}
+}
+
+func TestMaxBody(t *testing.T) {
+	limits := New(map[string]Rule{DEFAULT_ROUTE: {MaxBody: 8}}, clock.Real, metrics.NewRegistry())
+	h := limits.Wrap("POST /transactions", ok)
+
+	if rec := serve(h, http.MethodPost, "10.0.0.1:1", "12345678"); rec.Code != http.StatusOK {
+		t.Fatalf("a body at the limit got %d", rec.Code)
+	}
+	if rec := serve(h, http.MethodPost, "10.0.0.1:1", "123456789"); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("a body over the limit got %d", rec.Code)
+	}
+
+	// Without a Content-Length the body is cut while it is read.
+	req := httptest.NewRequest(http.MethodPost, "/", io.MultiReader(strings.NewReader("123456789")))
+	req.ContentLength = -1
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("a streamed body over the limit got %d", rec.Code)
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	limits := New(map[string]Rule{"PUT /consensus": {Concurrency: 1}}, clock.Real, metrics.NewRegistry())
+	started, release := make(chan struct{}), make(chan struct{})
+	h := limits.Wrap("PUT /consensus", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	done := make(chan int)
+	go func() { done <- serve(h, http.MethodPut, "10.0.0.1:1", "").Code }()
+	<-started
+	rec := serve(h, http.MethodPut, "10.0.0.2:1", "")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("a second request at once got %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("the first request got %d", code)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	limits := New(map[string]Rule{"/mine": {Timeout: 10 * time.Millisecond}}, clock.Real, metrics.NewRegistry())
+	h := limits.Wrap("/mine", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	if rec := serve(h, http.MethodGet, "10.0.0.1:1", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("a slow handler got %d, want 503", rec.Code)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := (Rule{Rate: 1, Burst: 2, MaxBody: 1, Timeout: time.Second, Concurrency: 1}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+	err := Rule{Rate: -1, Concurrency: -1}.Validate()
+	if err == nil || !strings.Contains(err.Error(), "rate") || !strings.Contains(err.Error(), "concurrency") {
+		t.Fatalf("got %v, want the rate and concurrency problems", err)
+	}
+}
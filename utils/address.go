@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+
+	"github.com/btcsuite/btcutil/base58"
+	"golang.org/x/crypto/ripemd160"
+)
+
+// AddressFromPublicKey returns the blockchain address of a public key: the
+// base58 of a version byte, the ripemd160 of the sha256 of the key and a
+// checksum of 4 bytes.
+func AddressFromPublicKey(publicKey *ecdsa.PublicKey) string {
+	h := sha256.New()
+	h.Write(publicKey.X.Bytes())
+	h.Write(publicKey.Y.Bytes())
+
+	r := ripemd160.New()
+	r.Write(h.Sum(nil))
+
+	versioned := append([]byte{0x00}, r.Sum(nil)...)
+	first := sha256.Sum256(versioned)
+	checksum := sha256.Sum256(first[:])
+	return base58.Encode(append(versioned, checksum[:4]...))
+}
@@ -1,13 +1,40 @@
 package utils
 
 import (
+	"crypto/ecdh"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"math/big"
 )
 
+// The reasons a key or signature string is rejected, wrapped in a
+// DecodeError.
+var (
+	ErrLength      = errors.New("wrong length")
+	ErrNotHex      = errors.New("not hexadecimal")
+	ErrNotOnCurve  = errors.New("not a point of the P-256 curve")
+	ErrOutOfRange  = errors.New("out of range")
+	ErrKeyMismatch = errors.New("does not match the public key")
+)
+
+// DecodeError tells which value a string could not be decoded to. Err is one
+// of the errors above.
+type DecodeError struct {
+	Value string
+	Err   error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("invalid %s: %v", e.Value, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
 type Signature struct {
 	R *big.Int // public key x coordinate
 	S *big.Int // the signature
@@ -17,33 +44,73 @@ func (s *Signature) String() string {
 	return fmt.Sprintf("%064x%064x", s.R, s.S)
 }
 
-func String2BigIntTuple(s string) (big.Int, big.Int) {
-	bx, _ := hex.DecodeString(s[:64])
-	by, _ := hex.DecodeString(s[64:])
-
+// String2BigIntTuple decodes 128 hex characters as two 256 bit numbers.
+func String2BigIntTuple(s string) (big.Int, big.Int, error) {
 	var bix big.Int
 	var biy big.Int
 
+	if len(s) != 128 {
+		return bix, biy, fmt.Errorf("%w: %d characters, want 128", ErrLength, len(s))
+	}
+	bx, err := hex.DecodeString(s[:64])
+	if err != nil {
+		return bix, biy, ErrNotHex
+	}
+	by, err := hex.DecodeString(s[64:])
+	if err != nil {
+		return bix, biy, ErrNotHex
+	}
+
 	bix.SetBytes(bx)
 	biy.SetBytes(by)
 
-	return bix, biy
+	return bix, biy, nil
 }
 
-func SignatureFromString(s string) *Signature {
-	x, y := String2BigIntTuple(s)
-	return &Signature{R: &x, S: &y}
+func SignatureFromString(s string) (*Signature, error) {
+	x, y, err := String2BigIntTuple(s)
+	if err != nil {
+		return nil, &DecodeError{Value: "signature", Err: err}
+	}
+	return &Signature{R: &x, S: &y}, nil
 }
 
-func PublickKeyFromString(s string) *ecdsa.PublicKey {
-	x, y := String2BigIntTuple(s)
-	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: &x, Y: &y}
-
+// PublickKeyFromString decodes the X and Y coordinates of a public key and
+// checks that they are a point of the curve.
+func PublickKeyFromString(s string) (*ecdsa.PublicKey, error) {
+	x, y, err := String2BigIntTuple(s)
+	if err != nil {
+		return nil, &DecodeError{Value: "public key", Err: err}
+	}
+	point, _ := hex.DecodeString("04" + s)
+	if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+		return nil, &DecodeError{Value: "public key", Err: ErrNotOnCurve}
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: &x, Y: &y}, nil
 }
 
-func PrivateKeyFromString(s string, publicKey *ecdsa.PublicKey) *ecdsa.PrivateKey {
-	b, _ := hex.DecodeString(s)
+// PrivateKeyFromString decodes the hex form of a private key, at most 64
+// characters, and checks that it belongs to publicKey.
+func PrivateKeyFromString(s string, publicKey *ecdsa.PublicKey) (*ecdsa.PrivateKey, error) {
+	if len(s) == 0 || len(s) > 64 {
+		return nil, &DecodeError{Value: "private key", Err: fmt.Errorf("%w: %d characters, want 1 to 64", ErrLength, len(s))}
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, &DecodeError{Value: "private key", Err: ErrNotHex}
+	}
 	var bi big.Int
 	bi.SetBytes(b)
-	return &ecdsa.PrivateKey{PublicKey: *publicKey, D: &bi}
+
+	key, err := ecdh.P256().NewPrivateKey(bi.FillBytes(make([]byte, 32)))
+	if err != nil {
+		return nil, &DecodeError{Value: "private key", Err: ErrOutOfRange}
+	}
+	point := key.PublicKey().Bytes()
+	x := new(big.Int).SetBytes(point[1:33])
+	y := new(big.Int).SetBytes(point[33:])
+	if x.Cmp(publicKey.X) != 0 || y.Cmp(publicKey.Y) != 0 {
+		return nil, &DecodeError{Value: "private key", Err: ErrKeyMismatch}
+	}
+	return &ecdsa.PrivateKey{PublicKey: *publicKey, D: &bi}, nil
 }
@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func testKey(t testing.TB) (*ecdsa.PrivateKey, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, fmt.Sprintf("%064x%064x", key.X, key.Y)
+}
+
+func FuzzString2BigIntTuple(f *testing.F) {
+	f.Add(strings.Repeat("0", 128))
+	f.Add(strings.Repeat("f", 64))
+	f.Add(strings.Repeat("g", 128))
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, s string) {
+		x, y, err := String2BigIntTuple(s)
+		if err != nil {
+			if !errors.Is(err, ErrLength) && !errors.Is(err, ErrNotHex) {
+				t.Fatalf("untyped error %v", err)
+			}
+			return
+		}
+		if got := fmt.Sprintf("%064x%064x", &x, &y); got != strings.ToLower(s) {
+			t.Fatalf("decoded %q, encoded back to %q", s, got)
+		}
+	})
+}
+
+func FuzzSignatureFromString(f *testing.F) {
+	f.Add((&Signature{R: big.NewInt(1), S: big.NewInt(2)}).String())
+	f.Add("00")
+
+	f.Fuzz(func(t *testing.T, s string) {
+		signature, err := SignatureFromString(s)
+		if err != nil {
+			var de *DecodeError
+			if !errors.As(err, &de) {
+				t.Fatalf("untyped error %v", err)
+			}
+			return
+		}
+		if signature.String() != strings.ToLower(s) {
+			t.Fatalf("decoded %q, encoded back to %q", s, signature.String())
+		}
+	})
+}
+
+func FuzzPublickKeyFromString(f *testing.F) {
+	_, publicKey := testKey(f)
+	f.Add(publicKey)
+	f.Add(strings.Repeat("0", 128))
+
+	f.Fuzz(func(t *testing.T, s string) {
+		key, err := PublickKeyFromString(s)
+		if err != nil {
+			var de *DecodeError
+			if !errors.As(err, &de) {
+				t.Fatalf("untyped error %v", err)
+			}
+			return
+		}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			t.Fatalf("accepted %q, which is not on the curve", s)
+		}
+	})
+}
+
+func FuzzPrivateKeyFromString(f *testing.F) {
+	key, _ := testKey(f)
+	f.Add(fmt.Sprintf("%x", key.D.Bytes()))
+	f.Add("00")
+	f.Add(strings.Repeat("f", 64))
+
+	f.Fuzz(func(t *testing.T, s string) {
+		private, err := PrivateKeyFromString(s, &key.PublicKey)
+		if err != nil {
+			var de *DecodeError
+			if !errors.As(err, &de) {
+				t.Fatalf("untyped error %v", err)
+			}
+			return
+		}
+		if private.D.Cmp(key.D) != 0 {
+			t.Fatalf("accepted %q for another public key", s)
+		}
+	})
+}
+
+func TestDecodeErrors(t *testing.T) {
+	key, publicKey := testKey(t)
+	offCurve := publicKey[:127] + "0"
+	if publicKey[127] == '0' {
+		offCurve = publicKey[:127] + "1"
+	}
+	other, _ := testKey(t)
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"short signature", second(SignatureFromString("abc")), ErrLength},
+		{"hex signature", second(SignatureFromString(strings.Repeat("z", 128))), ErrNotHex},
+		{"short public key", second(PublickKeyFromString(publicKey[:64])), ErrLength},
+		{"off curve", second(PublickKeyFromString(offCurve)), ErrNotOnCurve},
+		{"long private key", second(PrivateKeyFromString(strings.Repeat("1", 66), &key.PublicKey)), ErrLength},
+		{"odd private key", second(PrivateKeyFromString("abc", &key.PublicKey)), ErrNotHex},
+		{"zero private key", second(PrivateKeyFromString("00", &key.PublicKey)), ErrOutOfRange},
+		{"other private key", second(PrivateKeyFromString(fmt.Sprintf("%x", other.D), &key.PublicKey)), ErrKeyMismatch},
+	}
+	for _, test := range tests {
+		var de *DecodeError
+		if !errors.As(test.err, &de) || !errors.Is(test.err, test.want) {
+			t.Errorf("%s: got %v, want a DecodeError for %v", test.name, test.err, test.want)
+		}
+	}
+}
+
+func second[T any](_ T, err error) error {
+	return err
+}
@@ -0,0 +1,17 @@
+package utils
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// HashFromString parses the hex form of a block or transaction hash.
+func HashFromString(s string) ([32]byte, error) {
+	var h [32]byte
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(h) {
+		return h, fmt.Errorf("%q is not a 64 character hex hash", s)
+	}
+	copy(h[:], b)
+	return h, nil
+}
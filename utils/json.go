@@ -5,8 +5,12 @@ import (
 	"net/http"
 )
 
+// MAX_JSON_BODY caps the bodies ReadJSON decodes, whatever limit the route
+// sets.
+const MAX_JSON_BODY = 1 << 20
+
 func ReadJSON(r *http.Request, dst any) error {
-	decoder := json.NewDecoder(r.Body)
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, MAX_JSON_BODY))
 	err := decoder.Decode(&dst)
 	if err != nil {
 		return err
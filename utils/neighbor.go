@@ -2,26 +2,29 @@ package utils
 
 import (
 	"fmt"
-	"log"
 	"net"
 	"os"
 	"regexp"
 	"strconv"
 	"time"
+
+	"github.com/Nico2220/blockchain/logging"
 )
 
+var p2pLog = logging.New(logging.P2P)
+
 var PATTERN = regexp.MustCompile(`((25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?\.){3})(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)`)
 
 func IsFoundHost(host string, port int) bool {
-	target := fmt.Sprintf("%s:%d", host, port)
-	fmt.Println(target)
-
-	_, err := net.DialTimeout("tcp", target, 1*time.Second)
+	target := net.JoinHostPort(host, strconv.Itoa(port))
+	conn, err := net.DialTimeout("tcp", target, 1*time.Second)
 	if err != nil {
-		log.Printf("%s %v\n", target, err)
+		p2pLog.Debug("no neighbor", "target", target, "err", err)
 		return false
 	}
+	conn.Close()
 
+	p2pLog.Debug("neighbor found", "target", target)
 	return true
 }
 func FindNeighbors(myHost string, myPort, startIp, endIp, startPort, endPort int) []string {
@@ -56,12 +59,16 @@ func GetHost() string {
 		return "127.0.0.1"
 	}
 
-	fmt.Println("hostname", hostname)
 	address, err := net.LookupHost(hostname)
 	if err != nil {
 		return "127.0.0.1"
 	}
 
-	fmt.Println("address", address)
-	return address[2]
+	p2pLog.Debug("host addresses", "hostname", hostname, "addresses", address)
+	for _, a := range address {
+		if ip := net.ParseIP(a); ip != nil && ip.To4() != nil {
+			return a
+		}
+	}
+	return "127.0.0.1"
 }
@@ -0,0 +1,42 @@
+// Package version tells which build of the servers is running.
+package version
+
+import "runtime/debug"
+
+// Version is set at build time with
+// -ldflags "-X github.com/Nico2220/blockchain/version.Version=v1.2.3".
+var Version = ""
+
+// String returns Version, or else the module version or VCS revision
+// recorded by the go tool, or "dev".
+func String() string {
+	if Version != "" {
+		return Version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	revision, dirty := "", false
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			dirty = s.Value == "true"
+		}
+	}
+	if revision == "" {
+		return "dev"
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if dirty {
+		revision += "-dirty"
+	}
+	return "dev-" + revision
+}
@@ -1,16 +1,18 @@
 package wallet
 
 import (
+	"crypto/ecdh"
 	"crypto/ecdsa"
 	"crypto/elliptic"
-	"crypto/rand"
-	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math/big"
 
+	"github.com/Nico2220/blockchain/block"
+	"github.com/Nico2220/blockchain/clock"
+	"github.com/Nico2220/blockchain/entropy"
 	"github.com/Nico2220/blockchain/utils"
-	"github.com/btcsuite/btcutil/base58"
-	"golang.org/x/crypto/ripemd160"
 )
 
 type Wallet struct {
@@ -20,50 +22,56 @@ type Wallet struct {
 }
 
 func NewWallet() *Wallet {
-	// 1. Creating ECDSA private key(32 bytes) public key(64 bytes)
+	w, err := NewWalletFrom(entropy.Real)
+	if err != nil {
+		// The system random source doesn't fail.
+		panic(err)
+	}
+	return w
+}
+
+// NewWalletFrom creates a wallet with a key drawn from the random bytes of
+// r, so that a deterministic r gives the same wallet every time.
+func NewWalletFrom(r io.Reader) (*Wallet, error) {
 	w := new(Wallet)
-	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	privateKey, err := generateKey(r)
+	if err != nil {
+		return nil, err
+	}
 	w.privateKey = privateKey
 	w.publicKey = &privateKey.PublicKey
+	w.blockchainAddress = utils.AddressFromPublicKey(w.publicKey)
+	return w, nil
+}
 
-	//2. Perform SHA-256
-	h2 := sha256.New()
-	h2.Write(w.publicKey.X.Bytes())
-	h2.Write(w.publicKey.Y.Bytes())
-	digest2 := h2.Sum(nil)
-
-	//3
-	h3 := ripemd160.New()
-	h3.Write(digest2)
-	digest3 := h3.Sum(nil)
-
-	//4
-	vd4 := make([]byte, 21)
-	vd4[0] = 0x00
-	copy(vd4[1:], digest3[:])
-
-	//5
-	h5 := sha256.New()
-	h5.Write(vd4)
-	digest5 := h5.Sum(nil)
-
-	//6
-	h6 := sha256.New()
-	h6.Write(digest5)
-	digest6 := h6.Sum(nil)
-
-	//7
-	chsum := digest6[:4]
-
-	//8
-	dc8 := make([]byte, 25)
-	copy(dc8[:21], vd4[:])
-	copy(dc8[21:], chsum)
-
-	// 9
-	address := base58.Encode(dc8)
-	w.blockchainAddress = address
-	return w
+// generateKey draws a P-256 private key from r: 64 more bits than the order
+// of the curve are read and reduced so that the key is uniform. Unlike
+// ecdsa.GenerateKey it reads exactly the same bytes every time.
+func generateKey(r io.Reader) (*ecdsa.PrivateKey, error) {
+	curve := elliptic.P256()
+	b := make([]byte, curve.Params().BitSize/8+8)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	one := big.NewInt(1)
+	n := new(big.Int).Sub(curve.Params().N, one)
+	d := new(big.Int).SetBytes(b)
+	d.Mod(d, n).Add(d, one)
+
+	key, err := ecdh.P256().NewPrivateKey(d.FillBytes(make([]byte, 32)))
+	if err != nil {
+		return nil, err
+	}
+	// The uncompressed point is 0x04, X and Y.
+	point := key.PublicKey().Bytes()
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(point[1:33]),
+			Y:     new(big.Int).SetBytes(point[33:]),
+		},
+		D: d,
+	}, nil
 }
 
 func (w *Wallet) PrivateKey() *ecdsa.PrivateKey {
@@ -104,14 +112,30 @@ type Transaction struct {
 	sendBlockchainAddress      string
 	recepientBlockchainAddress string
 	value                      float32
+	timeStamp                  int64
 }
 
+// NewTransaction creates a transaction timestamped by c.
 func NewTransaction(
 	privateKey *ecdsa.PrivateKey,
 	publicKey *ecdsa.PublicKey,
 	sender string,
 	recipient string,
 	value float32,
+	c clock.Clock,
+) *Transaction {
+	return NewTransactionAt(privateKey, publicKey, sender, recipient, value, c.Now().UnixNano())
+}
+
+// NewTransactionAt creates a transaction with a given timestamp. The node
+// must get the same timestamp, which the signature covers.
+func NewTransactionAt(
+	privateKey *ecdsa.PrivateKey,
+	publicKey *ecdsa.PublicKey,
+	sender string,
+	recipient string,
+	value float32,
+	timeStamp int64,
 ) *Transaction {
 	return &Transaction{
 		privateKey,
@@ -119,26 +143,27 @@ func NewTransaction(
 		sender,
 		recipient,
 		value,
+		timeStamp,
 	}
 }
 
+func (t *Transaction) Timestamp() int64 {
+	return t.timeStamp
+}
+
+// GenerateSignature signs the transaction the way the node verifies it, see
+// block.Transaction.Sign.
 func (t *Transaction) GenerateSignature() *utils.Signature {
-	m, _ := t.MarshaJSON()
-	h := sha256.Sum256([]byte(m))
-	r, s, _ := ecdsa.Sign(rand.Reader, t.senderPrivateKey, h[:])
-	return &utils.Signature{R: r, S: s}
+	return t.GenerateSignatureFrom(entropy.Real)
 }
 
-func (t *Transaction) MarshaJSON() ([]byte, error) {
-	return json.Marshal(struct {
-		Sender    string  `json:"sender_blockchain_address"`
-		Recipient string  `json:"recipient_blockchain_address"`
-		Value     float32 `json:"value"`
-	}{
-		Sender:    t.sendBlockchainAddress,
-		Recipient: t.recepientBlockchainAddress,
-		Value:     t.value,
-	})
+// GenerateSignatureFrom signs the transaction with the random bytes of r. It
+// returns nil when r fails. ecdsa.Sign may read one more byte of r or not,
+// so even a Fake does not give the same signature every time.
+func (t *Transaction) GenerateSignatureFrom(r io.Reader) *utils.Signature {
+	bt := block.NewTransactionAt(t.sendBlockchainAddress, t.recepientBlockchainAddress, t.value, t.timeStamp)
+	signature, _ := bt.Sign(t.senderPrivateKey, r)
+	return signature
 }
 
 type TransactionRequest struct {
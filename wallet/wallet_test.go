@@ -0,0 +1,76 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/Nico2220/blockchain/block"
+	"github.com/Nico2220/blockchain/entropy"
+)
+
+func TestNewWalletFromIsDeterministic(t *testing.T) {
+	newWallet := func(seed int64) *Wallet {
+		w, err := NewWalletFrom(entropy.NewFake(seed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	a, b := newWallet(1), newWallet(1)
+	if a.PrivateKeyStr() != b.PrivateKeyStr() || a.BlockchainAddress() != b.BlockchainAddress() {
+		t.Fatalf("the same seed gave %s and %s", a.BlockchainAddress(), b.BlockchainAddress())
+	}
+	if c := newWallet(2); c.BlockchainAddress() == a.BlockchainAddress() {
+		t.Fatalf("seeds 1 and 2 gave the same address %s", a.BlockchainAddress())
+	}
+
+	hash := sha256.Sum256([]byte("message"))
+	sig, err := ecdsa.SignASN1(entropy.NewFake(3), a.PrivateKey(), hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(a.PublicKey(), hash[:], sig) {
+		t.Fatal("the generated key pair does not verify its own signature")
+	}
+}
+
+// TestSignatureVerifies checks that the node accepts what the wallet signs,
+// and only with the timestamp it was signed with.
+func TestSignatureVerifies(t *testing.T) {
+	w, err := NewWalletFrom(entropy.NewFake(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient := "1nV5Np6exVRaZRoWHnJavQsVbDQwYZVPp"
+	tx := NewTransactionAt(w.PrivateKey(), w.PublicKey(), w.BlockchainAddress(), recipient, 1.5, 1735689600000000001)
+	signature := tx.GenerateSignature()
+
+	bc := block.NewBlockchain(recipient, 0, block.RegtestParams())
+	if !bc.VerifyTransactionSignature(w.PublicKey(), signature, block.NewTransactionAt(w.BlockchainAddress(), recipient, 1.5, tx.Timestamp())) {
+		t.Fatal("the node rejected the signature of the wallet")
+	}
+	if bc.VerifyTransactionSignature(w.PublicKey(), signature, block.NewTransactionAt(w.BlockchainAddress(), recipient, 1.5, tx.Timestamp()+1)) {
+		t.Fatal("the signature verified with another timestamp")
+	}
+}
+
+func TestGenerateSignatureFrom(t *testing.T) {
+	w, err := NewWalletFrom(entropy.NewFake(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient := "1nV5Np6exVRaZRoWHnJavQsVbDQwYZVPp"
+	tx := NewTransactionAt(w.PrivateKey(), w.PublicKey(), w.BlockchainAddress(), recipient, 1.5, 1)
+
+	bc := block.NewBlockchain(recipient, 0, block.RegtestParams())
+	signature := tx.GenerateSignatureFrom(entropy.NewFake(2))
+	if signature == nil || !bc.VerifyTransactionSignature(w.PublicKey(), signature, block.NewTransactionAt(w.BlockchainAddress(), recipient, 1.5, 1)) {
+		t.Fatal("the node rejected a signature drawn from a fake")
+	}
+	if signature := tx.GenerateSignatureFrom(bytes.NewReader(nil)); signature != nil {
+		t.Fatalf("signed %s without any random bytes", signature)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Nico2220/blockchain/pb"
+	"github.com/Nico2220/blockchain/wallet"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcWallet serves the Wallet gRPC service. Like the HTTP handlers it talks
+// to the node through the gateway.
+type grpcWallet struct {
+	pb.UnimplementedWalletServer
+	ws *WalletServer
+}
+
+func (ws *WalletServer) GRPCServer() *grpc.Server {
+	s := grpc.NewServer()
+	pb.RegisterWalletServer(s, &grpcWallet{ws: ws})
+	return s
+}
+
+func (g *grpcWallet) CreateWallet(ctx context.Context, req *pb.CreateWalletRequest) (*pb.WalletKeys, error) {
+	w, err := wallet.NewWalletFrom(g.ws.entropy)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.WalletKeys{
+		PrivateKey:        w.PrivateKeyStr(),
+		PublicKey:         w.PublicKeyStr(),
+		BlockchainAddress: w.BlockchainAddress(),
+	}, nil
+}
+
+func (g *grpcWallet) SendTransaction(ctx context.Context, req *pb.WalletTransactionRequest) (*pb.SendTransactionResponse, error) {
+	if req.SenderPrivateKey == "" || req.SenderPublicKey == "" ||
+		req.SenderBlockchainAddress == "" || req.RecipientBlockchainAddress == "" || req.Value <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "cannot process transaction entity")
+	}
+
+	id, code, err := g.ws.sendTransaction(ctx, req.SenderPrivateKey, req.SenderPublicKey,
+		req.SenderBlockchainAddress, req.RecipientBlockchainAddress, req.Value)
+	if err != nil {
+		return nil, status.Error(grpcCode(code), err.Error())
+	}
+	return &pb.SendTransactionResponse{Id: id}, nil
+}
+
+func (g *grpcWallet) GetBalance(ctx context.Context, req *pb.GetBalanceRequest) (*pb.Balance, error) {
+	if req.BlockchainAddress == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing blockchain address")
+	}
+
+	a, code, err := g.ws.fetchAmount(ctx, req.BlockchainAddress)
+	if err != nil {
+		return nil, status.Error(grpcCode(code), err.Error())
+	}
+	return &pb.Balance{Amount: a.Amount, Spendable: a.Spendable, Immature: a.Immature}, nil
+}
+
+// grpcCode maps the status code of a failed gateway call to a gRPC code.
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	}
+	return codes.Internal
+}
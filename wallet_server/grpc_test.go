@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Nico2220/blockchain/block"
+	"github.com/Nico2220/blockchain/clock"
+	"github.com/Nico2220/blockchain/entropy"
+	"github.com/Nico2220/blockchain/pb"
+	"github.com/Nico2220/blockchain/utils"
+	"github.com/Nico2220/blockchain/wallet"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const testBob = "1nV5Np6exVRaZRoWHnJavQsVbDQwYZVPp"
+
+// gateway stands for the node: it adds the transactions it is posted to a
+// chain whose genesis block funds alice, and answers status instead when it
+// is set.
+type gateway struct {
+	*httptest.Server
+	bc *block.Blockchain
+
+	mu     sync.Mutex
+	status int
+}
+
+func newGateway(t *testing.T, alice *wallet.Wallet) *gateway {
+	params := block.RegtestParams()
+	params.Genesis.Allocations = []*block.Allocation{{Address: alice.BlockchainAddress(), Amount: 100}}
+	g := &gateway{bc: block.NewBlockchain(testBob, 0, params)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /transactions", func(w http.ResponseWriter, r *http.Request) {
+		var t block.TransactionRequest
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil || len(t.Validate()) > 0 {
+			utils.WriteJSON(w, http.StatusUnprocessableEntity, wrapper{"error": "invalid transaction"})
+			return
+		}
+		publicKey, _ := utils.PublickKeyFromString(*t.SenderPublicKey)
+		signature, _ := utils.SignatureFromString(*t.Signature)
+		if !g.bc.AddTransactionAt(*t.SenderBlockchainAddress, *t.RecipientBlochainAddress, *t.Value, *t.Timestamp, publicKey, signature) {
+			utils.WriteJSON(w, http.StatusBadRequest, wrapper{"error": "transaction is not created"})
+			return
+		}
+		id := block.NewTransactionAt(*t.SenderBlockchainAddress, *t.RecipientBlochainAddress, *t.Value, *t.Timestamp).Hash()
+		utils.WriteJSON(w, http.StatusCreated, wrapper{"id": fmt.Sprintf("%x", id)})
+	})
+	mux.HandleFunc("GET /amount", func(w http.ResponseWriter, r *http.Request) {
+		b := g.bc.CalculateBalance(r.URL.Query().Get("blockchain_address"))
+		utils.WriteJSON(w, http.StatusOK, &block.AmountResponse{Amount: b.Amount, Spendable: b.Spendable, Immature: b.Immature})
+	})
+	g.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.mu.Lock()
+		status := g.status
+		g.mu.Unlock()
+		if status != 0 {
+			utils.WriteJSON(w, status, wrapper{"error": http.StatusText(status)})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(g.Close)
+	return g
+}
+
+func (g *gateway) answer(status int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.status = status
+}
+
+// dialWallet serves the Wallet service of ws over an in-memory connection
+// and returns a client of it.
+func dialWallet(t *testing.T, ws *WalletServer) pb.WalletClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := ws.GRPCServer()
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewWalletClient(conn)
+}
+
+func wantCode(t *testing.T, name string, err error, code codes.Code) {
+	t.Helper()
+	if status.Code(err) != code {
+		t.Errorf("%s: %v, want %s", name, err, code)
+	}
+}
+
+func TestGRPCWallet(t *testing.T) {
+	alice, _ := wallet.NewWalletFrom(entropy.NewFake(1))
+	gw := newGateway(t, alice)
+	ws := NewWalletServer("", "", gw.URL, block.RegtestParams())
+	ws.entropy = entropy.NewFake(2)
+	epoch := time.Unix(1735689600, 0)
+	ws.clock = clock.NewFake(epoch)
+	client := dialWallet(t, ws)
+	ctx := context.Background()
+
+	keys, err := client.CreateWallet(ctx, &pb.CreateWalletRequest{})
+	want, _ := wallet.NewWalletFrom(entropy.NewFake(2))
+	if err != nil || keys.BlockchainAddress != want.BlockchainAddress() || keys.PublicKey != want.PublicKeyStr() || keys.PrivateKey != want.PrivateKeyStr() {
+		t.Fatalf("created %v %v, want the wallet of the entropy", keys, err)
+	}
+
+	req := &pb.WalletTransactionRequest{
+		SenderPrivateKey:           alice.PrivateKeyStr(),
+		SenderPublicKey:            alice.PublicKeyStr(),
+		SenderBlockchainAddress:    alice.BlockchainAddress(),
+		RecipientBlockchainAddress: testBob,
+		Value:                      1,
+	}
+	sent, err := client.SendTransaction(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := gw.bc.TransactionPool()
+	if len(pool) != 1 || fmt.Sprintf("%x", pool[0].Hash()) != sent.Id {
+		t.Fatalf("sent %s, the gateway holds %d transactions", sent.Id, len(pool))
+	}
+	if pool[0].Timestamp() != epoch.UnixNano() {
+		t.Fatalf("transaction dated %d, want the clock's %d", pool[0].Timestamp(), epoch.UnixNano())
+	}
+
+	balance, err := client.GetBalance(ctx, &pb.GetBalanceRequest{BlockchainAddress: alice.BlockchainAddress()})
+	if err != nil || balance.Amount != 100 || balance.Spendable != 100 {
+		t.Fatalf("balance %v %v", balance, err)
+	}
+
+	_, err = client.SendTransaction(ctx, &pb.WalletTransactionRequest{SenderPublicKey: alice.PublicKeyStr()})
+	wantCode(t, "missing fields", err, codes.InvalidArgument)
+	badKey := &pb.WalletTransactionRequest{
+		SenderPrivateKey:           alice.PrivateKeyStr(),
+		SenderPublicKey:            "00",
+		SenderBlockchainAddress:    alice.BlockchainAddress(),
+		RecipientBlockchainAddress: testBob,
+		Value:                      1,
+	}
+	_, err = client.SendTransaction(ctx, badKey)
+	wantCode(t, "malformed key", err, codes.InvalidArgument)
+	overspend := &pb.WalletTransactionRequest{
+		SenderPrivateKey:           alice.PrivateKeyStr(),
+		SenderPublicKey:            alice.PublicKeyStr(),
+		SenderBlockchainAddress:    alice.BlockchainAddress(),
+		RecipientBlockchainAddress: testBob,
+		Value:                      1000,
+	}
+	_, err = client.SendTransaction(ctx, overspend)
+	wantCode(t, "rejected by the gateway", err, codes.InvalidArgument)
+	_, err = client.GetBalance(ctx, &pb.GetBalanceRequest{})
+	wantCode(t, "no address", err, codes.InvalidArgument)
+
+	gw.answer(http.StatusUnauthorized)
+	_, err = client.SendTransaction(ctx, req)
+	wantCode(t, "unauthorized", err, codes.Unauthenticated)
+	gw.answer(http.StatusServiceUnavailable)
+	_, err = client.GetBalance(ctx, &pb.GetBalanceRequest{BlockchainAddress: alice.BlockchainAddress()})
+	wantCode(t, "gateway unavailable", err, codes.Unavailable)
+
+	gw.Close()
+	_, err = client.SendTransaction(ctx, req)
+	wantCode(t, "gateway down", err, codes.Unavailable)
+	_, err = client.GetBalance(ctx, &pb.GetBalanceRequest{BlockchainAddress: alice.BlockchainAddress()})
+	wantCode(t, "gateway down", err, codes.Unavailable)
+}
+
+func TestGRPCSignatureFailure(t *testing.T) {
+	alice, _ := wallet.NewWalletFrom(entropy.NewFake(1))
+	gw := newGateway(t, alice)
+	ws := NewWalletServer("", "", gw.URL, block.RegtestParams())
+	ws.entropy = bytes.NewReader(nil)
+	client := dialWallet(t, ws)
+
+	_, err := client.SendTransaction(context.Background(), &pb.WalletTransactionRequest{
+		SenderPrivateKey:           alice.PrivateKeyStr(),
+		SenderPublicKey:            alice.PublicKeyStr(),
+		SenderBlockchainAddress:    alice.BlockchainAddress(),
+		RecipientBlockchainAddress: testBob,
+		Value:                      1,
+	})
+	wantCode(t, "no entropy", err, codes.Internal)
+	if n := len(gw.bc.TransactionPool()); n != 0 {
+		t.Fatalf("the gateway got %d transactions", n)
+	}
+}
+
+func TestGRPCCode(t *testing.T) {
+	for httpStatus, want := range map[int]codes.Code{
+		http.StatusBadRequest:          codes.InvalidArgument,
+		http.StatusUnprocessableEntity: codes.InvalidArgument,
+		http.StatusNotFound:            codes.NotFound,
+		http.StatusUnauthorized:        codes.Unauthenticated,
+		http.StatusBadGateway:          codes.Unavailable,
+		http.StatusServiceUnavailable:  codes.Unavailable,
+		http.StatusInternalServerError: codes.Internal,
+		http.StatusTooManyRequests:     codes.ResourceExhausted,
+	} {
+		if got := grpcCode(httpStatus); got != want {
+			t.Errorf("%d: %s, want %s", httpStatus, got, want)
+		}
+	}
+}
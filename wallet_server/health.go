@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Nico2220/blockchain/utils"
+)
+
+const GATEWAY_CHECK_TIMEOUT = 2 * time.Second
+
+// HealthzHandler tells the orchestrator the process is alive.
+func (ws *WalletServer) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, wrapper{"status": "ok"})
+}
+
+// ReadyzHandler answers 503 while the gateway node can't be reached, since
+// every wallet operation goes through it.
+func (ws *WalletServer) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	gateway := "ok"
+	status := http.StatusOK
+	if err := ws.checkGateway(r.Context()); err != nil {
+		gateway = err.Error()
+		status = http.StatusServiceUnavailable
+	}
+	utils.WriteJSON(w, status, wrapper{
+		"ready":  status == http.StatusOK,
+		"checks": map[string]string{"gateway": gateway},
+	})
+}
+
+// checkGateway asks the liveness endpoint of the gateway.
+func (ws *WalletServer) checkGateway(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, GATEWAY_CHECK_TIMEOUT)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ws.gateway+"/healthz", nil)
+	response, err := ws.gatewayDo("healthz", req)
+	if err != nil {
+		return fmt.Errorf("gateway unreachable: %w", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("gateway answered %s", response.Status)
+	}
+	return nil
+}
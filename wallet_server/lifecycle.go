@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// SHUTDOWN_TIMEOUT bounds how long Run waits for the requests in flight
+// once it is asked to stop.
+const SHUTDOWN_TIMEOUT = 15 * time.Second
+
+// Run serves until ctx is done or a server fails, then stops.
+func (ws *WalletServer) Run(ctx context.Context) error {
+	if err := ws.Start(); err != nil {
+		return err
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		apiLog.Info("shutting down")
+	case err = <-ws.serveErr:
+	}
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	return errors.Join(err, ws.Stop(stopCtx))
+}
+
+// Start binds the listen addresses and serves the HTTP and gRPC APIs in the
+// background.
+func (ws *WalletServer) Start() error {
+	addresses := []string{ws.listen}
+	if ws.grpcListen != "" {
+		addresses = append(addresses, ws.grpcListen)
+	}
+	for _, address := range addresses {
+		lis, err := net.Listen("tcp", address)
+		if err != nil {
+			for _, l := range ws.listeners {
+				l.Close()
+			}
+			ws.listeners = nil
+			return err
+		}
+		ws.listeners = append(ws.listeners, lis)
+	}
+
+	ws.serveErr = make(chan error, len(ws.listeners))
+	ws.httpServer = &http.Server{Handler: ws.routes()}
+	go ws.serve(func() error { return ws.httpServer.Serve(ws.listeners[0]) })
+	apiLog.Info("wallet_server running", "listen", ws.Addr(), "network", ws.params.Name, "gateway", ws.gateway)
+	if len(ws.listeners) > 1 {
+		ws.grpcServer = ws.GRPCServer()
+		go ws.serve(func() error { return ws.grpcServer.Serve(ws.listeners[1]) })
+		apiLog.Info("wallet_server gRPC running", "listen", ws.listeners[1].Addr().String())
+	}
+	return nil
+}
+
+// serve reports the errors of a server other than being stopped.
+func (ws *WalletServer) serve(serve func() error) {
+	if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		apiLog.Error("server stopped", "err", err)
+		ws.serveErr <- err
+	}
+}
+
+// Addr returns the address the HTTP API listens on.
+func (ws *WalletServer) Addr() string {
+	return ws.listeners[0].Addr().String()
+}
+
+// Stop drains the HTTP and gRPC requests in flight. When ctx expires first
+// the remaining connections are closed.
+func (ws *WalletServer) Stop(ctx context.Context) error {
+	err := ws.httpServer.Shutdown(ctx)
+	if err != nil {
+		ws.httpServer.Close()
+	}
+	if ws.grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			ws.grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			ws.grpcServer.Stop()
+			<-stopped
+		}
+	}
+	apiLog.Info("wallet_server stopped")
+	return err
+}
@@ -1,22 +1,43 @@
 package main
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
 	"os"
-	"strconv"
-)
+	"os/signal"
+	"syscall"
 
-func init() {
-	log.SetPrefix("Wallet Server: ")
-}
+	"github.com/Nico2220/blockchain/config"
+	"github.com/Nico2220/blockchain/logging"
+)
 
 func main() {
-	port, _ := strconv.Atoi(os.Getenv("port"))
+	cfg, err := config.Load(config.Wallet, os.Args[1:])
+	if config.IsHelp(err) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	logging.Setup(cfg.Logging())
+
+	params, err := cfg.Params()
+	if err != nil {
+		slog.Error("cannot load the network params", "err", err)
+		os.Exit(1)
+	}
 
-	walletServer := NewWalletServer(port, "http://localhost:5001")
+	walletServer := NewWalletServer(cfg.Wallet.Listen, cfg.Wallet.GRPCListen, cfg.Wallet.Gateway, params)
+	walletServer.limits = cfg.Wallet.Limits
 
-	err := walletServer.Run()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = walletServer.Run(ctx)
 	if err != nil {
-		log.Fatal("error starting the server", err)
+		slog.Error("server stopped", "err", err)
+		os.Exit(1)
 	}
 }
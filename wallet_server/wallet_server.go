@@ -3,29 +3,75 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
-	"log"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/Nico2220/blockchain/block"
+	"github.com/Nico2220/blockchain/clock"
+	"github.com/Nico2220/blockchain/config"
+	"github.com/Nico2220/blockchain/entropy"
+	"github.com/Nico2220/blockchain/logging"
+	"github.com/Nico2220/blockchain/metrics"
+	"github.com/Nico2220/blockchain/ratelimit"
 	"github.com/Nico2220/blockchain/utils"
 	"github.com/Nico2220/blockchain/wallet"
+	"google.golang.org/grpc"
 )
 
 type WalletServer struct {
-	port    int
-	gateway string
+	listen     string
+	grpcListen string
+	gateway    string
+	params     *block.NetworkParams
+
+	// entropy is what the keys of new wallets and the signatures of
+	// transactions are drawn from.
+	entropy io.Reader
+	// clock timestamps the transactions and refills the rate limits.
+	clock clock.Clock
+	// limits are the rules of the HTTP routes, by route.
+	limits map[string]ratelimit.Rule
+
+	metrics         *metrics.Registry
+	gatewayFailures *metrics.Counter
+
+	httpServer *http.Server
+	grpcServer *grpc.Server
+	listeners  []net.Listener
+	serveErr   chan error
 }
 
-func NewWalletServer(port int, gateway string) *WalletServer {
-	return &WalletServer{port: port, gateway: gateway}
+func NewWalletServer(listen string, grpcListen string, gateway string, params *block.NetworkParams) *WalletServer {
+	ws := &WalletServer{listen: listen, grpcListen: grpcListen, gateway: gateway, params: params, entropy: entropy.Real,
+		clock: clock.Real, limits: config.DefaultWalletLimits()}
+	ws.metrics = metrics.NewRegistry()
+	ws.gatewayFailures = ws.metrics.NewCounter("wallet_gateway_request_failures_total",
+		"Requests to the gateway node that failed or got a server error, by endpoint.", "endpoint")
+	return ws
 }
 
-func (ws *WalletServer) Port() int {
-	return ws.port
+// gatewayDo sends req to the gateway with the ID of the request it is made
+// for, counting the requests that fail.
+func (ws *WalletServer) gatewayDo(endpoint string, req *http.Request) (*http.Response, error) {
+	logging.SetRequestID(req)
+	response, err := http.DefaultClient.Do(req)
+	if err != nil || response.StatusCode >= http.StatusInternalServerError {
+		ws.gatewayFailures.Inc(endpoint)
+	}
+	return response, err
+}
+
+func (ws *WalletServer) Listen() string {
+	return ws.listen
 }
 
 func (ws *WalletServer) GateWay() string {
@@ -35,7 +81,9 @@ func (ws *WalletServer) GateWay() string {
 func (ws *WalletServer) Index(w http.ResponseWriter, r *http.Request) {
 	t, err := template.ParseFiles("templates/index.html")
 	if err != nil {
-		fmt.Println("error parsing file", err)
+		apiLog.ErrorContext(r.Context(), "cannot parse the index template", "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
 	}
 	t.Execute(w, "")
 
@@ -43,8 +91,15 @@ func (ws *WalletServer) Index(w http.ResponseWriter, r *http.Request) {
 
 type wrapper map[string]any
 
+var apiLog = logging.New(logging.API)
+
 func (ws *WalletServer) CreateWallet(w http.ResponseWriter, r *http.Request) {
-	myWallet := wallet.NewWallet()
+	myWallet, err := wallet.NewWalletFrom(ws.entropy)
+	if err != nil {
+		apiLog.ErrorContext(r.Context(), "cannot create a wallet", "err", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, wrapper{"error": "cannot create a wallet"})
+		return
+	}
 	m, err := myWallet.MarshalJSON()
 	w.Header().Set("Content-Type", "application/json")
 	if err != nil {
@@ -72,87 +127,224 @@ func (ws *WalletServer) CreateTransaction(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	publicKey := utils.PublickKeyFromString(*input.SenderPublickKey)
-	privateKey := utils.PrivateKeyFromString(*input.SenderPrivateKey, publicKey)
 	value, err := strconv.ParseFloat(*input.Value, 32)
 	if err != nil {
 		utils.WriteJSON(w, http.StatusUnprocessableEntity, wrapper{"error": err.Error()})
+		return
 	}
-	value32 := float32(value)
 
-	transaction := wallet.NewTransaction(privateKey, publicKey, *input.SenderBlockchainAddress, *input.RecepientBlockchainAddress, value32)
-	signature := transaction.GenerateSignature()
+	id, code, err := ws.sendTransaction(r.Context(), *input.SenderPrivateKey, *input.SenderPublickKey,
+		*input.SenderBlockchainAddress, *input.RecepientBlockchainAddress, float32(value))
+	if code == http.StatusUnprocessableEntity {
+		utils.WriteJSON(w, code, wrapper{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		apiLog.WarnContext(r.Context(), "cannot send the transaction", "err", err)
+		utils.WriteJSON(w, http.StatusBadRequest, wrapper{"error": "transaction failed :("})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, wrapper{"message": "success", "id": id})
+}
+
+// sendTransaction signs a transfer with the sender keys and posts it to the
+// gateway. It returns the ID the node gave the transaction, or the status
+// code that explains the failure.
+func (ws *WalletServer) sendTransaction(ctx context.Context, privateKeyStr, publicKeyStr, sender, recipient string, value float32) (string, int, error) {
+	publicKey, err := utils.PublickKeyFromString(publicKeyStr)
+	if err != nil {
+		return "", http.StatusUnprocessableEntity, err
+	}
+	privateKey, err := utils.PrivateKeyFromString(privateKeyStr, publicKey)
+	if err != nil {
+		return "", http.StatusUnprocessableEntity, err
+	}
+
+	transaction := wallet.NewTransaction(privateKey, publicKey, sender, recipient, value, ws.clock)
+	signature := transaction.GenerateSignatureFrom(ws.entropy)
+	if signature == nil {
+		return "", http.StatusInternalServerError, errors.New("cannot sign the transaction")
+	}
 	signatureStr := signature.String()
+	timeStamp := transaction.Timestamp()
 
 	bt := block.TransactionRequest{
-		SenderBlockchainAddress:  input.SenderBlockchainAddress,
-		RecipientBlochainAddress: input.RecepientBlockchainAddress,
-		SenderPublicKey:          input.SenderPublickKey,
-		Value:                    &value32,
+		SenderBlockchainAddress:  &sender,
+		RecipientBlochainAddress: &recipient,
+		SenderPublicKey:          &publicKeyStr,
+		Value:                    &value,
 		Signature:                &signatureStr,
+		Timestamp:                &timeStamp,
 	}
-
 	m, _ := json.Marshal(bt)
-	buf := bytes.NewBuffer(m)
 
-	fmt.Println("gateway:", ws.GateWay())
-	rep, err := http.Post(ws.GateWay()+"/transactions", "application/json", buf)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, ws.GateWay()+"/transactions", bytes.NewBuffer(m))
+	req.Header.Set("Content-Type", "application/json")
+	response, err := ws.gatewayDo("transactions", req)
 	if err != nil {
-		utils.WriteJSON(w, http.StatusBadRequest, wrapper{"error": err.Error()})
-	}
-	if rep.StatusCode != http.StatusCreated {
-		utils.WriteJSON(w, http.StatusBadRequest, wrapper{"error": "transaction failed :("})
-		return
+		return "", http.StatusBadGateway, err
 	}
+	defer response.Body.Close()
 
-	utils.WriteJSON(w, http.StatusCreated, wrapper{"message": "success"})
+	if response.StatusCode != http.StatusCreated {
+		return "", response.StatusCode, fmt.Errorf("gateway answered %s", response.Status)
+	}
 
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&created); err != nil {
+		return "", http.StatusBadGateway, err
+	}
+	return created.ID, http.StatusCreated, nil
 }
 
 func (ws *WalletServer) GetAmount(w http.ResponseWriter, r *http.Request) {
+	blockchainAddress := r.URL.Query().Get("blockchain_address")
 
-	client := http.Client{}
-	endpoint := fmt.Sprintf("%s/amount", ws.gateway)
+	a, status, err := ws.fetchAmount(r.Context(), blockchainAddress)
+	if err != nil {
+		apiLog.WarnContext(r.Context(), "cannot get the amount", "err", err)
+		utils.WriteJSON(w, status, wrapper{"error": "cannot get amount"})
+		return
+	}
 
-	blockchainAddress := r.URL.Query().Get("blockchain_address")
+	utils.WriteJSON(w, http.StatusOK, wrapper{"amount": a.Amount, "spendable": a.Spendable, "immature": a.Immature})
+}
 
-	ctx := context.Background()
+// fetchAmount reads the balance of address from the gateway.
+func (ws *WalletServer) fetchAmount(ctx context.Context, address string) (*block.AmountResponse, int, error) {
+	endpoint := fmt.Sprintf("%s/amount", ws.gateway)
 	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
-
 	q := req.URL.Query()
-	q.Add("blockchain_address", blockchainAddress)
+	q.Add("blockchain_address", address)
 	req.URL.RawQuery = q.Encode()
 
-	response, err := client.Do(req)
+	response, err := ws.gatewayDo("amount", req)
 	if err != nil {
-		log.Printf("error: %v", err)
-		utils.WriteJSON(w, http.StatusBadRequest, wrapper{"error": "cannot get amount"})
-		return
+		return nil, http.StatusBadGateway, err
 	}
+	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
-		utils.WriteJSON(w, response.StatusCode, wrapper{"error": "cannot get amount"})
-		return
+		return nil, response.StatusCode, fmt.Errorf("gateway answered %s", response.Status)
 	}
 
 	var a block.AmountResponse
-	err = json.NewDecoder(response.Body).Decode(&a)
+	if err := json.NewDecoder(response.Body).Decode(&a); err != nil {
+		return nil, http.StatusBadGateway, err
+	}
+	return &a, http.StatusOK, nil
+}
+
+const HISTORY_PAGE_LIMIT = 100
+
+// fetchHistory reads a page of the history of address from the gateway.
+func (ws *WalletServer) fetchHistory(ctx context.Context, address, offset, limit string) (*block.HistoryResponse, int, error) {
+	endpoint := fmt.Sprintf("%s/addresses/%s/history", ws.gateway, url.PathEscape(address))
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	q := req.URL.Query()
+	if offset != "" {
+		q.Add("offset", offset)
+	}
+	if limit != "" {
+		q.Add("limit", limit)
+	}
+	req.URL.RawQuery = q.Encode()
+
+	response, err := ws.gatewayDo("history", req)
 	if err != nil {
-		utils.WriteJSON(w, http.StatusBadRequest, wrapper{"error": "cannot decode amount reponse"})
+		return nil, http.StatusBadGateway, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, response.StatusCode, fmt.Errorf("gateway answered %s", response.Status)
+	}
+
+	var h block.HistoryResponse
+	if err := json.NewDecoder(response.Body).Decode(&h); err != nil {
+		return nil, http.StatusBadGateway, err
+	}
+	return &h, http.StatusOK, nil
+}
+
+// GetHistory lists the transfers of an address. With format=csv the whole
+// history is exported as a CSV file.
+func (ws *WalletServer) GetHistory(w http.ResponseWriter, r *http.Request) {
+	blockchainAddress := r.URL.Query().Get("blockchain_address")
+	if blockchainAddress == "" {
+		utils.WriteJSON(w, http.StatusUnprocessableEntity, wrapper{"error": "missing blockchain address"})
+		return
+	}
+
+	if r.URL.Query().Get("format") != "csv" {
+		h, status, err := ws.fetchHistory(r.Context(), blockchainAddress,
+			r.URL.Query().Get("offset"), r.URL.Query().Get("limit"))
+		if err != nil {
+			apiLog.WarnContext(r.Context(), "cannot get the history", "err", err)
+			utils.WriteJSON(w, status, wrapper{"error": "cannot get history"})
+			return
+		}
+		utils.WriteJSON(w, http.StatusOK, wrapper{"history": h.History, "total": h.Total})
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, wrapper{"amount": a.Amount})
+	var entries []*block.HistoryEntry
+	for {
+		h, status, err := ws.fetchHistory(r.Context(), blockchainAddress,
+			strconv.Itoa(len(entries)), strconv.Itoa(HISTORY_PAGE_LIMIT))
+		if err != nil {
+			apiLog.WarnContext(r.Context(), "cannot get the history", "err", err)
+			utils.WriteJSON(w, status, wrapper{"error": "cannot get history"})
+			return
+		}
+		entries = append(entries, h.History...)
+		if len(h.History) == 0 || len(entries) >= h.Total {
+			break
+		}
+	}
 
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"history-%s.csv\"", blockchainAddress))
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "direction", "status", "counterparty", "amount", "height", "confirmations", "timestamp"})
+	for _, e := range entries {
+		cw.Write([]string{
+			e.ID,
+			e.Direction,
+			e.Status,
+			e.Counterparty,
+			strconv.FormatFloat(float64(e.Amount), 'f', -1, 32),
+			strconv.Itoa(e.Height),
+			strconv.Itoa(e.Confirmations),
+			time.Unix(0, e.Timestamp).UTC().Format(time.RFC3339),
+		})
+	}
+	cw.Flush()
 }
 
-func (ws *WalletServer) Run() error {
-	fmt.Println("wallet_server running on:", ws.port)
-	router := http.NewServeMux()
-	router.HandleFunc("/", ws.Index)
+// routes registers the HTTP API, timing and limiting every route.
+func (ws *WalletServer) routes() http.Handler {
+	router := metrics.NewServeMux(ws.metrics)
+	limits := ratelimit.New(ws.limits, ws.clock, ws.metrics)
+	handle := func(pattern string, handler http.HandlerFunc) {
+		router.HandleFunc(pattern, limits.Wrap(pattern, handler))
+	}
+	handle("/", ws.Index)
+
+	handle("POST /transactions", ws.CreateTransaction)
+	handle("POST /wallet", ws.CreateWallet)
+	handle("GET /wallet/amount", ws.GetAmount)
+	handle("GET /wallet/history", ws.GetHistory)
+	handle("GET /metrics", ws.metrics.Handler())
+	handle("GET /healthz", ws.HealthzHandler)
+	handle("GET /readyz", ws.ReadyzHandler)
+
+	for _, route := range limits.Unused() {
+		apiLog.Warn("limits set for an unknown route", "route", route)
+	}
 
-	router.HandleFunc("POST /transactions", ws.CreateTransaction)
-	router.HandleFunc("POST /wallet", ws.CreateWallet)
-	router.HandleFunc("GET /wallet/amount", ws.GetAmount)
-	return http.ListenAndServe(fmt.Sprintf(":%d", ws.port), router)
+	return logging.RequestIDs(logging.Recover(apiLog, router))
 }
@@ -0,0 +1,656 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nico2220/blockchain/clock"
+	"github.com/Nico2220/blockchain/events"
+	"github.com/Nico2220/blockchain/logging"
+)
+
+var webhookLog = logging.New(logging.WEBHOOK)
+
+const (
+	WEBHOOKS_FILE = "webhooks.json"
+	// Every delivery is kept in a file of its own, named after its place in
+	// the queue, so that queuing or attempting one never rewrites the others.
+	QUEUE_DIR = "webhook_queue"
+	DEAD_DIR  = "webhook_dead"
+	// LEGACY_QUEUE_FILE is the single queue file of earlier versions. It is
+	// moved to QUEUE_DIR and DEAD_DIR on start.
+	LEGACY_QUEUE_FILE = "webhook_queue.json"
+
+	EVENT_BUFFER     = 1024
+	DELIVERY_TIMEOUT = 10 * time.Second
+	POLL_INTERVAL    = time.Second
+	MIN_BACKOFF      = time.Second
+	MAX_BACKOFF      = 10 * time.Minute
+	MAX_ATTEMPTS     = 10
+	MAX_DEAD         = 100
+	// MAX_QUEUE is how many deliveries a webhook may have pending. The ones
+	// queued past it go straight to the dead deliveries.
+	MAX_QUEUE = 1000
+
+	SIGNATURE_HEADER = "X-Webhook-Signature"
+	TIMESTAMP_HEADER = "X-Webhook-Timestamp"
+	// SIGNATURE_TOLERANCE is how far from the time of the receiver a signed
+	// timestamp may be.
+	SIGNATURE_TOLERANCE = 5 * time.Minute
+)
+
+var ErrNotFound = errors.New("webhook not found")
+
+// Webhook is a registered endpoint. It receives the events of the listed
+// types, or of every type when Events is empty, and when Addresses is not
+// empty only the events that concern one of them.
+type Webhook struct {
+	ID        string        `json:"id"`
+	URL       string        `json:"url"`
+	Events    []events.Type `json:"events"`
+	Addresses []string      `json:"addresses"`
+	Secret    string        `json:"secret,omitempty"`
+	CreatedAt int64         `json:"created_at"`
+}
+
+func (wh *Webhook) wants(e *events.Event) bool {
+	if len(wh.Events) > 0 {
+		found := false
+		for _, t := range wh.Events {
+			found = found || t == e.Type
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(wh.Addresses) == 0 {
+		return true
+	}
+	for _, a := range wh.Addresses {
+		for _, b := range e.Addresses {
+			if a == b {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type WebhookRequest struct {
+	URL       *string       `json:"url"`
+	Events    []events.Type `json:"events"`
+	Addresses []string      `json:"addresses"`
+	Secret    *string       `json:"secret"`
+}
+
+func (wr *WebhookRequest) Validate() map[string]string {
+	mapError := map[string]string{}
+	if wr.URL == nil {
+		mapError["url"] = "missing value"
+	} else if u, err := url.Parse(*wr.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		mapError["url"] = "must be an http(s) URL"
+	}
+
+	for _, t := range wr.Events {
+		if !t.Valid() {
+			mapError["events"] = fmt.Sprintf("unknown event %q", t)
+		}
+	}
+	return mapError
+}
+
+// Delivery is one event waiting to be posted to one webhook. The payload is
+// kept as sent so that every attempt carries the same body, signed with the
+// time of the attempt.
+type Delivery struct {
+	ID          string          `json:"id"`
+	WebhookID   string          `json:"webhook_id"`
+	Event       events.Type     `json:"event"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt int64           `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+
+	// seq orders the deliveries and names their file.
+	seq uint64
+}
+
+// Manager keeps the webhooks and their delivery queue in the data directory
+// so that pending deliveries survive a restart. A webhook has at most
+// MAX_QUEUE pending deliveries, so that an endpoint that is down can't make
+// the queue grow without bound.
+type Manager struct {
+	dir    string
+	bus    *events.Bus
+	client *http.Client
+	clock  clock.Clock
+
+	mu       sync.Mutex
+	webhooks map[string]*Webhook
+	queue    []*Delivery
+	dead     []*Delivery
+	nextSeq  uint64
+	// busy holds the webhooks whose worker is delivering.
+	busy map[string]bool
+
+	sub  *events.Subscription
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewManager(dir string, bus *events.Bus) (*Manager, error) {
+	m := &Manager{
+		dir:      dir,
+		bus:      bus,
+		client:   &http.Client{Timeout: DELIVERY_TIMEOUT},
+		clock:    clock.Real,
+		webhooks: make(map[string]*Webhook),
+		busy:     make(map[string]bool),
+	}
+
+	var webhooks []*Webhook
+	if err := readJSON(filepath.Join(dir, WEBHOOKS_FILE), &webhooks); err != nil {
+		return nil, err
+	}
+	for _, wh := range webhooks {
+		m.webhooks[wh.ID] = wh
+	}
+
+	for _, name := range []string{QUEUE_DIR, DEAD_DIR} {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0o700); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.migrateQueue(); err != nil {
+		return nil, err
+	}
+	var err error
+	if m.queue, err = m.loadDeliveries(QUEUE_DIR); err != nil {
+		return nil, err
+	}
+	if m.dead, err = m.loadDeliveries(DEAD_DIR); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// loadDeliveries reads the deliveries of dir in queue order.
+func (m *Manager) loadDeliveries(dir string) ([]*Delivery, error) {
+	entries, err := os.ReadDir(filepath.Join(m.dir, dir))
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*Delivery
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		seq, err := strconv.ParseUint(name, 16, 64)
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(m.dir, dir, entry.Name())
+		d := new(Delivery)
+		if err := readJSON(path, d); err != nil {
+			return nil, err
+		}
+		// The files are indented, payloads included: compact them back so
+		// that a delivery resumed after a restart posts the body it was
+		// queued with.
+		var b bytes.Buffer
+		if err := json.Compact(&b, d.Payload); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		d.Payload = b.Bytes()
+		d.seq = seq
+		m.nextSeq = max(m.nextSeq, seq+1)
+		list = append(list, d)
+	}
+	return list, nil
+}
+
+// migrateQueue moves the deliveries of LEGACY_QUEUE_FILE to their own files.
+// The file is removed last: a migration that was interrupted starts over
+// from empty directories.
+func (m *Manager) migrateQueue() error {
+	path := filepath.Join(m.dir, LEGACY_QUEUE_FILE)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	var q struct {
+		Queue []*Delivery `json:"queue"`
+		Dead  []*Delivery `json:"dead"`
+	}
+	if err := readJSON(path, &q); err != nil {
+		return err
+	}
+
+	for _, name := range []string{QUEUE_DIR, DEAD_DIR} {
+		entries, err := os.ReadDir(filepath.Join(m.dir, name))
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := os.Remove(filepath.Join(m.dir, name, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	for i, d := range append(q.Queue, q.Dead...) {
+		d.seq = uint64(i)
+		if err := m.saveDelivery(d, i >= len(q.Queue)); err != nil {
+			return err
+		}
+	}
+	return os.Remove(path)
+}
+
+// SetClock makes the manager read the time from c, which paces the polls
+// and the backoff and timestamps the signatures. Call it before Start.
+func (m *Manager) SetClock(c clock.Clock) {
+	m.clock = c
+}
+
+// Start subscribes to the bus and starts delivering. The subscription misses
+// no event and never holds up the publisher, so every event is queued on
+// disk before it can be lost.
+func (m *Manager) Start() {
+	m.sub = m.bus.SubscribeAll(EVENT_BUFFER)
+	m.stop = make(chan struct{})
+	m.wg.Add(2)
+	go m.enqueueLoop()
+	go m.deliverLoop()
+}
+
+// Stop waits for the deliveries in flight to finish. The queue is already on
+// disk and is resumed by the next Start.
+func (m *Manager) Stop() {
+	m.sub.Unsubscribe()
+	close(m.stop)
+	m.wg.Wait()
+}
+
+func (m *Manager) Register(wr *WebhookRequest) (*Webhook, error) {
+	wh := &Webhook{
+		ID:        randomHex(8),
+		URL:       *wr.URL,
+		Events:    wr.Events,
+		Addresses: wr.Addresses,
+		CreatedAt: m.clock.Now().Unix(),
+	}
+	if wr.Secret != nil && *wr.Secret != "" {
+		wh.Secret = *wr.Secret
+	} else {
+		wh.Secret = randomHex(32)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.webhooks[wh.ID] = wh
+	if err := m.saveWebhooks(); err != nil {
+		delete(m.webhooks, wh.ID)
+		return nil, err
+	}
+	return wh, nil
+}
+
+// Webhooks lists the registered webhooks without their secret.
+func (m *Manager) Webhooks() []*Webhook {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]*Webhook, 0, len(m.webhooks))
+	for _, wh := range m.webhooks {
+		c := *wh
+		c.Secret = ""
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt < list[j].CreatedAt })
+	return list
+}
+
+// Unregister removes a webhook and drops its pending deliveries.
+func (m *Manager) Unregister(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.webhooks[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.webhooks, id)
+
+	if err := m.saveWebhooks(); err != nil {
+		return err
+	}
+
+	queue := m.queue[:0]
+	var err error
+	for _, d := range m.queue {
+		if d.WebhookID != id {
+			queue = append(queue, d)
+		} else if e := m.deleteDelivery(d, false); e != nil && err == nil {
+			err = e
+		}
+	}
+	m.queue = queue
+	return err
+}
+
+// Deliveries returns the pending deliveries and the ones that ran out of
+// attempts.
+func (m *Manager) Deliveries() ([]*Delivery, []*Delivery) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*Delivery{}, m.queue...), append([]*Delivery{}, m.dead...)
+}
+
+func (m *Manager) enqueueLoop() {
+	defer m.wg.Done()
+	for e := range m.sub.C() {
+		m.enqueue(&e)
+	}
+}
+
+func (m *Manager) enqueue(e *events.Event) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		webhookLog.Error("cannot encode event", "type", e.Type, "err", err)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pending := make(map[string]int)
+	for _, d := range m.queue {
+		pending[d.WebhookID]++
+	}
+	for _, wh := range m.webhooks {
+		if !wh.wants(e) {
+			continue
+		}
+		d := &Delivery{
+			ID:          randomHex(8),
+			WebhookID:   wh.ID,
+			Event:       e.Type,
+			Payload:     payload,
+			NextAttempt: m.clock.Now().UnixNano(),
+			seq:         m.sequence(),
+		}
+		if pending[wh.ID] >= MAX_QUEUE {
+			webhookLog.Warn("queue full, dropping delivery", "delivery", d.ID, "url", wh.URL)
+			d.LastError = "queue full"
+			m.bury(d)
+			continue
+		}
+		if err := m.saveDelivery(d, false); err != nil {
+			webhookLog.Error("cannot save the delivery", "delivery", d.ID, "err", err)
+		}
+		m.queue = append(m.queue, d)
+		pending[wh.ID]++
+	}
+}
+
+// deliverLoop hands the due deliveries to a worker per webhook, so that a
+// slow or unreachable endpoint doesn't hold back the others.
+func (m *Manager) deliverLoop() {
+	defer m.wg.Done()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-m.clock.After(POLL_INTERVAL):
+		}
+		for id, due := range m.due() {
+			m.wg.Add(1)
+			go m.deliver(id, due)
+		}
+	}
+}
+
+// deliver attempts the deliveries of webhook id in queue order.
+func (m *Manager) deliver(id string, due []*Delivery) {
+	defer m.wg.Done()
+	defer func() {
+		m.mu.Lock()
+		delete(m.busy, id)
+		m.mu.Unlock()
+	}()
+
+	for _, d := range due {
+		select {
+		case <-m.stop:
+			return
+		default:
+		}
+		m.attempt(d)
+	}
+}
+
+// due returns the deliveries whose next attempt has come by webhook. The
+// webhooks whose worker is still busy are left for a later poll, the others
+// are marked busy.
+func (m *Manager) due() map[string][]*Delivery {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now().UnixNano()
+	due := make(map[string][]*Delivery)
+	for _, d := range m.queue {
+		if d.NextAttempt <= now && !m.busy[d.WebhookID] {
+			due[d.WebhookID] = append(due[d.WebhookID], d)
+		}
+	}
+	for id := range due {
+		m.busy[id] = true
+	}
+	return due
+}
+
+func (m *Manager) attempt(d *Delivery) {
+	m.mu.Lock()
+	wh, ok := m.webhooks[d.WebhookID]
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	err := m.post(wh, d)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d.Attempts++
+	if err == nil {
+		m.remove(d)
+		return
+	}
+	d.LastError = err.Error()
+	if d.Attempts >= MAX_ATTEMPTS {
+		webhookLog.Warn("giving up delivery", "delivery", d.ID, "url", wh.URL, "attempts", d.Attempts, "err", err)
+		m.remove(d)
+		m.bury(d)
+		return
+	}
+	d.NextAttempt = m.clock.Now().Add(Backoff(d.Attempts)).UnixNano()
+	if err := m.saveDelivery(d, false); err != nil {
+		webhookLog.Error("cannot save the delivery", "delivery", d.ID, "err", err)
+	}
+}
+
+func (m *Manager) post(wh *Webhook, d *Delivery) error {
+	req, err := http.NewRequest(http.MethodPost, wh.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", wh.ID)
+	req.Header.Set("X-Webhook-Delivery", d.ID)
+	req.Header.Set("X-Webhook-Event", string(d.Event))
+	timestamp := m.clock.Now().Unix()
+	req.Header.Set(TIMESTAMP_HEADER, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SIGNATURE_HEADER, Sign(wh.Secret, timestamp, d.Payload))
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return nil
+}
+
+// remove takes d out of the queue and deletes its file.
+func (m *Manager) remove(d *Delivery) {
+	for i, q := range m.queue {
+		if q == d {
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+			break
+		}
+	}
+	if err := m.deleteDelivery(d, false); err != nil {
+		webhookLog.Error("cannot delete the delivery", "delivery", d.ID, "err", err)
+	}
+}
+
+// bury adds d to the dead deliveries, forgetting the oldest ones past
+// MAX_DEAD.
+func (m *Manager) bury(d *Delivery) {
+	d.seq = m.sequence()
+	if err := m.saveDelivery(d, true); err != nil {
+		webhookLog.Error("cannot save the dead delivery", "delivery", d.ID, "err", err)
+	}
+	m.dead = append(m.dead, d)
+	for len(m.dead) > MAX_DEAD {
+		if err := m.deleteDelivery(m.dead[0], true); err != nil {
+			webhookLog.Error("cannot delete the dead delivery", "delivery", m.dead[0].ID, "err", err)
+		}
+		m.dead = m.dead[1:]
+	}
+}
+
+func (m *Manager) sequence() uint64 {
+	seq := m.nextSeq
+	m.nextSeq++
+	return seq
+}
+
+func (m *Manager) saveWebhooks() error {
+	list := make([]*Webhook, 0, len(m.webhooks))
+	for _, wh := range m.webhooks {
+		list = append(list, wh)
+	}
+	return writeJSON(filepath.Join(m.dir, WEBHOOKS_FILE), list)
+}
+
+func (m *Manager) deliveryPath(d *Delivery, dead bool) string {
+	dir := QUEUE_DIR
+	if dead {
+		dir = DEAD_DIR
+	}
+	return filepath.Join(m.dir, dir, fmt.Sprintf("%016x.json", d.seq))
+}
+
+func (m *Manager) saveDelivery(d *Delivery, dead bool) error {
+	return writeJSON(m.deliveryPath(d, dead), d)
+}
+
+func (m *Manager) deleteDelivery(d *Delivery, dead bool) error {
+	err := os.Remove(m.deliveryPath(d, dead))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Backoff returns how long to wait before the attempt following the given
+// number of failed ones: it doubles from MIN_BACKOFF up to MAX_BACKOFF.
+func Backoff(attempts int) time.Duration {
+	d := MIN_BACKOFF
+	for i := 1; i < attempts && d < MAX_BACKOFF; i++ {
+		d *= 2
+	}
+	if d > MAX_BACKOFF {
+		d = MAX_BACKOFF
+	}
+	return d
+}
+
+// Sign returns the value of the signature header for payload sent at
+// timestamp, in Unix seconds: "sha256=" followed by the hex HMAC-SHA256 of
+// the timestamp, a dot and the payload keyed by secret.
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature and timestamp headers of a delivery received
+// at now. The timestamp must be within SIGNATURE_TOLERANCE of now, so that a
+// recorded delivery can't be replayed later.
+func Verify(secret string, payload []byte, timestamp, signature string, now time.Time) bool {
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := now.Sub(time.Unix(t, 0)); age > SIGNATURE_TOLERANCE || age < -SIGNATURE_TOLERANCE {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, t, payload)), []byte(signature))
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func readJSON(path string, dst any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, dst); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// writeJSON replaces path atomically so a crash never leaves half a file.
+func writeJSON(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
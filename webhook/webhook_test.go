@@ -0,0 +1,318 @@
+package webhook
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Nico2220/blockchain/clock"
+	"github.com/Nico2220/blockchain/events"
+)
+
+var testEpoch = time.Unix(1735689600, 0)
+
+func TestSignature(t *testing.T) {
+	payload := []byte(`{"type":"newBlock"}`)
+	signature := Sign("secret", testEpoch.Unix(), payload)
+	timestamp := "1735689600"
+
+	if !Verify("secret", payload, timestamp, signature, testEpoch.Add(time.Minute)) {
+		t.Fatal("valid signature rejected")
+	}
+	for name, ok := range map[string]bool{
+		"other secret":    Verify("other", payload, timestamp, signature, testEpoch),
+		"tampered":        Verify("secret", []byte(`{"type":"reorg"}`), timestamp, signature, testEpoch),
+		"other timestamp": Verify("secret", payload, "1735689601", signature, testEpoch),
+		"bad timestamp":   Verify("secret", payload, "now", signature, testEpoch),
+		"replayed":        Verify("secret", payload, timestamp, signature, testEpoch.Add(SIGNATURE_TOLERANCE+time.Second)),
+		"future":          Verify("secret", payload, timestamp, signature, testEpoch.Add(-SIGNATURE_TOLERANCE-time.Second)),
+	} {
+		if ok {
+			t.Errorf("%s signature accepted", name)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  MIN_BACKOFF,
+		2:  2 * MIN_BACKOFF,
+		3:  4 * MIN_BACKOFF,
+		30: MAX_BACKOFF,
+	} {
+		if got := Backoff(attempts); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+// endpoint records the deliveries it receives and answers them with the
+// status status returns for the number of the request.
+type endpoint struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	received []*http.Request
+	bodies   [][]byte
+	status   func(n int) int
+}
+
+func newEndpoint(t *testing.T, status func(n int) int) *endpoint {
+	e := &endpoint{status: status}
+	e.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		e.mu.Lock()
+		e.received = append(e.received, r)
+		e.bodies = append(e.bodies, body)
+		n := len(e.received)
+		e.mu.Unlock()
+		w.WriteHeader(e.status(n))
+	}))
+	t.Cleanup(e.Close)
+	return e
+}
+
+func (e *endpoint) hits() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.received)
+}
+
+func (e *endpoint) request(n int) (*http.Request, []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.received[n], e.bodies[n]
+}
+
+// queued returns a copy of the pending deliveries once no worker is busy.
+func queued(m *Manager) ([]Delivery, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.busy) > 0 {
+		return nil, false
+	}
+	var list []Delivery
+	for _, d := range m.queue {
+		list = append(list, *d)
+	}
+	return list, true
+}
+
+// waitFor returns the pending deliveries once cond holds for them.
+func waitFor(t *testing.T, m *Manager, cond func([]Delivery) bool) []Delivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if list, ok := queued(m); ok && cond(list) {
+			return list
+		}
+		time.Sleep(time.Millisecond)
+	}
+	list, _ := queued(m)
+	t.Fatalf("timed out, %d deliveries pending", len(list))
+	return nil
+}
+
+func pending(n int) func([]Delivery) bool {
+	return func(list []Delivery) bool { return len(list) == n }
+}
+
+func attempted(n int) func([]Delivery) bool {
+	return func(list []Delivery) bool { return len(list) == 1 && list[0].Attempts == n }
+}
+
+func startManager(t *testing.T, dir string, fake *clock.Fake) (*Manager, *events.Bus) {
+	t.Helper()
+	bus := events.NewBus()
+	m, err := NewManager(dir, bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SetClock(fake)
+	m.Start()
+	return m, bus
+}
+
+// poll advances the clock by d once the delivery loop waits on it.
+func poll(fake *clock.Fake, d time.Duration) {
+	fake.BlockUntil(1)
+	fake.Advance(d)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	endpoint := newEndpoint(t, func(n int) int {
+		if n <= 2 {
+			return http.StatusInternalServerError
+		}
+		return http.StatusNoContent
+	})
+	fake := clock.NewFake(testEpoch)
+	m, bus := startManager(t, t.TempDir(), fake)
+	defer m.Stop()
+
+	secret := "secret"
+	wh, err := m.Register(&WebhookRequest{URL: &endpoint.URL, Secret: &secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.Publish(events.Event{Type: events.NEW_BLOCK, Height: 1, Time: 1})
+	waitFor(t, m, pending(1))
+
+	poll(fake, POLL_INTERVAL)
+	d := waitFor(t, m, attempted(1))[0]
+	if want := fake.Now().Add(MIN_BACKOFF).UnixNano(); d.NextAttempt != want {
+		t.Fatalf("next attempt at %d after one failure, want %d", d.NextAttempt, want)
+	}
+
+	poll(fake, MIN_BACKOFF)
+	d = waitFor(t, m, attempted(2))[0]
+	if want := fake.Now().Add(2 * MIN_BACKOFF).UnixNano(); d.NextAttempt != want {
+		t.Fatalf("next attempt at %d after two failures, want %d", d.NextAttempt, want)
+	}
+
+	poll(fake, MIN_BACKOFF)
+	fake.BlockUntil(1)
+	if n := endpoint.hits(); n != 2 {
+		t.Fatalf("delivery attempted %d times before its backoff ran out, want 2", n)
+	}
+
+	poll(fake, MIN_BACKOFF)
+	waitFor(t, m, pending(0))
+	if n := endpoint.hits(); n != 3 {
+		t.Fatalf("%d attempts, want 3", n)
+	}
+
+	for i := 0; i < endpoint.hits(); i++ {
+		r, body := endpoint.request(i)
+		if r.Header.Get("X-Webhook-ID") != wh.ID || r.Header.Get("X-Webhook-Delivery") != d.ID {
+			t.Errorf("attempt %d has webhook %q and delivery %q", i, r.Header.Get("X-Webhook-ID"), r.Header.Get("X-Webhook-Delivery"))
+		}
+		if !Verify(secret, body, r.Header.Get(TIMESTAMP_HEADER), r.Header.Get(SIGNATURE_HEADER), fake.Now()) {
+			t.Errorf("attempt %d is not signed", i)
+		}
+	}
+	if _, dead := m.Deliveries(); len(dead) != 0 {
+		t.Errorf("%d dead deliveries", len(dead))
+	}
+}
+
+func TestRedeliveryAfterRestart(t *testing.T) {
+	var up bool
+	var mu sync.Mutex
+	endpoint := newEndpoint(t, func(int) int {
+		mu.Lock()
+		defer mu.Unlock()
+		if !up {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	dir := t.TempDir()
+	fake := clock.NewFake(testEpoch)
+	m, bus := startManager(t, dir, fake)
+
+	if _, err := m.Register(&WebhookRequest{URL: &endpoint.URL}); err != nil {
+		t.Fatal(err)
+	}
+	bus.Publish(events.Event{Type: events.NEW_PENDING_TX, Time: 1})
+	waitFor(t, m, pending(1))
+	poll(fake, POLL_INTERVAL)
+	before := waitFor(t, m, attempted(1))[0]
+	m.Stop()
+
+	mu.Lock()
+	up = true
+	mu.Unlock()
+
+	// The stopped manager left its wait on fake behind.
+	fake = clock.NewFake(fake.Now())
+	m, _ = startManager(t, dir, fake)
+	defer m.Stop()
+	after := waitFor(t, m, pending(1))[0]
+	if after.ID != before.ID || string(after.Payload) != string(before.Payload) || after.Attempts != 1 {
+		t.Fatalf("restarted with delivery %s attempt %d, want %s attempt 1", after.ID, after.Attempts, before.ID)
+	}
+
+	poll(fake, MIN_BACKOFF)
+	waitFor(t, m, pending(0))
+	if n := endpoint.hits(); n != 2 {
+		t.Fatalf("%d attempts, want 2", n)
+	}
+	r, body := endpoint.request(1)
+	if r.Header.Get("X-Webhook-Delivery") != before.ID || string(body) != string(before.Payload) {
+		t.Fatalf("redelivered %s %s, want %s %s", r.Header.Get("X-Webhook-Delivery"), body, before.ID, before.Payload)
+	}
+}
+
+func TestQueueCap(t *testing.T) {
+	dir := t.TempDir()
+	fake := clock.NewFake(testEpoch)
+	m, bus := startManager(t, dir, fake)
+	url := "http://127.0.0.1:1/hook"
+	if _, err := m.Register(&WebhookRequest{URL: &url}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < MAX_QUEUE+3; i++ {
+		bus.Publish(events.Event{Type: events.NEW_BLOCK, Height: i, Time: 1})
+	}
+	queue := waitFor(t, m, func(list []Delivery) bool {
+		_, dead := m.Deliveries()
+		return len(list) == MAX_QUEUE && len(dead) == 3
+	})
+	m.Stop()
+
+	m, err := NewManager(dir, events.NewBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, dead := m.Deliveries()
+	if len(reloaded) != MAX_QUEUE || len(dead) != 3 {
+		t.Fatalf("reloaded %d pending and %d dead deliveries, want %d and 3", len(reloaded), len(dead), MAX_QUEUE)
+	}
+	for i, d := range reloaded {
+		if d.ID != queue[i].ID {
+			t.Fatalf("delivery %d reloaded as %s, want %s", i, d.ID, queue[i].ID)
+		}
+	}
+	if dead[0].LastError != "queue full" || string(dead[2].Payload) != `{"type":"newBlock","height":1002,"time":1}` {
+		t.Fatalf("dead deliveries %s %s", dead[0].LastError, dead[2].Payload)
+	}
+}
+
+func TestMigrateQueue(t *testing.T) {
+	dir := t.TempDir()
+	legacy := `{
+	"queue": [
+		{"id": "a", "webhook_id": "w", "event": "newBlock", "payload": {"type": "newBlock", "height": 1}, "attempts": 2},
+		{"id": "b", "webhook_id": "w", "event": "newBlock", "payload": {"type": "newBlock", "height": 2}}
+	],
+	"dead": [
+		{"id": "c", "webhook_id": "w", "event": "reorg", "payload": {"type": "reorg"}, "attempts": 10}
+	]
+}`
+	if err := os.WriteFile(filepath.Join(dir, LEGACY_QUEUE_FILE), []byte(legacy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		m, err := NewManager(dir, events.NewBus())
+		if err != nil {
+			t.Fatal(err)
+		}
+		queue, dead := m.Deliveries()
+		if len(queue) != 2 || queue[0].ID != "a" || queue[0].Attempts != 2 || queue[1].ID != "b" ||
+			string(queue[1].Payload) != `{"type":"newBlock","height":2}` || len(dead) != 1 || dead[0].ID != "c" {
+			t.Fatalf("start %d: queue %v, dead %v", i, queue, dead)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, LEGACY_QUEUE_FILE)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("%s still there: %v", LEGACY_QUEUE_FILE, err)
+	}
+}